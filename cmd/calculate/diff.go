@@ -0,0 +1,308 @@
+package calculate
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Compare two similar runs and report ranking drift",
+	Long: `
+Compare the similar results of two runs, each given as either a sqlite database with a SIMILAR table (e.g. data/data.db)
+or an exported data/similar/ folder, and report how much the recommendation lists changed.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDiff,
+}
+
+func init() {
+	calculateCmd.AddCommand(diffCmd)
+	diffCmd.Flags().IntP("top", "n", 20, "Number of most changed titles to list")
+	diffCmd.Flags().Float64P("persistence", "p", 0.9, "Rank biased overlap persistence, higher looks deeper into the lists")
+	diffCmd.Flags().BoolP("json", "j", false, "Print the report as json")
+}
+
+type diffReport struct {
+	TitlesOld          int               `json:"titlesOld"`
+	TitlesNew          int               `json:"titlesNew"`
+	TitlesCompared     int               `json:"titlesCompared"`
+	TitlesUnchanged    int               `json:"titlesUnchanged"`
+	MeanJaccard        float64           `json:"meanJaccard"`
+	MeanRBO            float64           `json:"meanRbo"`
+	MeanKendallTau     float64           `json:"meanKendallTau"`
+	LostAllMatches     []string          `json:"lostAllMatches"`
+	GainedAllMatches   []string          `json:"gainedAllMatches"`
+	MostChanged        []titleDiff       `json:"mostChanged"`
+	ScoreOld           scoreDistribution `json:"scoreOld"`
+	ScoreNew           scoreDistribution `json:"scoreNew"`
+	kendallTauMeasured int
+}
+
+type titleDiff struct {
+	Id         string   `json:"id"`
+	Title      string   `json:"title"`
+	Jaccard    float64  `json:"jaccard"`
+	RBO        float64  `json:"rbo"`
+	KendallTau *float64 `json:"kendallTau,omitempty"`
+	NumOld     int      `json:"numOld"`
+	NumNew     int      `json:"numNew"`
+}
+
+type scoreDistribution struct {
+	NumMatches int     `json:"numMatches"`
+	Mean       float64 `json:"mean"`
+	Median     float64 `json:"median"`
+	// Count of match scores in [0.0, 0.1), [0.1, 0.2) ... [0.9, 1.0]
+	Histogram [10]int `json:"histogram"`
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	topN, _ := cmd.Flags().GetInt("top")
+	persistence, _ := cmd.Flags().GetFloat64("persistence")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	if persistence <= 0 || persistence >= 1 {
+		log.Fatal("persistence must be between 0 and 1")
+	}
+
+	// The summary goes to stderr so --json output stays valid
+	summary := internal.NewErrorSummary()
+	defer summary.Fprint(os.Stderr)
+	oldRun, err := loadSimilarRun(args[0])
+	summary.CheckErr(summary.SkipRows("reading "+args[0], err))
+	newRun, err := loadSimilarRun(args[1])
+	summary.CheckErr(summary.SkipRows("reading "+args[1], err))
+
+	report := diffReport{
+		TitlesOld:        len(oldRun),
+		TitlesNew:        len(newRun),
+		LostAllMatches:   []string{},
+		GainedAllMatches: []string{},
+		ScoreOld:         newScoreDistribution(oldRun),
+		ScoreNew:         newScoreDistribution(newRun),
+	}
+
+	var titleDiffs []titleDiff
+	for id, oldManga := range oldRun {
+		newManga, ok := newRun[id]
+		if !ok || len(newManga.SimilarMatches) == 0 {
+			if len(oldManga.SimilarMatches) > 0 {
+				report.LostAllMatches = append(report.LostAllMatches, id)
+			}
+			continue
+		}
+		if len(oldManga.SimilarMatches) == 0 {
+			continue
+		}
+
+		oldIds := similarMatchIds(oldManga)
+		newIds := similarMatchIds(newManga)
+		diff := titleDiff{
+			Id:      id,
+			Title:   newManga.Title["en"],
+			Jaccard: similar.Jaccard(oldIds, newIds),
+			RBO:     similar.RankBiasedOverlap(oldIds, newIds, persistence),
+			NumOld:  len(oldIds),
+			NumNew:  len(newIds),
+		}
+		if tau, ok := similar.KendallTau(oldIds, newIds); ok {
+			diff.KendallTau = &tau
+			report.MeanKendallTau += tau
+			report.kendallTauMeasured++
+		}
+		if strings.Join(oldIds, ",") == strings.Join(newIds, ",") {
+			report.TitlesUnchanged++
+		}
+		report.MeanJaccard += diff.Jaccard
+		report.MeanRBO += diff.RBO
+		titleDiffs = append(titleDiffs, diff)
+	}
+	for id, newManga := range newRun {
+		oldManga, ok := oldRun[id]
+		if len(newManga.SimilarMatches) > 0 && (!ok || len(oldManga.SimilarMatches) == 0) {
+			report.GainedAllMatches = append(report.GainedAllMatches, id)
+		}
+	}
+	sort.Strings(report.LostAllMatches)
+	sort.Strings(report.GainedAllMatches)
+
+	report.TitlesCompared = len(titleDiffs)
+	if report.TitlesCompared > 0 {
+		report.MeanJaccard /= float64(report.TitlesCompared)
+		report.MeanRBO /= float64(report.TitlesCompared)
+	}
+	if report.kendallTauMeasured > 0 {
+		report.MeanKendallTau /= float64(report.kendallTauMeasured)
+	}
+
+	// Most changed first, ties broken by id so reports are stable
+	sort.Slice(titleDiffs, func(i, j int) bool {
+		if titleDiffs[i].RBO == titleDiffs[j].RBO {
+			return titleDiffs[i].Id < titleDiffs[j].Id
+		}
+		return titleDiffs[i].RBO < titleDiffs[j].RBO
+	})
+	if len(titleDiffs) > topN {
+		titleDiffs = titleDiffs[:topN]
+	}
+	report.MostChanged = titleDiffs
+
+	if jsonOutput {
+		jsonReport, err := json.MarshalIndent(report, "", "  ")
+		internal.CheckErr(err)
+		fmt.Println(string(jsonReport))
+		return
+	}
+	printDiffReport(report)
+}
+
+func printDiffReport(report diffReport) {
+	fmt.Printf("Titles with matches: %d old, %d new, %d in both\n", report.TitlesOld, report.TitlesNew, report.TitlesCompared)
+	fmt.Printf("Titles with identical lists: %d\n\n", report.TitlesUnchanged)
+	fmt.Printf("Mean jaccard overlap:       %.4f\n", report.MeanJaccard)
+	fmt.Printf("Mean rank biased overlap:   %.4f\n", report.MeanRBO)
+	fmt.Printf("Mean kendall tau (shared):  %.4f\n\n", report.MeanKendallTau)
+
+	fmt.Printf("Scores           %10s %10s\n", "old", "new")
+	fmt.Printf("  matches        %10d %10d\n", report.ScoreOld.NumMatches, report.ScoreNew.NumMatches)
+	fmt.Printf("  mean           %10.4f %10.4f\n", report.ScoreOld.Mean, report.ScoreNew.Mean)
+	fmt.Printf("  median         %10.4f %10.4f\n", report.ScoreOld.Median, report.ScoreNew.Median)
+	for i := range report.ScoreOld.Histogram {
+		fmt.Printf("  [%.1f, %.1f)     %10d %10d\n", float64(i)/10, float64(i+1)/10, report.ScoreOld.Histogram[i], report.ScoreNew.Histogram[i])
+	}
+	fmt.Println()
+
+	fmt.Printf("Lost all matches (%d):\n", len(report.LostAllMatches))
+	for _, id := range report.LostAllMatches {
+		fmt.Printf("  - https://mangadex.org/title/%s\n", id)
+	}
+	fmt.Printf("Gained matches (%d):\n", len(report.GainedAllMatches))
+	for _, id := range report.GainedAllMatches {
+		fmt.Printf("  - https://mangadex.org/title/%s\n", id)
+	}
+	fmt.Println()
+
+	fmt.Printf("Most changed titles:\n")
+	for _, diff := range report.MostChanged {
+		tau := "n/a"
+		if diff.KendallTau != nil {
+			tau = fmt.Sprintf("%.3f", *diff.KendallTau)
+		}
+		fmt.Printf("  | %.3f rbo, %.3f jaccard, %s tau, %d -> %d matches -> %s - https://mangadex.org/title/%s\n",
+			diff.RBO, diff.Jaccard, tau, diff.NumOld, diff.NumNew, truncateText(diff.Title, 30), diff.Id)
+	}
+}
+
+func similarMatchIds(similarManga internal.SimilarManga) []string {
+	ids := make([]string, 0, len(similarManga.SimilarMatches))
+	for _, match := range similarManga.SimilarMatches {
+		ids = append(ids, match.Id)
+	}
+	return ids
+}
+
+func newScoreDistribution(run map[string]internal.SimilarManga) scoreDistribution {
+	distribution := scoreDistribution{}
+	var scores []float64
+	for _, similarManga := range run {
+		for _, match := range similarManga.SimilarMatches {
+			score := float64(match.Score)
+			scores = append(scores, score)
+			distribution.Mean += score
+			bucket := int(score * 10)
+			if bucket > 9 {
+				bucket = 9
+			}
+			if bucket < 0 {
+				bucket = 0
+			}
+			distribution.Histogram[bucket]++
+		}
+	}
+	distribution.NumMatches = len(scores)
+	if len(scores) > 0 {
+		distribution.Mean /= float64(len(scores))
+		sort.Float64s(scores)
+		distribution.Median = scores[len(scores)/2]
+	}
+	return distribution
+}
+
+// Loads the similar results from a sqlite database file or an exported data/similar/ folder
+// Entries which can't be read are skipped and returned joined as *internal.RowError, alongside the rest
+func loadSimilarRun(path string) (map[string]internal.SimilarManga, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	run := map[string]internal.SimilarManga{}
+	var rowErrs []error
+	addEntry := func(uuid string, jsonSimilar string) {
+		similarManga := internal.SimilarManga{}
+		if err := json.Unmarshal([]byte(jsonSimilar), &similarManga); err != nil {
+			rowErrs = append(rowErrs, &internal.RowError{Table: path, UUID: uuid, Err: err})
+			return
+		}
+		run[uuid] = similarManga
+	}
+
+	if !info.IsDir() {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		rows, err := db.Query("SELECT UUID, JSON FROM " + internal.TableSimilar)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var uuid, jsonSimilar string
+			if err := rows.Scan(&uuid, &jsonSimilar); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+			addEntry(uuid, jsonSimilar)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return run, errors.Join(rowErrs...)
+	}
+
+	err = filepath.WalkDir(path, func(filePath string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		for scanner.Scan() {
+			split := strings.Split(scanner.Text(), ":::||@!@||:::")
+			if len(split) == 2 {
+				addEntry(split[0], split[1])
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, errors.Join(rowErrs...)
+}
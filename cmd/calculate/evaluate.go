@@ -0,0 +1,174 @@
+package calculate
+
+import (
+	"encoding/json"
+	"fmt"
+	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var evaluateCmd = &cobra.Command{
+	Use:   "evaluate",
+	Short: "Measure the quality of the similar results against a judged dataset",
+	Long: `
+Calculate similar manga for every query in a judged dataset and report precision@k, recall@k, nDCG@k and MRR.
+The judged dataset is a csv file of "queryUUID,matchUUID,relevance" lines, where a relevance of 0 means not similar.
+Nothing is written to the database.`,
+	Run: runEvaluate,
+}
+
+func init() {
+	calculateCmd.AddCommand(evaluateCmd)
+	evaluateCmd.Flags().StringP("judgements", "j", "", "Csv file of judged manga pairs")
+	evaluateCmd.Flags().BoolP("related", "r", false, "Use related manga as weak positives (relevance 1) for pairs which are not judged")
+	evaluateCmd.Flags().IntP("k", "k", 10, "Cut off rank for precision, recall and nDCG")
+	evaluateCmd.Flags().StringP("config", "c", "data/similar_config.json", "Config file with the similar weights and thresholds")
+	evaluateCmd.Flags().StringP("index", "x", "inverted", "Candidate index to use, either inverted (approximate) or brute (exact pairwise)")
+	evaluateCmd.Flags().Int("candidates", 500, "Number of candidates the inverted index, and the lsa index if lsaCandidates is set, return for each manga")
+	evaluateCmd.Flags().Int("max-posting", 5000, "Ignore index terms shared by more than this many manga")
+	evaluateCmd.Flags().StringP("output", "o", "", "Also write the report as json to this file")
+	evaluateCmd.Flags().Float64("min-ndcg", 0, "Exit with an error if nDCG is below this value")
+}
+
+type evaluationReport struct {
+	ConfigHash     string                  `json:"configHash"`
+	Metrics        similar.RankingMetrics  `json:"metrics"`
+	MissingQueries []string                `json:"missingQueries,omitempty"`
+	Queries        map[string]queryMetrics `json:"queries"`
+}
+
+type queryMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	NDCG      float64 `json:"ndcg"`
+	MRR       float64 `json:"mrr"`
+}
+
+func runEvaluate(cmd *cobra.Command, args []string) {
+	startProcessing := time.Now()
+
+	judgementsFile, _ := cmd.Flags().GetString("judgements")
+	useRelated, _ := cmd.Flags().GetBool("related")
+	k, _ := cmd.Flags().GetInt("k")
+	configFile, _ := cmd.Flags().GetString("config")
+	indexMode, _ := cmd.Flags().GetString("index")
+	numCandidates, _ := cmd.Flags().GetInt("candidates")
+	maxPostingLength, _ := cmd.Flags().GetInt("max-posting")
+	outputFile, _ := cmd.Flags().GetString("output")
+	minNDCG, _ := cmd.Flags().GetFloat64("min-ndcg")
+
+	if judgementsFile == "" && !useRelated {
+		log.Fatal("either --judgements or --related is needed to evaluate")
+	}
+	if k < 1 {
+		log.Fatal("k must be at least 1")
+	}
+
+	config, err := similar.LoadConfig(configFile)
+	internal.CheckErr(err)
+	fmt.Printf("Evaluating similar config %s (hash %s) at k=%d\n", configFile, config.Hash(), k)
+
+	judgements := similar.Judgements{}
+	if judgementsFile != "" {
+		judgements, err = similar.LoadJudgements(judgementsFile)
+		internal.CheckErr(err)
+	}
+
+	// Rank deep enough that every cut off can be measured
+	if config.NumSimToGet < k {
+		config.NumSimToGet = k
+	}
+	summary := internal.NewErrorSummary()
+	defer summary.Fprint(os.Stderr)
+	mangaList, err := internal.GetAllManga()
+	summary.CheckErr(summary.SkipRows("reading manga", err))
+	corpus, err := buildSimilarCorpus(mangaList, config, nil)
+	summary.CheckErr(err)
+
+	// Related manga are never valid matches, so they are removed from the corpus when used as positives
+	if useRelated {
+		for i, manga := range corpus.mangaList {
+			for _, relatedId := range manga.RelatedIds {
+				if _, judged := judgements[manga.Id][relatedId]; !judged {
+					judgements.Add(manga.Id, relatedId, 1)
+				}
+			}
+			corpus.mangaList[i].RelatedIds = nil
+			corpus.mangaList[i].Relations = nil
+		}
+	}
+
+	index, err := corpus.candidateIndex(indexMode, numCandidates, maxPostingLength)
+	summary.CheckErr(err)
+
+	report := evaluationReport{
+		ConfigHash: config.Hash(),
+		Metrics:    similar.RankingMetrics{K: k},
+		Queries:    map[string]queryMetrics{},
+	}
+
+	var queryIds []string
+	for queryId := range judgements {
+		if _, ok := corpus.mangaIndex[queryId]; ok {
+			queryIds = append(queryIds, queryId)
+		} else {
+			report.MissingQueries = append(report.MissingQueries, queryId)
+		}
+	}
+	sort.Strings(queryIds)
+	sort.Strings(report.MissingQueries)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(queryIds))
+	maxGoroutines := 100
+	guard := make(chan struct{}, maxGoroutines)
+
+	for _, queryId := range queryIds {
+
+		// would block if guard channel is already filled
+		guard <- struct{}{}
+		go func(queryId string) {
+			defer wg.Done()
+
+			currentMangaIndex := corpus.mangaIndex[queryId]
+			var ranked []string
+			for _, match := range corpus.findMatches(currentMangaIndex, index.Candidates(currentMangaIndex), nil) {
+				ranked = append(ranked, corpus.mangaList[match.ID.(int)].Id)
+			}
+
+			metrics, ok := similar.EvaluateRanking(ranked, judgements[queryId], k)
+			if ok {
+				mutex.Lock()
+				report.Metrics.Accumulate(metrics)
+				report.Queries[queryId] = queryMetrics{Precision: metrics.Precision, Recall: metrics.Recall, NDCG: metrics.NDCG, MRR: metrics.MRR}
+				mutex.Unlock()
+			}
+			<-guard
+		}(queryId)
+	}
+	wg.Wait()
+
+	fmt.Printf("\nEvaluated %d queries in %s (%d judged queries are not in the corpus)\n", report.Metrics.NumQueries, time.Since(startProcessing), len(report.MissingQueries))
+	fmt.Printf("  precision@%d = %.4f\n", k, report.Metrics.Precision)
+	fmt.Printf("  recall@%d    = %.4f\n", k, report.Metrics.Recall)
+	fmt.Printf("  nDCG@%d      = %.4f\n", k, report.Metrics.NDCG)
+	fmt.Printf("  MRR          = %.4f\n\n", report.Metrics.MRR)
+
+	if outputFile != "" {
+		jsonReport, err := json.MarshalIndent(report, "", "  ")
+		internal.CheckErr(err)
+		err = os.WriteFile(outputFile, jsonReport, 0777)
+		internal.CheckErr(err)
+	}
+
+	if report.Metrics.NDCG < minNDCG {
+		log.Fatalf("\u001B[1;31mnDCG@%d of %.4f is below the minimum of %.4f\u001B[0m\n", k, report.Metrics.NDCG, minNDCG)
+	}
+}
@@ -0,0 +1,212 @@
+package calculate
+
+import (
+	"fmt"
+	"github.com/james-bowman/nlp/measures/pairwise"
+	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"sort"
+	"strings"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <uuidA> <uuidB>",
+	Short: "Explain why manga B was (or was not) matched to manga A",
+	Long: `
+Show the description terms, tags, override rules and match rules which decided the score of manga B as a match for manga A.
+Matching is not symmetric, so swap the arguments to explain the other direction.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runExplain,
+}
+
+func init() {
+	calculateCmd.AddCommand(explainCmd)
+	explainCmd.Flags().StringP("config", "c", "data/similar_config.json", "Config file with the similar weights and thresholds")
+	explainCmd.Flags().IntP("terms", "n", 15, "Number of top contributing description terms to show")
+}
+
+type termContribution struct {
+	term         string
+	weight       float64
+	weightOther  float64
+	contribution float64
+}
+
+func runExplain(cmd *cobra.Command, args []string) {
+	configFile, _ := cmd.Flags().GetString("config")
+	numTerms, _ := cmd.Flags().GetInt("terms")
+
+	config, err := similar.LoadConfig(configFile)
+	internal.CheckErr(err)
+
+	summary := internal.NewErrorSummary()
+	defer summary.Print()
+	mangaList, err := internal.GetAllManga()
+	summary.CheckErr(summary.SkipRows("reading manga", err))
+	corpus, err := buildSimilarCorpus(mangaList, config, nil)
+	summary.CheckErr(err)
+	currentMangaIndex, ok := corpus.mangaIndex[args[0]]
+	if !ok {
+		summary.CheckErr(fmt.Errorf("manga %s is not in the corpus", args[0]))
+	}
+	matchMangaIndex, ok := corpus.mangaIndex[args[1]]
+	if !ok {
+		summary.CheckErr(fmt.Errorf("manga %s is not in the corpus", args[1]))
+	}
+	currentManga := corpus.mangaList[currentMangaIndex]
+	matchManga := corpus.mangaList[matchMangaIndex]
+
+	fmt.Printf("Explaining match of config %s (hash %s)\n", configFile, config.Hash())
+	fmt.Printf("  A: %s - https://mangadex.org/title/%s\n", (*currentManga.Title)["en"], currentManga.Id)
+	fmt.Printf("  B: %s - https://mangadex.org/title/%s\n\n", (*matchManga.Title)["en"], matchManga.Id)
+
+	// Raw cosine similarities before any of the override rules
+	numTags := int(mat.Sum(corpus.tagCSC.ColView(currentMangaIndex)))
+	distTag := pairwise.CosineSimilarity(corpus.tagWeightedCSC.ColView(currentMangaIndex), corpus.tagCSC.ColView(matchMangaIndex))
+	distDesc := corpus.descSimilarity(currentMangaIndex, matchMangaIndex)
+	if math.IsNaN(distTag) {
+		distTag = 0
+	}
+	if math.IsNaN(distDesc) {
+		distDesc = 0
+	}
+
+	// Description terms
+	descVocabularyInverse := map[int]string{}
+	for k, v := range corpus.model.DescVocabulary {
+		descVocabularyInverse[v] = k
+	}
+	terms := explainTerms(corpus.descCSC.ColView(currentMangaIndex), corpus.descCSC.ColView(matchMangaIndex), descVocabularyInverse)
+	fmt.Printf("Description: %.4f cosine, %d words in A, %d words in B, %d shared terms\n", distDesc, corpus.descLength[currentMangaIndex], corpus.descLength[matchMangaIndex], len(terms))
+	if corpus.descLsa != nil {
+		distTfidf := pairwise.CosineSimilarity(corpus.descCSC.ColView(currentMangaIndex), corpus.descCSC.ColView(matchMangaIndex))
+		fmt.Printf("  | scored in %d lsa dimensions, the shared terms below give a %.4f tf-idf cosine\n", corpus.descLsa.RawMatrix().Rows, distTfidf)
+	}
+	for i, term := range terms {
+		if i >= numTerms {
+			fmt.Printf("  | ... %d more\n", len(terms)-numTerms)
+			break
+		}
+		fmt.Printf("  | %-20s %.4f contribution (tf-idf %.3f in A, %.3f in B)\n", term.term, term.contribution, term.weight, term.weightOther)
+	}
+	fmt.Println()
+
+	// Tags
+	tagVocabularyInverse := map[int]string{}
+	for k, v := range corpus.model.TagVocabulary {
+		tagVocabularyInverse[v] = k
+	}
+	tags := explainTerms(corpus.tagWeightedCSC.ColView(currentMangaIndex), corpus.tagCSC.ColView(matchMangaIndex), tagVocabularyInverse)
+	fmt.Printf("Tags: %.4f weighted cosine, %d tags in A, %d shared tags\n", distTag, numTags, len(tags))
+	for _, tag := range tags {
+		tagId := corpus.config.TagCatalogue.Ids[tag.term]
+		fmt.Printf("  | %-20s %.4f contribution (weight %.2f, %s %s)\n", tag.term, tag.contribution, tag.weight, corpus.config.TagCatalogue.Groups[tagId], tagId)
+	}
+	fmt.Println()
+
+	// Authors and artists
+	fmt.Printf("Creators: %.4f overlap, weighted by creatorScoreRatio %.2f\n", similar.CreatorOverlap(currentManga, matchManga), config.CreatorScoreRatio)
+	fmt.Printf("  | A %s\n", creatorNames(currentManga))
+	fmt.Printf("  | B %s\n", creatorNames(matchManga))
+	fmt.Println()
+
+	// Publication metadata
+	fmt.Printf("Year: %.4f proximity, weighted by yearScoreRatio %.2f\n", similar.YearProximity(currentManga, matchManga, config.YearScoreRange), config.YearScoreRatio)
+	fmt.Printf("  | A %d, B %d, range %d years\n", currentManga.Year, matchManga.Year, config.YearScoreRange)
+	fmt.Printf("Original language: %.4f affinity, weighted by languageScoreRatio %.2f\n", similar.LanguageAffinity(currentManga, matchManga), config.LanguageScoreRatio)
+	fmt.Printf("  | A %s, B %s\n", currentManga.OriginalLanguage, matchManga.OriginalLanguage)
+	fmt.Printf("Status: A %s, B %s\n", currentManga.Status, matchManga.Status)
+	fmt.Println()
+
+	// Override rules, these are the same as in combineScores
+	fmt.Printf("Override rules:\n")
+	printRule("A has fewer than ignoreTagsUnderCount tags, tag score set to 1", numTags < config.IgnoreTagsUnderCount)
+	printRule("description score under ignoreDescScoreUnder, description score set to 0", distDesc < 1e-4 || distDesc < config.IgnoreDescScoreUnder)
+	printRule("B has fewer than minDescriptionWords words, description score set to 0", corpus.descLength[matchMangaIndex] < config.MinDescriptionWords)
+	printRule("description score over acceptDescScoreOver, tag score set to 1", distDesc > config.AcceptDescScoreOver)
+	printRule("A has fewer than minDescriptionWords words, A is never matched", corpus.descLength[currentMangaIndex] < config.MinDescriptionWords)
+	fmt.Println()
+
+	match := corpus.combineScores(currentMangaIndex, matchMangaIndex, numTags, distTag, distDesc)
+	fmt.Printf("Combined: %.3f tag, %.3f desc, %.3f creator, %.3f year, %.3f language, %.3f score\n\n",
+		match.DistanceTag, match.DistanceDesc, match.DistanceCreator, match.DistanceYear, match.DistanceLanguage, match.Distance/config.MaxScore())
+
+	// Match rules
+	if invalid, reason := invalidForProcessing(match, currentMangaIndex, currentManga, matchManga, corpus.config); invalid {
+		fmt.Printf("\u001B[1;31mRejected because %s\u001B[0m\n", reason)
+		return
+	}
+	if config.IsDemotedStatus(matchManga.Status) {
+		fmt.Printf("\u001B[1;33mDemoted because its status is %s, only fills otherwise empty slots\u001B[0m\n", matchManga.Status)
+	}
+
+	// Finally see where B lands against every other manga
+	matches := corpus.findMatches(currentMangaIndex, similar.BruteForceIndex{Size: len(corpus.mangaList)}.Candidates(currentMangaIndex), nil)
+	for rank, match := range matches {
+		if match.ID.(int) == matchMangaIndex {
+			fmt.Printf("Matched at rank %d of %d\n", rank+1, len(matches))
+			return
+		}
+	}
+	lowest := 0.0
+	if len(matches) > 0 {
+		lowest = matches[len(matches)-1].Distance / config.MaxScore()
+	}
+	fmt.Printf("Valid, but not within the top %d matches (lowest kept score is %.3f)\n", config.NumSimToGet, lowest)
+}
+
+func creatorNames(manga internal.Manga) string {
+	var names []string
+	for _, creator := range manga.Authors {
+		names = append(names, creator.Name+" (author)")
+	}
+	for _, creator := range manga.Artists {
+		names = append(names, creator.Name+" (artist)")
+	}
+	if len(names) == 0 {
+		return "no authors or artists stored"
+	}
+	return strings.Join(names, ", ")
+}
+
+func printRule(rule string, fired bool) {
+	if fired {
+		fmt.Printf("  | [x] %s\n", rule)
+	} else {
+		fmt.Printf("  | [ ] %s\n", rule)
+	}
+}
+
+// Splits the cosine similarity of two sparse vectors into the contribution of each shared term, largest first
+func explainTerms(v mat.Vector, vOther mat.Vector, vocabularyInverse map[int]string) []termContribution {
+	norm := mat.Norm(v, 2)
+	normOther := mat.Norm(vOther, 2)
+	if norm == 0 || normOther == 0 {
+		return nil
+	}
+
+	var terms []termContribution
+	for i := 0; i < v.Len(); i++ {
+		weight := v.AtVec(i)
+		if weight == 0 {
+			continue
+		}
+		weightOther := vOther.AtVec(i)
+		if weightOther == 0 {
+			continue
+		}
+		terms = append(terms, termContribution{
+			term:         vocabularyInverse[i],
+			weight:       weight,
+			weightOther:  weightOther,
+			contribution: weight * weightOther / (norm * normOther),
+		})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].contribution > terms[j].contribution
+	})
+	return terms
+}
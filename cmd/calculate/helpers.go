@@ -3,118 +3,121 @@ package calculate
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/similar-manga/similar/internal"
-	"log"
 	"os"
 )
 
-func DeleteSimilarDB() {
+func DeleteSimilarDB() error {
 	_, err := internal.DB.Exec("DELETE FROM " + internal.TableSimilar)
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("clearing similar: %w", err)
+	}
+	return nil
 }
 
-func InsertSimilarData(similarData internal.SimilarManga) {
+func InsertSimilarData(similarData internal.SimilarManga) error {
 	dst := &bytes.Buffer{}
-	jsonSimilar, _ := json.Marshal(similarData)
-	err := json.Compact(dst, jsonSimilar)
-	internal.CheckErr(err)
-	stmt, err := internal.DB.Prepare("INSERT INTO " + internal.TableSimilar + " (UUID, JSON) VALUES (?, ?)")
-	internal.CheckErr(err)
-	defer stmt.Close()
-	_, err = stmt.Exec(similarData.Id, dst.Bytes())
-	internal.CheckErr(err)
+	jsonSimilar, err := json.Marshal(similarData)
+	if err != nil {
+		return fmt.Errorf("storing similar of %s: %w", similarData.Id, err)
+	}
+	err = json.Compact(dst, jsonSimilar)
+	if err != nil {
+		return fmt.Errorf("storing similar of %s: %w", similarData.Id, err)
+	}
+	_, err = internal.DB.Exec("INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, ?)", similarData.Id, dst.Bytes())
+	if err != nil {
+		return fmt.Errorf("storing similar of %s: %w", similarData.Id, err)
+	}
+	return nil
 }
 
-func getDBSimilar() []internal.DbSimilar {
+func DeleteSimilarData(uuid string) error {
+	_, err := internal.DB.Exec("DELETE FROM "+internal.TableSimilar+" WHERE UUID = ?", uuid)
+	if err != nil {
+		return fmt.Errorf("deleting similar of %s: %w", uuid, err)
+	}
+	return nil
+}
+
+func getDBSimilar() ([]internal.DbSimilar, error) {
 	rows, err := internal.DB.Query("SELECT UUID, JSON FROM SIMILAR")
+	if err != nil {
+		return nil, fmt.Errorf("reading similar: %w", err)
+	}
 	defer rows.Close()
-	internal.CheckErr(err)
 
 	var similarList []internal.DbSimilar
 	for rows.Next() {
 		similar := internal.DbSimilar{}
-		rows.Scan(&similar.Id, &similar.JSON)
-		internal.CheckErr(err)
+		if err := rows.Scan(&similar.Id, &similar.JSON); err != nil {
+			return nil, fmt.Errorf("reading similar: %w", err)
+		}
 		similarList = append(similarList, similar)
 	}
-	return similarList
+	return similarList, rows.Err()
 }
 
-func WriteLineToDebugFile(fileName string, line string) {
+func WriteLineToDebugFile(fileName string, line string) error {
 	os.MkdirAll("debug", 0777)
 	file, err := os.OpenFile("debug/"+fileName+".txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
-	internal.CheckErr(err)
-	file.WriteString(line + "\n")
-	file.Close()
-}
-
-func ExportAniList() {
-	genericList := getAllGenericFromTable(internal.TableAnilist)
-	exportGeneric("anilist2mdex", genericList)
-}
-
-func ExportAnimePlanet() {
-	genericList := getAllGenericFromTable(internal.TableAnimePlanet)
-	exportGeneric("animeplanet2mdex", genericList)
-}
-func ExportBookWalker() {
-	genericList := getAllGenericFromTable(internal.TableBookWalker)
-	exportGeneric("bookwalker2mdex", genericList)
-}
-
-func ExportMangaUpdates() {
-	genericList := getAllGenericFromTable(internal.TableMangaupdates)
-	exportGeneric("mangaupdates2mdex", genericList)
-}
-
-func ExportNovelUpdates() {
-	genericList := getAllGenericFromTable(internal.TableNovelUpdates)
-	exportGeneric("novelupdates2mdex", genericList)
-}
-
-func ExportKitsu() {
-	genericList := getAllGenericFromTable(internal.TableKitsu)
-	exportGeneric("kitsu2mdex", genericList)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(line + "\n")
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func ExportMyAnimeList() {
-	genericList := getAllGenericFromTable(internal.TableMyanimelist)
-	exportGeneric("myanimelist2mdex", genericList)
+// ExportMapping writes the mapping of the site to data/mappings/<ExportFile>.txt
+func ExportMapping(site internal.MappingSite) error {
+	return exportTable(site.Table, site.ExportFile)
 }
 
-func ExportMangaUpdatesNewIds() {
-	genericList := getAllGenericFromTable(internal.TableMangaupdatesNewId)
-	exportGeneric("mangaupdates_new2mdex", genericList)
+func exportTable(tableName string, fileName string) error {
+	genericList, err := getAllGenericFromTable(tableName)
+	if err != nil {
+		return err
+	}
+	return exportGeneric(fileName, genericList)
 }
 
-func exportGeneric(fileName string, genericList []internal.DbGeneric) {
+func exportGeneric(fileName string, genericList []internal.DbGeneric) error {
 	file, err := os.Create("data/mappings/" + fileName + ".txt")
-	internal.CheckErr(err)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", fileName, err)
+	}
 	for _, entry := range genericList {
-		file.WriteString(entry.ID + ":::||@!@||:::" + entry.UUID + "\n")
+		if _, err := file.WriteString(entry.ID + ":::||@!@||:::" + entry.UUID + "\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("exporting %s: %w", fileName, err)
+		}
 	}
-	file.Close()
+	return file.Close()
 }
 
-func getAllGenericFromTable(tableName string) []internal.DbGeneric {
-	rows, err := internal.DB.Query("SELECT UUID, ID FROM " + tableName + " ORDER BY UUID asc ")
+func getAllGenericFromTable(tableName string) ([]internal.DbGeneric, error) {
+	rows, err := internal.DB.Query("SELECT UUID, ID, COALESCE(RAW, ID) FROM " + tableName + " ORDER BY UUID asc ")
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", tableName, err)
+	}
 	defer rows.Close()
-	internal.CheckErr(err)
 
 	var genericList []internal.DbGeneric
 	for rows.Next() {
 		similar := internal.DbGeneric{}
-		rows.Scan(&similar.UUID, &similar.ID)
-		internal.CheckErr(err)
+		if err := rows.Scan(&similar.UUID, &similar.ID, &similar.RAW); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", tableName, err)
+		}
 		genericList = append(genericList, similar)
 	}
-	return genericList
+	return genericList, rows.Err()
 }
 
-func CreateMappingsFile(fileName string) *os.File {
-	file, err := os.Create("data/mappings/" + fileName + ".txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	return file
+func CreateMappingsFile(fileName string) (*os.File, error) {
+	return os.Create("data/mappings/" + fileName + ".txt")
 }
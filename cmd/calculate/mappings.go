@@ -1,6 +1,7 @@
 package calculate
 
 import (
+	"errors"
 	"fmt"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
@@ -12,170 +13,147 @@ import (
 var mappingsCmd = &cobra.Command{
 	Use:   "mappings",
 	Short: "This updates the external website mapping ids to MangaDex uuids",
-	Long:  "This updates the external website mapping ids to MangaDex uuids",
-	Run:   runMappings,
+	Long: `This updates the external website mapping ids to MangaDex uuids
+
+With --audit nothing is updated, instead every site is checked for external ids which several manga map to,
+ids not in the form the site uses (e.g. a url instead of a MyAnimeList id) and ids which changed since the
+last run. The json report is written to --report and with --debug also to the debug/ folder.`,
+	Run: runMappings,
 }
 
 func init() {
 	calculateCmd.AddCommand(mappingsCmd)
+	mappingsCmd.Flags().Bool("audit", false, "Report duplicate, malformed and changed ids instead of updating the mappings")
+	mappingsCmd.Flags().String("report", "data/mappings_audit.json", "File the json audit report is written to")
+	mappingsCmd.Flags().Bool("debug", false, "Also write the audit to debug/MappingDuplicates.txt, MappingMalformed.txt and MappingChanged.txt")
 }
 
 func runMappings(cmd *cobra.Command, args []string) {
 	initialStart := time.Now()
-
-	mangaList := internal.GetAllManga()
-
-	calculateAniListMapping(mangaList)
-	calculateAnimePlanetMapping(mangaList)
-	calculateBookWalkerMapping(mangaList)
-	calculateNovelUpdatesMapping(mangaList)
-	calculateKitsuMapping(mangaList)
-	calculateMyAnimeListMapping(mangaList)
-	calculateMangaUpdatesMapping(mangaList)
-	calculateMangaUpdatesNewIdMapping(mangaList)
-
-	fmt.Printf("Finished all mappings in %s\n", time.Since(initialStart))
-
-}
-
-func calculateAniListMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating AniList Mapping")
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-	for _, manga := range mangaList {
-		id := manga.Links["al"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableAnilist, manga.Id, id)
+	summary := internal.NewErrorSummary()
+
+	mangaList, err := internal.GetAllManga()
+	summary.CheckErr(summary.SkipRows("reading manga", err))
+
+	audit, _ := cmd.Flags().GetBool("audit")
+	if audit {
+		reportFile, _ := cmd.Flags().GetString("report")
+		debug, _ := cmd.Flags().GetBool("debug")
+		report, err := auditMappings(mangaList)
+		summary.CheckErr(err)
+		summary.CheckErr(writeMappingAuditReport(reportFile, report))
+		if debug {
+			summary.Add("writing audit debug files", writeMappingAuditDebugFiles(report))
 		}
+		printMappingAudit(report)
+		fmt.Printf("Wrote mapping audit to %s in %s\n", reportFile, time.Since(initialStart))
+		summary.Print()
+		return
 	}
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting AniList mapping file")
-	ExportAniList()
-}
 
-func calculateAnimePlanetMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating AnimePlanet Mapping")
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-	for _, manga := range mangaList {
-		id := manga.Links["ap"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableAnimePlanet, manga.Id, id)
+	// A site which fails is rolled back and left as it was, the other sites are still updated
+	for _, site := range internal.MappingSites {
+		summary.CheckErr(site.CreateTable())
+		if site.LinkKey != "" {
+			summary.Add(site.Name+" mapping", calculateLinkMapping(site, mangaList, summary))
 		}
 	}
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting Anime Planet mapping file")
-	ExportAnimePlanet()
-}
+	summary.Add("MangaUpdates New Id mapping", calculateMangaUpdatesNewIdMapping(mangaList, summary))
 
-func calculateBookWalkerMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating BookWalker Mapping")
-
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-
-	for _, manga := range mangaList {
-		id := manga.Links["bw"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableBookWalker, manga.Id, id)
-		}
+	for _, site := range internal.MappingSites {
+		fmt.Printf("Exporting %s mapping file\n", site.Name)
+		summary.Add("exporting "+site.Name+" mapping", ExportMapping(site))
 	}
 
-	err = tx.Commit()
-	internal.CheckErr(err)
+	fmt.Printf("Finished all mappings in %s\n", time.Since(initialStart))
+	summary.Print()
 
-	fmt.Println("Exporting Book Walker mapping file")
-	ExportBookWalker()
 }
 
-func calculateNovelUpdatesMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating NovelUpdates Mapping")
-
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-
-	for _, manga := range mangaList {
-		id := manga.Links["nu"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableNovelUpdates, manga.Id, id)
+// Stores the normalised id of the site from the links of every manga, together with the raw link
+// Ids the site can't normalise or resolve are added to summary and the id stored for the manga by an earlier run is deleted
+// If resolving fails for another reason, e.g. the site is down, the stored id is kept
+func calculateLinkMapping(site internal.MappingSite, mangaList []internal.Manga, summary *internal.ErrorSummary) error {
+	fmt.Printf("Calculating %s Mapping\n", site.Name)
+	var resolved map[string]string
+	if site.Resolve != nil {
+		storedList, err := getAllGenericFromTable(site.Table)
+		if err != nil {
+			return err
 		}
+		resolved = resolvedIds(site, storedList)
 	}
-
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting NovelUpdates mapping file")
-	ExportNovelUpdates()
-}
-
-func calculateKitsuMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating Kitsu Mapping")
-
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-
-	for _, manga := range mangaList {
-		id := manga.Links["kt"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableKitsu, manga.Id, id)
-		}
+	rateLimiter := ratelimit.New(4)
+	if internal.Offline {
+		rateLimiter = ratelimit.NewUnlimited()
 	}
 
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting Kitsu mapping file")
-	ExportKitsu()
-}
-
-func calculateMyAnimeListMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating MyAnimeList Mapping")
-
 	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 	for _, manga := range mangaList {
-		id := manga.Links["mal"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableMyanimelist, manga.Id, id)
+		raw := manga.Links[site.LinkKey]
+		if raw == "" {
+			continue
+		}
+		id := raw
+		if site.Normalise != nil {
+			id, err = site.Normalise(raw)
+			if err != nil {
+				summary.Add(site.Name+" mapping", fmt.Errorf("manga %s link %q: %w", manga.Id, raw, err))
+				if err := DeleteGeneric(tx, site.Table, manga.Id); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if site.Resolve != nil && site.Validate(id) != nil {
+			resolvedId, ok := resolved[id]
+			if !ok {
+				rateLimiter.Take()
+				resolvedId, err = site.Resolve(id)
+				if err != nil {
+					summary.Add(site.Name+" mapping", fmt.Errorf("manga %s link %q: %w", manga.Id, raw, err))
+					if errors.Is(err, internal.ErrUnknownId) {
+						if err := DeleteGeneric(tx, site.Table, manga.Id); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				fmt.Printf("manga %s -> %s %s resolved to %s\n", manga.Id, site.Key, id, resolvedId)
+				resolved[id] = resolvedId
+			}
+			id = resolvedId
+		}
+		if err := UpsertGeneric(tx, site.Table, manga.Id, id, raw); err != nil {
+			return err
 		}
 	}
-
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting MyAnimeList New Ids file")
-	ExportMyAnimeList()
+	return tx.Commit()
 }
 
-func calculateMangaUpdatesMapping(mangaList []internal.Manga) {
-	fmt.Println("Calculating MangaUpdates Mapping")
-
-	tx, err := internal.DB.Begin()
-	internal.CheckErr(err)
-
-	for _, manga := range mangaList {
-		id := manga.Links["mu"]
-		if id != "" {
-			UpsertGeneric(tx, internal.TableMangaupdates, manga.Id, id)
+// The ids earlier runs resolved, keyed by the normalised id of the stored raw link they were resolved from
+func resolvedIds(site internal.MappingSite, storedList []internal.DbGeneric) map[string]string {
+	resolved := map[string]string{}
+	for _, stored := range storedList {
+		id, err := site.Normalise(stored.RAW)
+		if err == nil && id != stored.ID && site.Validate(stored.ID) == nil {
+			resolved[id] = stored.ID
 		}
 	}
-
-	err = tx.Commit()
-	internal.CheckErr(err)
-
-	fmt.Println("Exporting MangaUpdates mapping file")
-	ExportMangaUpdates()
-
+	return resolved
 }
 
-func calculateMangaUpdatesNewIdMapping(mangaList []internal.Manga) {
+// Manga whose MangaUpdates id couldn't be checked are skipped and added to summary
+func calculateMangaUpdatesNewIdMapping(mangaList []internal.Manga, summary *internal.ErrorSummary) error {
 	fmt.Println("Calculating MangaUpdates New Id Mapping")
 	rateLimiter := ratelimit.New(1)
+	if internal.Offline {
+		rateLimiter = ratelimit.NewUnlimited()
+	}
 
 	// mangaupdates
 	// https://www.mangaupdates.com/series.html?id=`{id}`
@@ -191,33 +169,35 @@ func calculateMangaUpdatesNewIdMapping(mangaList []internal.Manga) {
 	maxGoroutines := 1000
 	guard := make(chan struct{}, maxGoroutines)
 
+	// The new id is worked out from the normalised MangaUpdates id, which is stored as its raw value
+	mangaUpdates, _ := internal.GetMappingSite("mu")
 	for index, manga := range mangaList {
-		muLink := manga.Links["mu"]
+		muLink := mangaUpdates.NormaliseId(manga.Links["mu"])
 
 		// would block if guard channel is already filled
 		guard <- struct{}{}
 
 		go func(index int, totalManga int, uuid string, muLink string, limiter ratelimit.Limiter) {
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Println("goroutine paniqued: ", r)
-				}
-			}()
 			// Our search file
 			defer wg.Done()
-			if muLink != "" {
-				if !AddAlreadyConvertedId(index, totalManga, uuid, muLink, rateLimiter) && !CheckAndAddLegacyId(index, totalManga, uuid, muLink, rateLimiter) {
-					fmt.Printf("%d/%d manga %s -> mu invalid %s\n", index+1, totalManga, uuid, muLink)
-				}
+			defer func() { <-guard }()
+			if muLink == "" {
+				return
+			}
+			found, err := AddAlreadyConvertedId(index, totalManga, uuid, muLink, rateLimiter)
+			if err == nil && !found {
+				found, err = CheckAndAddLegacyId(index, totalManga, uuid, muLink, rateLimiter)
+			}
+			if err != nil {
+				summary.Add("MangaUpdates New Id mapping", err)
+			} else if !found {
+				fmt.Printf("%d/%d manga %s -> mu invalid %s\n", index+1, totalManga, uuid, muLink)
 			}
-			<-guard
 		}(index, totalManga, manga.Id, muLink, rateLimiter)
 	}
 
 	wg.Wait()
 
-	fmt.Println("Exporting MangaUpdates New Ids file")
-	ExportMangaUpdatesNewIds()
-
 	fmt.Printf("done processing MangaUpdates New Ids (%.2f seconds)!\n", time.Since(start).Seconds())
+	return nil
 }
@@ -0,0 +1,196 @@
+package calculate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/similar-manga/similar/internal"
+	"os"
+	"sort"
+	"time"
+)
+
+type mappingAuditReport struct {
+	GeneratedAt string      `json:"generatedAt"`
+	Sites       []siteAudit `json:"sites"`
+}
+
+type siteAudit struct {
+	Site       string             `json:"site"`
+	Name       string             `json:"name"`
+	Mapped     int                `json:"mapped"`
+	Duplicates []duplicateMapping `json:"duplicates"`
+	Malformed  []malformedMapping `json:"malformed"`
+	Changed    []changedMapping   `json:"changed"`
+}
+
+// An external id more than one MangaDex manga maps to
+type duplicateMapping struct {
+	Id       string   `json:"id"`
+	Url      string   `json:"url"`
+	MangaIds []string `json:"mangaIds"`
+}
+
+// Id is the raw link if it couldn't be normalised, otherwise the normalised id
+type malformedMapping struct {
+	MangaId string `json:"mangaId"`
+	Id      string `json:"id"`
+	Reason  string `json:"reason"`
+}
+
+// A stored id which the links no longer agree with, NewId is empty if the link was removed
+type changedMapping struct {
+	MangaId string `json:"mangaId"`
+	OldId   string `json:"oldId"`
+	NewId   string `json:"newId"`
+}
+
+// Audits the normalised links of every manga against what the last mappings run stored, without changing the mappings
+// Sites without a link key are audited on their stored ids alone, a site whose table is missing has nothing stored
+func auditMappings(mangaList []internal.Manga) (mappingAuditReport, error) {
+	report := mappingAuditReport{GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, site := range internal.MappingSites {
+		exists, err := site.TableExists()
+		if err != nil {
+			return report, err
+		}
+		var storedList []internal.DbGeneric
+		if exists {
+			storedList, err = getAllGenericFromTable(site.Table)
+			if err != nil {
+				return report, err
+			}
+		}
+		stored := map[string]string{}
+		for _, generic := range storedList {
+			stored[generic.UUID] = generic.ID
+		}
+
+		// Ids are only resolved when the mappings are calculated, the audit uses the ones stored by the last run
+		var resolved map[string]string
+		if site.Resolve != nil {
+			resolved = resolvedIds(site, storedList)
+		}
+
+		current := stored
+		var unnormalised []malformedMapping
+		if site.LinkKey != "" {
+			current = map[string]string{}
+			for _, manga := range mangaList {
+				raw := manga.Links[site.LinkKey]
+				if raw == "" {
+					continue
+				}
+				id := raw
+				if site.Normalise != nil {
+					if id, err = site.Normalise(raw); err != nil {
+						unnormalised = append(unnormalised, malformedMapping{MangaId: manga.Id, Id: raw, Reason: err.Error()})
+						continue
+					}
+				}
+				if resolvedId, ok := resolved[id]; ok {
+					id = resolvedId
+				}
+				current[manga.Id] = id
+			}
+		}
+		report.Sites = append(report.Sites, auditSite(site, stored, current, unnormalised))
+	}
+	return report, nil
+}
+
+// current is the id of the site for each manga uuid, stored is the one the last run left in the table
+// unnormalised are the links the site couldn't normalise, which aren't in current
+func auditSite(site internal.MappingSite, stored map[string]string, current map[string]string, unnormalised []malformedMapping) siteAudit {
+	audit := siteAudit{
+		Site:       site.Key,
+		Name:       site.Name,
+		Mapped:     len(current),
+		Duplicates: []duplicateMapping{},
+		Malformed:  append([]malformedMapping{}, unnormalised...),
+		Changed:    []changedMapping{},
+	}
+
+	mangaIds := map[string][]string{}
+	for uuid, id := range current {
+		mangaIds[id] = append(mangaIds[id], uuid)
+		if site.Validate != nil {
+			if err := site.Validate(id); err != nil {
+				audit.Malformed = append(audit.Malformed, malformedMapping{MangaId: uuid, Id: id, Reason: err.Error()})
+			}
+		}
+		if oldId, ok := stored[uuid]; ok && oldId != id {
+			audit.Changed = append(audit.Changed, changedMapping{MangaId: uuid, OldId: oldId, NewId: id})
+		}
+	}
+	for uuid, oldId := range stored {
+		if _, ok := current[uuid]; !ok {
+			audit.Changed = append(audit.Changed, changedMapping{MangaId: uuid, OldId: oldId})
+		}
+	}
+	for id, uuids := range mangaIds {
+		if len(uuids) > 1 {
+			sort.Strings(uuids)
+			audit.Duplicates = append(audit.Duplicates, duplicateMapping{Id: id, Url: site.URL(id), MangaIds: uuids})
+		}
+	}
+
+	sort.Slice(audit.Duplicates, func(i, j int) bool {
+		return audit.Duplicates[i].Id < audit.Duplicates[j].Id
+	})
+	sort.Slice(audit.Malformed, func(i, j int) bool {
+		return audit.Malformed[i].MangaId < audit.Malformed[j].MangaId
+	})
+	sort.Slice(audit.Changed, func(i, j int) bool {
+		return audit.Changed[i].MangaId < audit.Changed[j].MangaId
+	})
+	return audit
+}
+
+func writeMappingAuditReport(fileName string, report mappingAuditReport) error {
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, jsonReport, 0777)
+}
+
+// Writes the report as lines to debug/MappingDuplicates.txt, debug/MappingMalformed.txt and debug/MappingChanged.txt
+// The files are replaced, not appended to, so they only hold this audit
+func writeMappingAuditDebugFiles(report mappingAuditReport) error {
+	for _, fileName := range []string{"MappingDuplicates", "MappingMalformed", "MappingChanged"} {
+		if err := os.Remove("debug/" + fileName + ".txt"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, audit := range report.Sites {
+		for _, duplicate := range audit.Duplicates {
+			line := fmt.Sprintf("%s %s -> %d manga", audit.Site, duplicate.Url, len(duplicate.MangaIds))
+			for _, uuid := range duplicate.MangaIds {
+				line += " https://mangadex.org/title/" + uuid
+			}
+			if err := WriteLineToDebugFile("MappingDuplicates", line); err != nil {
+				return err
+			}
+		}
+		for _, malformed := range audit.Malformed {
+			line := fmt.Sprintf("%s https://mangadex.org/title/%s %q %s", audit.Site, malformed.MangaId, malformed.Id, malformed.Reason)
+			if err := WriteLineToDebugFile("MappingMalformed", line); err != nil {
+				return err
+			}
+		}
+		for _, changed := range audit.Changed {
+			line := fmt.Sprintf("%s https://mangadex.org/title/%s %q -> %q", audit.Site, changed.MangaId, changed.OldId, changed.NewId)
+			if err := WriteLineToDebugFile("MappingChanged", line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printMappingAudit(report mappingAuditReport) {
+	for _, audit := range report.Sites {
+		fmt.Printf("%s: %d mapped, %d duplicate ids, %d malformed ids, %d changed since the last run\n",
+			audit.Name, audit.Mapped, len(audit.Duplicates), len(audit.Malformed), len(audit.Changed))
+	}
+}
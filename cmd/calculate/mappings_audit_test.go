@@ -0,0 +1,94 @@
+package calculate
+
+import (
+	"github.com/similar-manga/similar/internal"
+	"reflect"
+	"testing"
+)
+
+func TestAuditSite(t *testing.T) {
+	anilist, _ := internal.GetMappingSite("al")
+	unvalidated := internal.MappingSite{Key: "x", Name: "Unvalidated", URLTemplate: "https://example.com/{id}"}
+	tests := []struct {
+		name         string
+		site         internal.MappingSite
+		stored       map[string]string
+		current      map[string]string
+		unnormalised []malformedMapping
+		audit        siteAudit
+	}{
+		{
+			name:    "unchanged",
+			site:    anilist,
+			stored:  map[string]string{"m1": "1", "m2": "2"},
+			current: map[string]string{"m1": "1", "m2": "2"},
+			audit:   siteAudit{Mapped: 2},
+		},
+		{
+			name:    "nothing stored or linked",
+			site:    anilist,
+			stored:  map[string]string{},
+			current: map[string]string{},
+			audit:   siteAudit{},
+		},
+		{
+			name:    "duplicates sorted by id with their manga sorted",
+			site:    anilist,
+			stored:  map[string]string{},
+			current: map[string]string{"m3": "7", "m1": "7", "m2": "5", "m4": "5", "m5": "6"},
+			audit: siteAudit{Mapped: 5, Duplicates: []duplicateMapping{
+				{Id: "5", Url: "https://anilist.co/manga/5", MangaIds: []string{"m2", "m4"}},
+				{Id: "7", Url: "https://anilist.co/manga/7", MangaIds: []string{"m1", "m3"}},
+			}},
+		},
+		{
+			name:         "malformed and unnormalised sorted by manga",
+			site:         anilist,
+			stored:       map[string]string{},
+			current:      map[string]string{"m3": "abc", "m1": "1"},
+			unnormalised: []malformedMapping{{MangaId: "m2", Id: "https://anilist.co/", Reason: "is empty"}},
+			audit: siteAudit{Mapped: 2, Malformed: []malformedMapping{
+				{MangaId: "m2", Id: "https://anilist.co/", Reason: "is empty"},
+				{MangaId: "m3", Id: "abc", Reason: "is not a numeric id"},
+			}},
+		},
+		{
+			name:    "changed, removed and new links",
+			site:    anilist,
+			stored:  map[string]string{"m1": "1", "m2": "2", "m3": "3"},
+			current: map[string]string{"m1": "10", "m3": "3", "m4": "4"},
+			audit: siteAudit{Mapped: 3, Changed: []changedMapping{
+				{MangaId: "m1", OldId: "1", NewId: "10"},
+				{MangaId: "m2", OldId: "2"},
+			}},
+		},
+		{
+			name:    "site without validation",
+			site:    unvalidated,
+			stored:  map[string]string{"m1": "anything at all"},
+			current: map[string]string{"m1": "anything at all"},
+			audit:   siteAudit{Mapped: 1},
+		},
+	}
+	for _, test := range tests {
+		want := withEmptyLists(test.audit)
+		want.Site, want.Name = test.site.Key, test.site.Name
+		if got := auditSite(test.site, test.stored, test.current, test.unnormalised); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.name, got, want)
+		}
+	}
+}
+
+// The audit has empty lists rather than nil, so the json report has [] for them
+func withEmptyLists(audit siteAudit) siteAudit {
+	if audit.Duplicates == nil {
+		audit.Duplicates = []duplicateMapping{}
+	}
+	if audit.Malformed == nil {
+		audit.Malformed = []malformedMapping{}
+	}
+	if audit.Changed == nil {
+		audit.Changed = []changedMapping{}
+	}
+	return audit
+}
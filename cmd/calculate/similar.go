@@ -1,21 +1,14 @@
 package calculate
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/caneroj1/stemmer"
-	"github.com/james-bowman/nlp"
-	"github.com/james-bowman/nlp/measures/pairwise"
-	"github.com/james-bowman/sparse"
 	_ "github.com/mattn/go-sqlite3"
 	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
 	"gonum.org/v1/gonum/mat"
-	"log"
-	"math"
 	"os"
-	"regexp"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,218 +28,177 @@ func init() {
 	similarCmd.Flags().BoolP("debug", "d", false, "Run a set of debug entries only.  Printing results to the screen only.")
 	similarCmd.Flags().BoolP("export", "e", false, "Only export results, don't recalculate similar.")
 	similarCmd.Flags().IntP("threads", "t", 1000, "Change the batch processing amount")
+	similarCmd.Flags().StringP("index", "x", "inverted", "Candidate index to use, either inverted (approximate) or brute (exact pairwise)")
+	similarCmd.Flags().IntP("candidates", "k", 500, "Number of candidates the inverted index, and the lsa index if lsaCandidates is set, return for each manga")
+	similarCmd.Flags().Int("max-posting", 5000, "Ignore index terms shared by more than this many manga")
+	similarCmd.Flags().StringP("config", "c", "data/similar_config.json", "Config file with the similar weights and thresholds")
+	similarCmd.Flags().BoolP("incremental", "i", false, "Only recalculate manga changed since the last run, re-using the stored vocabulary")
+	similarCmd.Flags().StringP("model", "m", "", "Vectorise with this stored model file instead of fitting a new one, for reproducible runs, not with --incremental")
+	similarCmd.Flags().Int("recall", 0, "Measure recall of the index against brute force on this many sampled manga")
 }
 func runSimilar(cmd *cobra.Command, args []string) {
 
 	debugMode, _ := cmd.Flags().GetBool("debug")
 	skippedMode, _ := cmd.Flags().GetBool("skipped")
 	exportOnly, _ := cmd.Flags().GetBool("export")
-
+	indexMode, _ := cmd.Flags().GetString("index")
+	numCandidates, _ := cmd.Flags().GetInt("candidates")
+	maxPostingLength, _ := cmd.Flags().GetInt("max-posting")
+	recallSample, _ := cmd.Flags().GetInt("recall")
+	incremental, _ := cmd.Flags().GetBool("incremental")
+	configFile, _ := cmd.Flags().GetString("config")
+	modelFile, _ := cmd.Flags().GetString("model")
+
+	summary := internal.NewErrorSummary()
+	if incremental && modelFile != "" {
+		summary.CheckErr(fmt.Errorf("--model can't be used with --incremental, which re-uses the stored %s", similarModelFile))
+	}
 	if !exportOnly {
 		fmt.Printf("\nBegin calculating similars\n")
-		calculateSimilars(debugMode, skippedMode)
+		calculateSimilars(summary, configFile, modelFile, debugMode, skippedMode, incremental, indexMode, numCandidates, maxPostingLength, recallSample)
 	}
 
 	if !debugMode {
 		startProcessing := time.Now()
 		fmt.Printf("Exporting All Similar to txt files\n")
-		exportSimilar()
+		summary.Add("exporting similar", exportSimilar())
 		fmt.Printf("Exporting simularities took %s\n\n", time.Since(startProcessing))
 
 	}
+	summary.Print()
 
 }
 
-func calculateSimilars(debugMode bool, skippedMode bool) {
+// Manga which fail are skipped and added to summary, anything the whole run depends on aborts it
+func calculateSimilars(summary *internal.ErrorSummary, configFile string, modelFile string, debugMode bool, skippedMode bool, incremental bool, indexMode string, numCandidates int, maxPostingLength int, recallSample int) {
 	startProcessing := time.Now()
 
 	// Settings
-	numSimToGet := 40
-	tagScoreRatio := 0.40
-	ignoreDescScoreUnder := 0.01
-	acceptDescScoreOver := 0.45
-	ignoreTagsUnderCount := 2
-	minDescriptionWords := 15
+	config, err := similar.LoadConfig(configFile)
+	summary.CheckErr(err)
+	fmt.Printf("Using similar config %s (hash %s)\n", configFile, config.Hash())
 
 	// Loop through all manga and try to get their chapter information for each
 	countMangasProcessed := 0
 
-	var corpusTag []string
-	var corpusDesc []string
-	var corpusDescLength []int
-
 	// Debug check / skip mangas
 	debugMangaIds := map[string]bool{"f7888782-0727-49b0-95ec-a3530c70f83b": true, "e56a163f-1a4c-400b-8c1d-6cb98e63ce04": true, "ee0df4ab-1e8d-49b9-9404-da9dcb11a32a": true, "32d76d19-8a05-4db0-9fc2-e0b0648fe9d0": true, "d46d9573-2ad9-45b2-9b6d-45f95452d1c0": true,
 		"e78a489b-6632-4d61-b00b-5206f5b8b22b": true, "58bc83a0-1808-484e-88b9-17e167469e23": true, "0fa5dab2-250a-4f69-bd15-9ceea54176fa": true}
+	// The metadata update our results will be up-to-date with
+	lastMetadataUpdate, err := readTimestampFile("data/last_metadata_update.txt")
+	summary.CheckErr(err)
+
+	// Incremental runs re-use the stored vocabulary and only recalculate what changed
+	var model *similar.Model
+	lastSimilarUpdate := ""
+	if incremental {
+		model, err = similar.LoadModel(similarModelFile)
+		lastSimilarUpdate, _ = readTimestampFile(lastSimilarUpdateFile)
+		if err != nil || lastSimilarUpdate == "" {
+			fmt.Printf("\nNo stored similar model or last similar update, doing a full recalculation\n")
+			incremental = false
+			model = nil
+		} else if model.ConfigHash != config.Hash() {
+			fmt.Printf("\nStored similar model was fitted with config %s, doing a full recalculation\n", model.ConfigHash)
+			incremental = false
+			model = nil
+		}
+	}
+
+	// A given model is used as is, so the vectors are exactly those of the run which fitted it
+	if modelFile != "" {
+		model, err = similar.LoadModel(modelFile)
+		summary.CheckErr(err)
+		if model.ConfigHash != config.Hash() {
+			summary.CheckErr(fmt.Errorf("model %s was fitted with config %s not %s", modelFile, model.ConfigHash, config.Hash()))
+		}
+		fmt.Printf("Using stored similar model %s\n", modelFile)
+	}
+
 	if debugMode {
 		fmt.Printf("\nRunning in Debug mode for the following ids:\n")
 		for k := range debugMangaIds {
 			fmt.Printf("  - %s\n", k)
 		}
-		fmt.Println("\n")
+		fmt.Println()
 
-	} else {
-		DeleteSimilarDB()
+	} else if !incremental {
+		summary.CheckErr(DeleteSimilarDB())
 	}
 
-	mangaList := internal.GetAllManga()
-
-	fmt.Println("Begin loading into corpus")
-
-	for _, manga := range mangaList {
-		// Skip if invalid, this should hardily ever occur
-		if manga.Title == nil || manga.Description == nil {
-			fmt.Printf("!!! Manga with Id %s had nil title or nil description", manga.Id)
-			continue
-		}
-
-		// Get the tag and description for this manga
-		tagText := ""
-		for _, tag := range manga.Tags {
-			reg, _ := regexp.Compile("[^a-zA-Z0-9]+")
-			tagText += reg.ReplaceAllString((*tag.Name)["en"], "") + " "
+	allManga, err := internal.GetAllManga()
+	summary.CheckErr(summary.SkipRows("reading manga", err))
+	corpus, err := buildSimilarCorpus(allManga, config, model)
+	summary.CheckErr(err)
+	mangaList := corpus.mangaList
+
+	mangaToProcess := make([]int, 0, len(mangaList))
+	if incremental {
+		affectedIds, err := affectedSinceLastRun(lastSimilarUpdate)
+		summary.CheckErr(err)
+		for currentMangaIndex, manga := range mangaList {
+			if affectedIds[manga.Id] {
+				mangaToProcess = append(mangaToProcess, currentMangaIndex)
+			}
 		}
-		descText := similar.CleanTitle((*manga.Title)["en"]) + " "
-		for _, altTitle := range manga.AltTitles {
-			if val, ok := altTitle["en"]; ok {
-				if similar.CleanTitle(val) != "" {
-					descText += similar.CleanTitle(val) + " "
-				}
+		fmt.Printf("Recalculating %d changed or affected manga since %s\n\n", len(mangaToProcess), lastSimilarUpdate)
+
+		// Tombstoned manga aren't in the corpus, so their own stored matches are removed here
+		if !debugMode {
+			tombstonedIds, err := internal.GetTombstonedMangaIds()
+			summary.CheckErr(err)
+			for _, uuid := range tombstonedIds {
+				summary.Add("storing similar", DeleteSimilarData(uuid))
 			}
 		}
-		descText += similar.CleanDescription((*manga.Description)["en"])
-
-		// Append to the corpusDesc
-		corpusTag = append(corpusTag, tagText)
-		corpusDesc = append(corpusDesc, descText)
-		corpusDescLength = append(corpusDescLength, len(strings.Split(descText, " ")))
+	} else {
+		for currentMangaIndex := range mangaList {
+			mangaToProcess = append(mangaToProcess, currentMangaIndex)
+		}
 	}
-	amountOfMangaToProcess := len(mangaList)
+	amountOfMangaToProcess := len(mangaToProcess)
 
 	if debugMode {
 		amountOfMangaToProcess = len(debugMangaIds)
 	}
-	fmt.Printf("\n\nLoaded %d Manga into our corpus\n\n", len(corpusDesc))
-
-	// Create our tf-idf pipeline
-	lsiTagVectoriser := nlp.NewCountVectoriser([]string{}...)
-	lsiPipelineTag := nlp.NewPipeline(lsiTagVectoriser)
-	stopWordsStemmed := append([]string(nil), similar.StopWords...)
-	stemmer.StemMultipleMutate(&stopWordsStemmed)
-	for i := range stopWordsStemmed {
-		stopWordsStemmed[i] = strings.ToLower(stopWordsStemmed[i])
-	}
-	lsiPipelineDescription := nlp.NewPipeline(nlp.NewCountVectoriser(stopWordsStemmed...), nlp.NewTfidfTransformer())
-
-	// Transform the corpusTag into an LSI fitting the model to the documents in the process
-	start := time.Now()
-	fmt.Printf("fitting to corpus of tags!\n")
-	lsiTag, err := lsiPipelineTag.FitTransform(corpusTag...)
-	if err != nil {
-		log.Fatalf("ERROR: failed to process documents because\n %v\n", err)
-	}
-	lsiTagCSC := lsiTag.(sparse.TypeConverter).ToCSC()
-	m, n := lsiTag.Dims()
-	fmt.Printf("\t- fitted data in %s\n", time.Since(start))
-	fmt.Printf("\t- system dim = %d x %d\n\n", m, n)
-
-	// We will now apply our custom weights for tags
-	// Each row of this matrix is a tag which we have a weight for
-	fmt.Println("Tag Vectoriser Vocabulary:")
-	fmt.Println(lsiTagVectoriser.Vocabulary)
-	fmt.Println()
-	vocabularyInverse := map[int]string{}
-	for k, v := range lsiTagVectoriser.Vocabulary {
-		vocabularyInverse[v] = k
-	}
 
-	// Special weights for tags that should have higher priority over others
-	// These are hand tuned and adhoc in nature, but seem to work?
-	tagWeights := map[string]float64{
-		"sexualviolence": 1.00,
-		"gore":           1.00,
-		"koma":           1.00,
-		"wuxia":          1.00,
-		"loli":           0.90,
-		"incest":         0.90,
-		"sports":         0.90,
-		"boyslove":       0.90,
-		"girlslove":      0.90,
-		"isekai":         0.90,
-		"villainess":     0.90,
-		"historical":     0.80,
-		"horror":         0.80,
-		"mecha":          0.80,
-		"medical":        0.80,
-		"sliceoflife":    0.80,
-		"cooking":        0.80,
-		"crossdressing":  0.80,
-		"genderswap":     0.80,
-		"harem":          0.80,
-		"reverseharem":   0.80,
-		"vampires":       0.80,
-		"zombies":        0.80,
+	// Candidate generation, only these get the full tag / description scoring
+	index, err := corpus.candidateIndex(indexMode, numCandidates, maxPostingLength)
+	summary.CheckErr(err)
+	if recallSample > 0 && indexMode != "brute" {
+		corpus.measureRecall(index, recallSample)
 	}
 
-	// Loop through the tag weights and set them to our custom ones
-	lsiTagCSCWeighted := lsiTag.(sparse.TypeConverter).ToCSC()
-	dimR, dimC := lsiTagCSCWeighted.Dims()
-	for r := 0; r < dimR; r++ {
-		tag := vocabularyInverse[r]
-		tagWeight := 0.70
-		if val, ok := tagWeights[tag]; ok {
-			tagWeight = val
-		}
-		for c := 0; c < dimC; c++ {
-			if lsiTagCSCWeighted.At(r, c) > 0 {
-				lsiTagCSCWeighted.Set(r, c, tagWeight)
-			}
-		}
-	}
-
-	// Transform the corpusDesc into an LSI fitting the model to the documents in the process
-	start = time.Now()
-	fmt.Printf("fitting to corpus of descriptions!\n")
-	lsiDesc, err := lsiPipelineDescription.FitTransform(corpusDesc...)
-	if err != nil {
-		log.Fatalf("ERROR: failed to process documents because\n %v\n", err)
-	}
-	lsiDescCSC := lsiDesc.(sparse.TypeConverter).ToCSC()
-	m, n = lsiDesc.Dims()
-	fmt.Printf("\t- fitted data in %s\n", time.Since(start))
-	fmt.Printf("\t- system dim = %d x %d\n\n", m, n)
-
 	// Create a "buffer" that is our num of max routines
 	// If we can append to it, then we will run a coroutine
 	// https://stackoverflow.com/a/25306241/7718197
 	// https://downey.io/notes/dev/openmp-parallel-for-in-golang/
 	var wg sync.WaitGroup
-	wg.Add(len(mangaList))
+	wg.Add(len(mangaToProcess))
 	maxGoroutines := 1000
 	guard := make(chan struct{}, maxGoroutines)
 
 	//	// For each manga we will get the top calculate for tags and description
 	//	// We will then combine these into a single score which is then used to rank all manga
-	start = time.Now()
+	start := time.Now()
 
-	for currentMangaIndex := 0; currentMangaIndex < len(mangaList); currentMangaIndex++ {
+	for processIndex, currentMangaIndex := range mangaToProcess {
 
 		// would block if guard channel is already filled
 		guard <- struct{}{}
-		go func(currentMangaIndex int) {
+		go func(processIndex int, currentMangaIndex int) {
 			defer wg.Done()
 
-			// This manga we will try to match to
-			// NOTE: here we use the weighted tag CSC matrix, so we will multiply this against a one-hot-matrix
-			// NOTE: e.g. [0.7 1.0 0.0 0.0 0.9] * [0 1 0 0 1] => 1.9 score value for current against another
 			currentManga := mangaList[currentMangaIndex]
+			numTags := int(mat.Sum(corpus.tagCSC.ColView(currentMangaIndex)))
 
-			vTagWeighted := lsiTagCSCWeighted.ColView(currentMangaIndex)
-			numTags := int(mat.Sum(lsiTagCSC.ColView(currentMangaIndex)))
-			vDesc := lsiDescCSC.ColView(currentMangaIndex)
+			// Incremental runs replace the stored matches of this manga
+			if incremental && !debugMode {
+				summary.Add("storing similar", DeleteSimilarData(currentManga.Id))
+			}
 
 			// Skip this manga if it has no description
-			if corpusDescLength[currentMangaIndex] < minDescriptionWords {
+			if corpus.descLength[currentMangaIndex] < config.MinDescriptionWords {
 				<-guard
 				return
 			}
@@ -259,47 +211,15 @@ func calculateSimilars(debugMode bool, skippedMode bool) {
 
 			var sb strings.Builder
 
-			// Perform matching to all the other vectors
-			var matches []customMatch
-			for mangaMatchCheckIndex := 0; mangaMatchCheckIndex < len(mangaList); mangaMatchCheckIndex++ {
-
-				// Get score for both tags and description
-				distTag := pairwise.CosineSimilarity(vTagWeighted, lsiTagCSC.ColView(mangaMatchCheckIndex))
-				distDesc := pairwise.CosineSimilarity(vDesc, lsiDescCSC.ColView(mangaMatchCheckIndex))
-
-				// Reject invalid matches
-				if math.IsNaN(distTag) || distTag < 1e-4 {
-					distTag = 0
-				}
-				if math.IsNaN(distDesc) || distDesc < 1e-4 {
-					distDesc = 0
-				}
-
-				// Special reject criteria to try to be robust to small label / description length
-				if numTags < ignoreTagsUnderCount {
-					distTag = 1
-				}
-				if distDesc < ignoreDescScoreUnder || corpusDescLength[mangaMatchCheckIndex] < minDescriptionWords {
-					distDesc = 0
-				}
-				if distDesc > acceptDescScoreOver {
-					distTag = 1
-				}
-
-				// Combine the two
-				match := customMatch{}
-				match.ID = mangaMatchCheckIndex
-				match.Distance = tagScoreRatio*distTag + distDesc
-				match.DistanceTag = distTag
-				match.DistanceDesc = distDesc
-				matches = append(matches, match)
+			fmt.Fprintf(&sb, "Manga %d has %d tags -> %s - https://mangadex.org/title/%s\n", currentMangaIndex, numTags, (*currentManga.Title)["en"], currentManga.Id)
 
+			// Perform matching to all the candidates of this manga
+			var skippedBuilder *strings.Builder
+			if skippedMode {
+				skippedBuilder = &sb
 			}
-			sort.Slice(matches, func(i, j int) bool {
-				return matches[i].Distance > matches[j].Distance
-			})
-
-			fmt.Fprintf(&sb, "Manga %d has %d tags -> %s - https://mangadex.org/title/%s\n", currentMangaIndex, numTags, (*currentManga.Title)["en"], currentManga.Id)
+			matchesBest := corpus.findMatches(currentMangaIndex, index.Candidates(currentMangaIndex), skippedBuilder)
+			matchesRelated := corpus.findRelatedMatches(currentMangaIndex)
 
 			// Create our calculate manga api object which will have our matches in it
 			similarMangaData := internal.SimilarManga{}
@@ -307,52 +227,35 @@ func calculateSimilars(debugMode bool, skippedMode bool) {
 			similarMangaData.Title = *currentManga.Title
 			similarMangaData.ContentRating = currentManga.ContentRating
 			similarMangaData.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05+00:00")
+			similarMangaData.ConfigHash = config.Hash()
 
-			// Finally loop through all our matches and try to find the best ones!
-			var matchesBest []customMatch
-			for _, match := range matches {
-
-				matchIndex := match.ID.(int)
-
-				matchManga := mangaList[matchIndex]
-
-				if invalid, reason := invalidForProcessing(match, currentMangaIndex, currentManga, matchManga); invalid {
-					if skippedMode {
-						fmt.Fprintf(&sb, "  | skipped because %s ->%s - https://mangadex.org/title/%s\n", reason, truncateText((*matchManga.Title)["en"], 30), matchManga.Id)
-					}
-					continue
+			for _, match := range matchesBest {
+				matchData, err := newSimilarMatch(similarMangaData.Id, mangaList[match.ID.(int)], match, config)
+				if err != nil {
+					summary.Add("scoring similar", err)
+					<-guard
+					return
 				}
-
-				// Otherwise lets append it!
-				matchData := internal.SimilarMatch{}
-				matchData.Id = matchManga.Id
-				matchData.Title = *matchManga.Title
-				matchData.ContentRating = matchManga.ContentRating
-				matchData.Score = float32(match.Distance) / float32(tagScoreRatio+1.0)
-				matchData.Languages = matchManga.AvailableTranslatedLanguages
 				similarMangaData.SimilarMatches = append(similarMangaData.SimilarMatches, matchData)
-				matchesBest = append(matchesBest, match)
-
-				// Debug error if score is invalid
-				if matchData.Score > 1 || matchData.Score < 0 {
-					log.Fatalf("\u001B[1;31mINVALID SCORE: %s -> %s gave %.4f\u001B[0m\n", similarMangaData.Id, matchManga.Id, matchData.Score)
-				}
-
-				// Exit if we have found enough calculate manga!
-				if len(similarMangaData.SimilarMatches) >= numSimToGet {
-					break
+			}
+			for _, match := range matchesRelated {
+				matchData, err := newSimilarMatch(similarMangaData.Id, mangaList[match.ID.(int)], match, config)
+				if err != nil {
+					summary.Add("scoring similar", err)
+					<-guard
+					return
 				}
-
+				similarMangaData.RelatedMatches = append(similarMangaData.RelatedMatches, matchData)
 			}
 
 			// Finally if we have non-zero matches then we should save it!
-			if len(similarMangaData.SimilarMatches) > 0 {
+			if len(similarMangaData.SimilarMatches) > 0 || len(similarMangaData.RelatedMatches) > 0 {
 				if !debugMode {
-					InsertSimilarData(similarMangaData)
+					summary.Add("storing similar", InsertSimilarData(similarMangaData))
 				}
 			}
 			countMangasProcessed++
-			avgIterTime := float64(currentMangaIndex+1) / time.Since(start).Seconds()
+			avgIterTime := float64(processIndex+1) / time.Since(start).Seconds()
 
 			for i, match := range matchesBest {
 				id := match.ID.(int)
@@ -360,22 +263,73 @@ func calculateSimilars(debugMode bool, skippedMode bool) {
 				fmt.Fprintf(&sb, "  | matched %d (%.3f tag, %.3f desc, %.3f comb) -> %s - https://mangadex.org/title/%s\n",
 					id, match.DistanceTag, match.DistanceDesc, score, truncateText((*mangaList[id].Title)["en"], 30), mangaList[id].Id)
 			}
+			for i, match := range matchesRelated {
+				id := match.ID.(int)
+				fmt.Fprintf(&sb, "  | related %s (%.3f comb) -> %s - https://mangadex.org/title/%s\n",
+					match.Relation, similarMangaData.RelatedMatches[i].Score, truncateText((*mangaList[id].Title)["en"], 30), mangaList[id].Id)
+			}
 			if !debugMode {
 				//This line makes no sense if we are in debug mode
-				fmt.Fprintf(&sb, "%d/%d processed at %.2f manga/sec....\n\n", currentMangaIndex+1, amountOfMangaToProcess, avgIterTime)
+				fmt.Fprintf(&sb, "%d/%d processed at %.2f manga/sec....\n\n", processIndex+1, amountOfMangaToProcess, avgIterTime)
 			}
 			fmt.Println(sb.String())
 
 			<-guard
-		}(currentMangaIndex)
+		}(processIndex, currentMangaIndex)
 
 	}
 	wg.Wait()
 
+	// Store what this run was fitted with, so the next incremental run can continue from it
+	// Skipped manga are only recalculated by the next incremental run if the last update isn't moved on
+	if !debugMode {
+		if !incremental {
+			err = similar.SaveModel(similarModelFile, corpus.model)
+			summary.CheckErr(err)
+		}
+		if summary.Len() == 0 {
+			summary.CheckErr(writeTimestampFile(lastSimilarUpdateFile, lastMetadataUpdate))
+		} else {
+			fmt.Printf("Not moving %s on, so the skipped manga are recalculated next run\n", lastSimilarUpdateFile)
+		}
+	}
+
 	fmt.Printf("Calculated simularities for %d Manga in %s\n\n", amountOfMangaToProcess, time.Since(startProcessing))
 
 }
 
+// Manga changed since the last run, plus the manga whose stored matches reference a changed manga
+// Stored matches which can't be read are recalculated too
+func affectedSinceLastRun(lastSimilarUpdate string) (map[string]bool, error) {
+	changedIds, err := internal.GetMangaIdsChangedSince(lastSimilarUpdate)
+	if err != nil {
+		return nil, err
+	}
+	affectedIds := map[string]bool{}
+	for uuid := range changedIds {
+		affectedIds[uuid] = true
+	}
+
+	dbSimilarList, err := getDBSimilar()
+	if err != nil {
+		return nil, err
+	}
+	for _, dbSimilar := range dbSimilarList {
+		similarManga := internal.SimilarManga{}
+		if err := json.Unmarshal([]byte(dbSimilar.JSON), &similarManga); err != nil {
+			affectedIds[dbSimilar.Id] = true
+			continue
+		}
+		for _, match := range append(similarManga.SimilarMatches, similarManga.RelatedMatches...) {
+			if changedIds[match.Id] {
+				affectedIds[similarManga.Id] = true
+				break
+			}
+		}
+	}
+	return affectedIds, nil
+}
+
 func truncateText(text string, maxLen int) string {
 	lastSpaceIx := maxLen
 	length := 0
@@ -391,7 +345,26 @@ func truncateText(text string, maxLen int) string {
 	return text
 }
 
-func invalidForProcessing(match customMatch, currentMangaIndex int, currentManga internal.Manga, matchManga internal.Manga) (bool, string) {
+func invalidForProcessing(match customMatch, currentMangaIndex int, currentManga internal.Manga, matchManga internal.Manga, config similar.Config) (bool, string) {
+	if invalid, reason := invalidCandidate(match, currentMangaIndex, currentManga, matchManga); invalid {
+		return true, reason
+	}
+
+	// Tags / content ratings / demographics we enforce
+	// Also enforce that the manga can't be *related* to the match
+	return similar.NotValidMatch(currentManga, matchManga, config)
+}
+
+// invalidForProcessing for a related manga listed separately, which skips the relation policy that lists it
+func invalidRelatedForProcessing(match customMatch, currentMangaIndex int, currentManga internal.Manga, matchManga internal.Manga, config similar.Config) (bool, string) {
+	if invalid, reason := invalidCandidate(match, currentMangaIndex, currentManga, matchManga); invalid {
+		return true, reason
+	}
+	return similar.NotValidRelatedMatch(currentManga, matchManga, config)
+}
+
+// The score, uuid and language rules every candidate has to pass
+func invalidCandidate(match customMatch, currentMangaIndex int, currentManga internal.Manga, matchManga internal.Manga) (bool, string) {
 	// Skip if not a valid score
 	if match.Distance <= 0 {
 		return true, "Invalid Score"
@@ -420,14 +393,23 @@ func invalidForProcessing(match customMatch, currentMangaIndex int, currentManga
 	if !foundCommonLang && len(currentManga.AvailableTranslatedLanguages) > 0 {
 		return true, "No Common Languages"
 	}
+	return false, ""
+}
 
-	// Tags / content ratings / demographics we enforce
-	// Also enforce that the manga can't be *related* to the match
-	if similar.NotValidMatch(currentManga, matchManga) {
-		return true, "Tag Check"
+func newSimilarMatch(mangaId string, matchManga internal.Manga, match customMatch, config similar.Config) (internal.SimilarMatch, error) {
+	matchData := internal.SimilarMatch{}
+	matchData.Id = matchManga.Id
+	matchData.Title = *matchManga.Title
+	matchData.ContentRating = matchManga.ContentRating
+	matchData.Score = float32(match.Distance / config.MaxScore())
+	matchData.Languages = matchManga.AvailableTranslatedLanguages
+	matchData.Relation = match.Relation
+
+	// Debug error if score is invalid
+	if matchData.Score > 1 || matchData.Score < 0 {
+		return matchData, fmt.Errorf("invalid score: %s -> %s gave %.4f", mangaId, matchManga.Id, matchData.Score)
 	}
-
-	return false, ""
+	return matchData, nil
 }
 
 // Type of match which also stores the description
@@ -437,22 +419,35 @@ type customMatch struct {
 	Distance     float64
 	DistanceTag  float64
 	DistanceDesc float64
+	// Optional metadata scores, overlap of the authors and artists, release year proximity and same original language
+	DistanceCreator  float64
+	DistanceYear     float64
+	DistanceLanguage float64
+	// Relation type if this is a related match
+	Relation string
 }
 
-func exportSimilar() {
+func exportSimilar() error {
+	similarList, err := getDBSimilar()
+	if err != nil {
+		return err
+	}
 	os.RemoveAll("data/similar/")
 	os.MkdirAll("data/similar/", 0777)
-	similarList := getDBSimilar()
 	for _, similar := range similarList {
 		folder := similar.Id[0:2]
 		suffix := similar.Id[0:3]
 		os.Mkdir("data/similar/"+folder, 0777)
 		file, err := os.OpenFile("data/similar/"+folder+"/"+suffix+".html", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0777)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("exporting similar of %s: %w", similar.Id, err)
 		}
-		file.WriteString(similar.Id + ":::||@!@||:::" + similar.JSON + "\n")
+		_, err = file.WriteString(similar.Id + ":::||@!@||:::" + similar.JSON + "\n")
 		file.Close()
+		if err != nil {
+			return fmt.Errorf("exporting similar of %s: %w", similar.Id, err)
+		}
 
 	}
+	return nil
 }
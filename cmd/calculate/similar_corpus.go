@@ -0,0 +1,421 @@
+package calculate
+
+import (
+	"fmt"
+	"github.com/james-bowman/nlp"
+	"github.com/james-bowman/nlp/measures/pairwise"
+	"github.com/james-bowman/sparse"
+	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
+	"github.com/similar-manga/similar/internal"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The fitted tag and description vectors of every manga we will match between
+// Column i of each matrix is the manga at mangaList[i]
+type similarCorpus struct {
+	config     similar.Config
+	mangaList  []internal.Manga
+	mangaIndex map[string]int
+	// Indexes of the manga which list the uuid as related, the reverse of RelatedIds and Relations
+	relatedBy      map[string][]int
+	descLength     []int
+	tagCSC         *sparse.CSC
+	tagWeightedCSC *sparse.CSC
+	descCSC        *sparse.CSC
+	descLsa        *mat.Dense
+	model          *similar.Model
+}
+
+// Vectorises the tags and descriptions of every manga
+// If model is nil a new vocabulary, idf weights and tag catalogue are fitted to the corpus, otherwise the stored ones are used
+func buildSimilarCorpus(mangaList []internal.Manga, config similar.Config, model *similar.Model) (*similarCorpus, error) {
+	if model != nil {
+		config.TagCatalogue = model.TagCatalogue
+	} else {
+		dbTags, err := internal.GetAllTags()
+		if err != nil {
+			return nil, err
+		}
+		config.TagCatalogue = similar.NewTagCatalogue(mangaList, dbTags)
+	}
+	corpus := &similarCorpus{config: config, mangaIndex: map[string]int{}, relatedBy: map[string][]int{}}
+
+	var corpusTag []string
+	var corpusDesc []string
+
+	fmt.Println("Begin loading into corpus")
+
+	for _, manga := range mangaList {
+		// Skip if invalid, this should hardily ever occur
+		if manga.Title == nil || manga.Description == nil {
+			fmt.Printf("!!! Manga with Id %s had nil title or nil description", manga.Id)
+			continue
+		}
+
+		// Get the tag and description for this manga
+		tagText := similar.TagText(manga)
+		descText := similar.DescriptionText(manga)
+
+		// Append to the corpusDesc
+		corpus.mangaIndex[manga.Id] = len(corpus.mangaList)
+		corpus.mangaList = append(corpus.mangaList, manga)
+		corpusTag = append(corpusTag, tagText)
+		corpusDesc = append(corpusDesc, descText)
+		corpus.descLength = append(corpus.descLength, len(strings.Split(descText, " ")))
+	}
+	for index, manga := range corpus.mangaList {
+		for _, relatedId := range relatedIds(manga) {
+			corpus.relatedBy[relatedId] = append(corpus.relatedBy[relatedId], index)
+		}
+	}
+
+	fmt.Printf("\n\nLoaded %d Manga into our corpus\n\n", len(corpusDesc))
+
+	// Create our tf-idf pipeline
+	var err error
+	lsiTagVectoriser := similar.NewTagVectoriser(model)
+	lsiPipelineTag := nlp.NewPipeline(lsiTagVectoriser)
+	lsiDescVectoriser := similar.NewDescriptionVectoriser(model)
+	lsiDescTfidf := nlp.NewTfidfTransformer()
+	if model != nil {
+		lsiDescTfidf, err = model.TfidfTransformer()
+		if err != nil {
+			return nil, err
+		}
+	}
+	lsiPipelineDescription := nlp.NewPipeline(lsiDescVectoriser, lsiDescTfidf)
+
+	// Transform the corpusTag into an LSI fitting the model to the documents in the process
+	start := time.Now()
+	var lsiTag mat.Matrix
+	if model != nil {
+		fmt.Printf("transforming corpus of tags with stored vocabulary!\n")
+		lsiTag, err = lsiPipelineTag.Transform(corpusTag...)
+	} else {
+		fmt.Printf("fitting to corpus of tags!\n")
+		lsiTag, err = lsiPipelineTag.FitTransform(corpusTag...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to process documents: %w", err)
+	}
+	corpus.tagCSC = lsiTag.(sparse.TypeConverter).ToCSC()
+	m, n := lsiTag.Dims()
+	fmt.Printf("\t- fitted data in %s\n", time.Since(start))
+	fmt.Printf("\t- system dim = %d x %d\n\n", m, n)
+
+	// We will now apply our custom weights for tags
+	// Each row of this matrix is a tag which we have a weight for
+	fmt.Println("Tag Vectoriser Vocabulary:")
+	fmt.Println(lsiTagVectoriser.Vocabulary)
+	fmt.Println()
+	corpus.tagWeightedCSC = similar.WeightTags(corpus.tagCSC, lsiTagVectoriser.Vocabulary, config)
+
+	// Transform the corpusDesc into an LSI fitting the model to the documents in the process
+	start = time.Now()
+	var lsiDesc mat.Matrix
+	if model != nil {
+		fmt.Printf("transforming corpus of descriptions with stored vocabulary!\n")
+		lsiDesc, err = lsiPipelineDescription.Transform(corpusDesc...)
+	} else {
+		fmt.Printf("fitting to corpus of descriptions!\n")
+		lsiDesc, err = lsiPipelineDescription.FitTransform(corpusDesc...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to process documents: %w", err)
+	}
+	corpus.descCSC = lsiDesc.(sparse.TypeConverter).ToCSC()
+	m, n = lsiDesc.Dims()
+	fmt.Printf("\t- fitted data in %s\n", time.Since(start))
+	fmt.Printf("\t- system dim = %d x %d\n\n", m, n)
+
+	// Optionally project the descriptions onto their latent semantic dimensions
+	var lsiDescSvd *nlp.TruncatedSVD
+	if model != nil {
+		lsiDescSvd, err = model.TruncatedSVD()
+		if err != nil {
+			return nil, err
+		}
+	} else if config.LsaDimensions > 0 {
+		fmt.Printf("fitting svd to corpus of descriptions!\n")
+		start = time.Now()
+		lsiDescSvd, err = similar.FitTruncatedSVD(corpus.descCSC, config.LsaDimensions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process documents: %w", err)
+		}
+	}
+	if lsiDescSvd != nil {
+		if model != nil {
+			fmt.Printf("projecting corpus of descriptions with stored svd!\n")
+			start = time.Now()
+		}
+		lsa := similar.ProjectSVD(lsiDescSvd, corpus.descCSC)
+		corpus.descLsa = normaliseColumns(lsa)
+		m, n = lsa.Dims()
+		fmt.Printf("\t- fitted data in %s\n", time.Since(start))
+		fmt.Printf("\t- system dim = %d x %d\n\n", m, n)
+	}
+
+	corpus.model = model
+	if model == nil {
+		corpus.model, err = similar.NewModel(config, lsiTagVectoriser, lsiDescVectoriser, lsiDescTfidf, lsiDescSvd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return corpus, nil
+}
+
+// Copy of the matrix with each column scaled to unit length, so the dot product of two columns is their cosine
+func normaliseColumns(matrix mat.Matrix) *mat.Dense {
+	normalised := mat.DenseCopyOf(matrix)
+	_, dimC := normalised.Dims()
+	for c := 0; c < dimC; c++ {
+		column := normalised.ColView(c).(*mat.VecDense)
+		if norm := mat.Norm(column, 2); norm > 0 {
+			column.ScaleVec(1/norm, column)
+		}
+	}
+	return normalised
+}
+
+// Cosine similarity of two descriptions, in the latent space if the lsa stage is enabled
+func (c *similarCorpus) descSimilarity(currentMangaIndex int, mangaMatchCheckIndex int) float64 {
+	if c.descLsa != nil {
+		return mat.Dot(c.descLsa.ColView(currentMangaIndex), c.descLsa.ColView(mangaMatchCheckIndex))
+	}
+	return pairwise.CosineSimilarity(c.descCSC.ColView(currentMangaIndex), c.descCSC.ColView(mangaMatchCheckIndex))
+}
+
+// Index used to generate the candidates of each manga in the corpus
+func (c *similarCorpus) candidateIndex(indexMode string, numCandidates int, maxPostingLength int) (similar.CandidateIndex, error) {
+	switch indexMode {
+	case "brute":
+		return similar.BruteForceIndex{Size: len(c.mangaList)}, nil
+	case "inverted":
+		start := time.Now()
+		fmt.Printf("building inverted index of tags and descriptions!\n")
+		index := similar.NewInvertedIndex(numCandidates, maxPostingLength,
+			similar.IndexField{Matrix: c.tagWeightedCSC, Weight: c.config.TagScoreRatio},
+			similar.IndexField{Matrix: c.descCSC, Weight: 1.0})
+		fmt.Printf("\t- built index in %s\n\n", time.Since(start))
+
+		// Synonyms share no tf-idf terms, so the nearest descriptions in the latent space can be candidates too
+		if c.descLsa != nil && c.config.LsaCandidates {
+			fmt.Printf("adding the nearest lsa descriptions as candidates!\n\n")
+			return similar.CombinedIndex{index, similar.LatentIndex{NumCandidates: numCandidates, Vectors: c.descLsa}}, nil
+		}
+		return index, nil
+	}
+	return nil, fmt.Errorf("unknown index mode %s", indexMode)
+}
+
+// Scores the manga at currentMangaIndex against each of the candidates, returning the best valid matches
+// If sb is not nil the reason each candidate was skipped is written to it
+func (c *similarCorpus) findMatches(currentMangaIndex int, candidates []int, sb *strings.Builder) []customMatch {
+
+	// This manga we will try to match to
+	// NOTE: here we use the weighted tag CSC matrix, so we will multiply this against a one-hot-matrix
+	// NOTE: e.g. [0.7 1.0 0.0 0.0 0.9] * [0 1 0 0 1] => 1.9 score value for current against another
+	currentManga := c.mangaList[currentMangaIndex]
+
+	vTagWeighted := c.tagWeightedCSC.ColView(currentMangaIndex)
+	numTags := int(mat.Sum(c.tagCSC.ColView(currentMangaIndex)))
+
+	// Perform matching to all the candidate vectors
+	var matches []customMatch
+	for _, mangaMatchCheckIndex := range candidates {
+		matches = append(matches, c.scoreMatch(currentMangaIndex, mangaMatchCheckIndex, vTagWeighted, numTags))
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Distance > matches[j].Distance
+	})
+
+	// Finally loop through all our matches and try to find the best ones!
+	// Matches with a demoted publication status are held back and only fill the slots left at the end
+	var matchesBest []customMatch
+	var matchesDemoted []customMatch
+	for _, match := range matches {
+
+		matchManga := c.mangaList[match.ID.(int)]
+
+		if invalid, reason := invalidForProcessing(match, currentMangaIndex, currentManga, matchManga, c.config); invalid {
+			if sb != nil {
+				fmt.Fprintf(sb, "  | skipped because %s ->%s - https://mangadex.org/title/%s\n", reason, truncateText((*matchManga.Title)["en"], 30), matchManga.Id)
+			}
+			continue
+		}
+		if c.config.IsDemotedStatus(matchManga.Status) {
+			if sb != nil {
+				fmt.Fprintf(sb, "  | demoted because %s ->%s - https://mangadex.org/title/%s\n", matchManga.Status, truncateText((*matchManga.Title)["en"], 30), matchManga.Id)
+			}
+			matchesDemoted = append(matchesDemoted, match)
+			continue
+		}
+		matchesBest = append(matchesBest, match)
+
+		// Exit if we have found enough calculate manga!
+		if len(matchesBest) >= c.config.NumSimToGet {
+			break
+		}
+	}
+	for _, match := range matchesDemoted {
+		if len(matchesBest) >= c.config.NumSimToGet {
+			break
+		}
+		matchesBest = append(matchesBest, match)
+	}
+	return matchesBest
+}
+
+// Scores the related manga whose relation type has the "separate" policy, these are listed apart from the matches
+// Manga related either way are found, and they have to pass the same rules as matches bar the relation policy
+func (c *similarCorpus) findRelatedMatches(currentMangaIndex int) []customMatch {
+	currentManga := c.mangaList[currentMangaIndex]
+	vTagWeighted := c.tagWeightedCSC.ColView(currentMangaIndex)
+	numTags := int(mat.Sum(c.tagCSC.ColView(currentMangaIndex)))
+
+	candidates := append([]int{}, c.relatedBy[currentManga.Id]...)
+	for _, relatedId := range relatedIds(currentManga) {
+		if mangaMatchCheckIndex, ok := c.mangaIndex[relatedId]; ok {
+			candidates = append(candidates, mangaMatchCheckIndex)
+		}
+	}
+
+	var matches []customMatch
+	seen := map[int]bool{}
+	for _, mangaMatchCheckIndex := range candidates {
+		if seen[mangaMatchCheckIndex] {
+			continue
+		}
+		seen[mangaMatchCheckIndex] = true
+		matchManga := c.mangaList[mangaMatchCheckIndex]
+		relation, _ := similar.RelationBetween(currentManga, matchManga)
+		if c.config.RelationPolicyOf(relation) != similar.RelationSeparate {
+			continue
+		}
+		match := c.scoreMatch(currentMangaIndex, mangaMatchCheckIndex, vTagWeighted, numTags)
+		if invalid, _ := invalidRelatedForProcessing(match, currentMangaIndex, currentManga, matchManga, c.config); invalid {
+			continue
+		}
+		match.Relation = relation
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Distance > matches[j].Distance
+	})
+	if len(matches) > c.config.NumSimToGet {
+		matches = matches[:c.config.NumSimToGet]
+	}
+	return matches
+}
+
+// Gets the tag, description and metadata scores of the manga at mangaMatchCheckIndex as a match for the current manga
+func (c *similarCorpus) scoreMatch(currentMangaIndex int, mangaMatchCheckIndex int, vTagWeighted mat.Vector, numTags int) customMatch {
+	distTag := pairwise.CosineSimilarity(vTagWeighted, c.tagCSC.ColView(mangaMatchCheckIndex))
+	distDesc := c.descSimilarity(currentMangaIndex, mangaMatchCheckIndex)
+	return c.combineScores(currentMangaIndex, mangaMatchCheckIndex, numTags, distTag, distDesc)
+}
+
+// Combines the raw tag and description cosine similarities against the manga at mangaMatchCheckIndex into a single match
+// together with the optional creator, year and original language scores
+func (c *similarCorpus) combineScores(currentMangaIndex int, mangaMatchCheckIndex int, numTags int, distTag float64, distDesc float64) customMatch {
+
+	// Reject invalid matches
+	if math.IsNaN(distTag) || distTag < 1e-4 {
+		distTag = 0
+	}
+	if math.IsNaN(distDesc) || distDesc < 1e-4 {
+		distDesc = 0
+	}
+
+	// Special reject criteria to try to be robust to small label / description length
+	if numTags < c.config.IgnoreTagsUnderCount {
+		distTag = 1
+	}
+	if distDesc < c.config.IgnoreDescScoreUnder || c.descLength[mangaMatchCheckIndex] < c.config.MinDescriptionWords {
+		distDesc = 0
+	}
+	if distDesc > c.config.AcceptDescScoreOver {
+		distTag = 1
+	}
+
+	// The metadata scores are only worked out if they count towards the combined score
+	currentManga := c.mangaList[currentMangaIndex]
+	matchManga := c.mangaList[mangaMatchCheckIndex]
+	distCreator, distYear, distLanguage := 0.0, 0.0, 0.0
+	if c.config.CreatorScoreRatio > 0 {
+		distCreator = similar.CreatorOverlap(currentManga, matchManga)
+	}
+	if c.config.YearScoreRatio > 0 {
+		distYear = similar.YearProximity(currentManga, matchManga, c.config.YearScoreRange)
+	}
+	if c.config.LanguageScoreRatio > 0 {
+		distLanguage = similar.LanguageAffinity(currentManga, matchManga)
+	}
+
+	// Combine them all
+	match := customMatch{}
+	match.ID = mangaMatchCheckIndex
+	match.Distance = c.config.TagScoreRatio*distTag + distDesc +
+		c.config.CreatorScoreRatio*distCreator + c.config.YearScoreRatio*distYear + c.config.LanguageScoreRatio*distLanguage
+	match.DistanceTag = distTag
+	match.DistanceDesc = distDesc
+	match.DistanceCreator = distCreator
+	match.DistanceYear = distYear
+	match.DistanceLanguage = distLanguage
+	return match
+}
+
+// Compares the matches found through the candidate index against exact brute force matching
+// on an evenly spaced sample of the corpus, and prints the average recall
+func (c *similarCorpus) measureRecall(index similar.CandidateIndex, sampleSize int) {
+	start := time.Now()
+	bruteForce := similar.BruteForceIndex{Size: len(c.mangaList)}
+	step := len(c.mangaList) / sampleSize
+	if step < 1 {
+		step = 1
+	}
+
+	totalRecall := 0.0
+	countSampled := 0
+	for currentMangaIndex := 0; currentMangaIndex < len(c.mangaList) && countSampled < sampleSize; currentMangaIndex += step {
+		if c.descLength[currentMangaIndex] < c.config.MinDescriptionWords {
+			continue
+		}
+		exact := c.findMatches(currentMangaIndex, bruteForce.Candidates(currentMangaIndex), nil)
+		if len(exact) == 0 {
+			continue
+		}
+		approx := map[int]bool{}
+		for _, match := range c.findMatches(currentMangaIndex, index.Candidates(currentMangaIndex), nil) {
+			approx[match.ID.(int)] = true
+		}
+		found := 0
+		for _, match := range exact {
+			if approx[match.ID.(int)] {
+				found++
+			}
+		}
+		totalRecall += float64(found) / float64(len(exact))
+		countSampled++
+	}
+
+	if countSampled == 0 {
+		fmt.Printf("No manga could be sampled to measure recall\n\n")
+		return
+	}
+	fmt.Printf("Index recall against brute force is %.4f over %d manga (%s)\n\n", totalRecall/float64(countSampled), countSampled, time.Since(start))
+}
+
+// The uuids a manga lists as related, from both its related ids and its typed relations
+func relatedIds(manga internal.Manga) []string {
+	ids := append([]string{}, manga.RelatedIds...)
+	for _, relation := range manga.Relations {
+		ids = append(ids, relation.Id)
+	}
+	return ids
+}
@@ -0,0 +1,192 @@
+package similar_helpers
+
+import (
+	"container/heap"
+	"github.com/james-bowman/sparse"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"sort"
+)
+
+// CandidateIndex returns which manga in the corpus are worth scoring against a given manga.
+// The full tag / description scoring is then only applied to these candidates.
+type CandidateIndex interface {
+	Candidates(index int) []int
+}
+
+// BruteForceIndex returns every manga in the corpus as a candidate (exact, but O(N²) overall)
+type BruteForceIndex struct {
+	Size int
+}
+
+func (b BruteForceIndex) Candidates(index int) []int {
+	candidates := make([]int, b.Size)
+	for i := range candidates {
+		candidates[i] = i
+	}
+	return candidates
+}
+
+// IndexField is a term document matrix (terms are rows, manga are columns) which is added
+// into an InvertedIndex, its cosine contribution to the candidate score is scaled by Weight
+type IndexField struct {
+	Matrix *sparse.CSC
+	Weight float64
+}
+
+type posting struct {
+	id     int
+	weight float64
+}
+
+// InvertedIndex is an approximate nearest-neighbour index over the non-zero terms of each manga.
+// Only manga sharing at least one term are ever considered, and terms which appear in more than
+// MaxPostingLength manga are skipped since they are both expensive and carry little information.
+type InvertedIndex struct {
+	NumCandidates    int
+	MaxPostingLength int
+	postings         [][]posting
+	documents        [][]posting
+}
+
+func NewInvertedIndex(numCandidates int, maxPostingLength int, fields ...IndexField) *InvertedIndex {
+	index := &InvertedIndex{
+		NumCandidates:    numCandidates,
+		MaxPostingLength: maxPostingLength,
+	}
+
+	termOffset := 0
+	for _, field := range fields {
+		numTerms, numDocs := field.Matrix.Dims()
+		if index.documents == nil {
+			index.documents = make([][]posting, numDocs)
+		}
+		index.postings = append(index.postings, make([][]posting, numTerms)...)
+
+		for doc := 0; doc < numDocs; doc++ {
+
+			// Normalise each manga so the accumulated dot product is the cosine similarity
+			norm := 0.0
+			field.Matrix.DoColNonZero(doc, func(term, doc int, v float64) {
+				norm += v * v
+			})
+			if norm == 0 {
+				continue
+			}
+			norm = math.Sqrt(norm)
+
+			field.Matrix.DoColNonZero(doc, func(term, doc int, v float64) {
+				weight := v / norm
+				index.documents[doc] = append(index.documents[doc], posting{id: termOffset + term, weight: field.Weight * weight})
+				index.postings[termOffset+term] = append(index.postings[termOffset+term], posting{id: doc, weight: weight})
+			})
+		}
+		termOffset += numTerms
+	}
+	return index
+}
+
+func (idx *InvertedIndex) Candidates(index int) []int {
+	scores := map[int]float64{}
+	for _, term := range idx.documents[index] {
+		postingList := idx.postings[term.id]
+		if idx.MaxPostingLength > 0 && len(postingList) > idx.MaxPostingLength {
+			continue
+		}
+		for _, p := range postingList {
+			scores[p.id] += term.weight * p.weight
+		}
+	}
+
+	candidates := make([]int, 0, len(scores))
+	for id := range scores {
+		candidates = append(candidates, id)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if scores[candidates[i]] == scores[candidates[j]] {
+			return candidates[i] < candidates[j]
+		}
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+	if idx.NumCandidates > 0 && len(candidates) > idx.NumCandidates {
+		candidates = candidates[:idx.NumCandidates]
+	}
+	return candidates
+}
+
+// LatentIndex is an exact nearest-neighbour index over dense unit length vectors, such as the lsa projection
+// of the descriptions, so manga with similar descriptions are found even when they share no terms.
+// Each call scans every vector, so candidates for the whole corpus cost O(N² × dimensions) like BruteForceIndex.
+type LatentIndex struct {
+	NumCandidates int
+	// Each column is the unit length vector of a manga
+	Vectors *mat.Dense
+}
+
+func (idx LatentIndex) Candidates(index int) []int {
+	_, numDocs := idx.Vectors.Dims()
+	current := idx.Vectors.ColView(index)
+	best := &candidateHeap{}
+	for doc := 0; doc < numDocs; doc++ {
+		score := mat.Dot(current, idx.Vectors.ColView(doc))
+		if score <= 0 {
+			continue
+		}
+		candidate := posting{id: doc, weight: score}
+		if idx.NumCandidates <= 0 || best.Len() < idx.NumCandidates {
+			heap.Push(best, candidate)
+		} else if best.less(best.postings[0], candidate) {
+			best.postings[0] = candidate
+			heap.Fix(best, 0)
+		}
+	}
+
+	sort.Slice(best.postings, func(i, j int) bool {
+		return best.less(best.postings[j], best.postings[i])
+	})
+	candidates := make([]int, len(best.postings))
+	for i, p := range best.postings {
+		candidates[i] = p.id
+	}
+	return candidates
+}
+
+// Min-heap of the best candidates found so far, the worst of them is at the top
+type candidateHeap struct {
+	postings []posting
+}
+
+// A posting is worse than another with a lower weight, or the same weight and a higher id
+func (h *candidateHeap) less(a posting, b posting) bool {
+	if a.weight == b.weight {
+		return a.id > b.id
+	}
+	return a.weight < b.weight
+}
+
+func (h *candidateHeap) Len() int           { return len(h.postings) }
+func (h *candidateHeap) Less(i, j int) bool { return h.less(h.postings[i], h.postings[j]) }
+func (h *candidateHeap) Swap(i, j int)      { h.postings[i], h.postings[j] = h.postings[j], h.postings[i] }
+func (h *candidateHeap) Push(x interface{}) { h.postings = append(h.postings, x.(posting)) }
+func (h *candidateHeap) Pop() interface{} {
+	last := h.postings[len(h.postings)-1]
+	h.postings = h.postings[:len(h.postings)-1]
+	return last
+}
+
+// CombinedIndex returns the candidates of every index, each manga once in the order they were first found
+type CombinedIndex []CandidateIndex
+
+func (c CombinedIndex) Candidates(index int) []int {
+	var candidates []int
+	seen := map[int]bool{}
+	for _, candidateIndex := range c {
+		for _, candidate := range candidateIndex.Candidates(index) {
+			if !seen[candidate] {
+				seen[candidate] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates
+}
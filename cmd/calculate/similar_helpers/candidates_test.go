@@ -0,0 +1,166 @@
+package similar_helpers
+
+import (
+	"fmt"
+	"github.com/james-bowman/sparse"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"sort"
+	"testing"
+)
+
+// Terms are rows and manga are columns, term 0 is in four manga, manga 1 and 2 are identical and manga 5 is empty
+func invertedIndexMatrix() *sparse.CSC {
+	dense := mat.NewDense(4, 6, []float64{
+		1, 1, 1, 0, 1, 0,
+		2, 1, 1, 0, 0, 0,
+		0, 2, 2, 0, 0, 0,
+		0, 0, 0, 3, 1, 0,
+	})
+	coo := sparse.NewCOO(4, 6, nil, nil, nil)
+	dense.Apply(func(i, j int, v float64) float64 {
+		if v != 0 {
+			coo.Set(i, j, v)
+		}
+		return v
+	}, dense)
+	return coo.ToCSC()
+}
+
+func cosine(matrix *sparse.CSC, a int, b int) float64 {
+	dot, normA, normB := 0.0, 0.0, 0.0
+	numTerms, _ := matrix.Dims()
+	for term := 0; term < numTerms; term++ {
+		dot += matrix.At(term, a) * matrix.At(term, b)
+		normA += matrix.At(term, a) * matrix.At(term, a)
+		normB += matrix.At(term, b) * matrix.At(term, b)
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// Scores every candidate of the brute force index by its weighted cosine, the ranking the inverted index approximates
+func bruteForceRanking(index int, fields ...IndexField) []int {
+	_, numDocs := fields[0].Matrix.Dims()
+	scores := map[int]float64{}
+	var ranking []int
+	for _, candidate := range (BruteForceIndex{Size: numDocs}).Candidates(index) {
+		for _, field := range fields {
+			scores[candidate] += field.Weight * cosine(field.Matrix, index, candidate)
+		}
+		if scores[candidate] > 0 {
+			ranking = append(ranking, candidate)
+		}
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return scores[ranking[i]]-scores[ranking[j]] > 1e-9
+	})
+	return ranking
+}
+
+func TestInvertedIndexMatchesTheBruteForceRanking(t *testing.T) {
+	matrix := invertedIndexMatrix()
+	tags := mat.NewDense(2, 6, []float64{
+		1, 0, 1, 0, 0, 0,
+		0, 1, 1, 1, 0, 0,
+	})
+	tagCOO := sparse.NewCOO(2, 6, nil, nil, nil)
+	tags.Apply(func(i, j int, v float64) float64 {
+		if v != 0 {
+			tagCOO.Set(i, j, v)
+		}
+		return v
+	}, tags)
+	fieldSets := [][]IndexField{
+		{{Matrix: matrix, Weight: 1}},
+		{{Matrix: tagCOO.ToCSC(), Weight: 0.5}, {Matrix: matrix, Weight: 1}},
+	}
+	for _, fields := range fieldSets {
+		index := NewInvertedIndex(0, 0, fields...)
+		for doc := 0; doc < 6; doc++ {
+			want := bruteForceRanking(doc, fields...)
+			if got := index.Candidates(doc); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%d fields, manga %d: got %v, want %v", len(fields), doc, got, want)
+			}
+		}
+	}
+}
+
+func TestInvertedIndexCandidates(t *testing.T) {
+	matrix := invertedIndexMatrix()
+	tests := []struct {
+		name             string
+		numCandidates    int
+		maxPostingLength int
+		index            int
+		candidates       []int
+	}{
+		// Manga 1 and 2 tie, the lower id comes first
+		{"ties ordered by id", 0, 0, 0, []int{0, 1, 2, 4}},
+		{"ties ordered by id for the later of them", 0, 0, 2, []int{1, 2, 0, 4}},
+		{"cut to the number of candidates", 2, 0, 0, []int{0, 1}},
+		{"shares only the common term", 0, 0, 4, []int{4, 3, 0, 1, 2}},
+		// Term 0 has four postings so it is skipped, manga 4 then only shares term 3 with manga 3,
+		// which scores higher than manga 4 itself since the skipped term still counts in the norm
+		{"postings over the maximum skipped", 0, 3, 4, []int{3, 4}},
+		{"postings at the maximum kept", 0, 4, 4, []int{4, 3, 0, 1, 2}},
+		{"all-zero manga", 0, 0, 5, []int{}},
+	}
+	for _, test := range tests {
+		index := NewInvertedIndex(test.numCandidates, test.maxPostingLength, IndexField{Matrix: matrix, Weight: 1})
+		if got := index.Candidates(test.index); fmt.Sprint(got) != fmt.Sprint(test.candidates) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.candidates)
+		}
+	}
+
+	// The all-zero manga isn't a candidate of any other
+	index := NewInvertedIndex(0, 0, IndexField{Matrix: matrix, Weight: 1})
+	for doc := 0; doc < 5; doc++ {
+		for _, candidate := range index.Candidates(doc) {
+			if candidate == 5 {
+				t.Errorf("all-zero manga is a candidate of manga %d", doc)
+			}
+		}
+	}
+}
+
+func TestLatentIndexReturnsTheNearestVectors(t *testing.T) {
+	// Columns are unit vectors at 0, 10, 80 and 180 degrees from the first, the last has no description at all
+	vectors := mat.NewDense(2, 5, []float64{
+		1, 0.98480775, 0.17364818, -1, 0,
+		0, 0.17364818, 0.98480775, 0, 0,
+	})
+	tests := []struct {
+		numCandidates int
+		candidates    []int
+	}{
+		{1, []int{0}},
+		{2, []int{0, 1}},
+		{10, []int{0, 1, 2}},
+		{0, []int{0, 1, 2}},
+	}
+	for _, test := range tests {
+		index := LatentIndex{NumCandidates: test.numCandidates, Vectors: vectors}
+		if got := index.Candidates(0); fmt.Sprint(got) != fmt.Sprint(test.candidates) {
+			t.Errorf("%d candidates: got %v, want %v", test.numCandidates, got, test.candidates)
+		}
+	}
+	if got := (LatentIndex{NumCandidates: 10, Vectors: vectors}).Candidates(4); len(got) != 0 {
+		t.Errorf("manga without a description got candidates %v", got)
+	}
+}
+
+type fixedIndex map[int][]int
+
+func (f fixedIndex) Candidates(index int) []int {
+	return f[index]
+}
+
+func TestCombinedIndexMergesCandidates(t *testing.T) {
+	index := CombinedIndex{fixedIndex{0: {3, 1}}, fixedIndex{0: {1, 2, 0}}}
+	if got := index.Candidates(0); fmt.Sprint(got) != fmt.Sprint([]int{3, 1, 2, 0}) {
+		t.Errorf("got %v, want [3 1 2 0]", got)
+	}
+}
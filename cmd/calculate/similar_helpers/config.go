@@ -0,0 +1,269 @@
+package similar_helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ConfigVersion is the only config file version this build understands
+const ConfigVersion = 1
+
+// Config holds all the tuning used when calculating similar manga
+type Config struct {
+	Version int `json:"version"`
+
+	// Number of matches to keep for each manga
+	NumSimToGet int `json:"numSimToGet"`
+
+	// How much the tag score counts compared to the description score
+	TagScoreRatio float64 `json:"tagScoreRatio"`
+
+	// Description scores under this are treated as no match at all
+	IgnoreDescScoreUnder float64 `json:"ignoreDescScoreUnder"`
+
+	// Description scores over this are accepted no matter the tags
+	AcceptDescScoreOver float64 `json:"acceptDescScoreOver"`
+
+	// Manga with fewer tags than this get a full tag score
+	IgnoreTagsUnderCount int `json:"ignoreTagsUnderCount"`
+
+	// Manga with fewer description words than this are not matched
+	MinDescriptionWords int `json:"minDescriptionWords"`
+
+	// Weight of tags which are not in TagWeights
+	DefaultTagWeight float64 `json:"defaultTagWeight"`
+
+	// Weights keyed by the tag UUID or the alphanumeric english tag name e.g. "sexualviolence"
+	// UUIDs are preferred since they survive the tag being renamed
+	TagWeights map[string]float64 `json:"tagWeights"`
+
+	// Weights of every tag in a group (genre, theme, format or content) which has no weight of its own
+	TagGroupWeights map[string]float64 `json:"tagGroupWeights,omitempty"`
+
+	// Tag UUIDs a match may only have if the current manga also has them, data/similar_config.json has
+	//	b11fda93-8f1d-4bef-b2ed-8803d3733170 4-Koma
+	//	b13b2a48-c720-44a9-9c77-39c9979373fb Doujinshi
+	//	b29d6a3d-1569-4e7a-8caf-7557bc92cd5d Gore
+	//	97893a4c-12af-4dac-b6be-0dffb353568e Sexual Violence
+	//	5920b825-4181-4a17-beeb-9918b0ff7a30 Boys' Love
+	//	a3c67850-4684-404e-9b7f-c69850ee5da6 Girls' Love
+	//	acc803a4-c95a-4c22-86fc-eb6b582d82a2 Wuxia
+	//	2d1f5d56-a1e5-4d0d-a961-2193588b08ec Loli
+	//	ddefd648-5140-4e5f-ba18-4eca4071d19b Shota
+	//	5bd0e105-4481-44ca-b6e7-7544da56b1a3 Incest
+	OneWayTags []string `json:"oneWayTags"`
+
+	// Groups whose tags are all one-way tags
+	OneWayTagGroups []string `json:"oneWayTagGroups,omitempty"`
+
+	// How much sharing authors or artists counts compared to the description score, 0 ignores them
+	CreatorScoreRatio float64 `json:"creatorScoreRatio,omitempty"`
+
+	// How much being released in nearby years counts compared to the description score, 0 ignores the year
+	YearScoreRatio float64 `json:"yearScoreRatio,omitempty"`
+
+	// Years apart at which the year score reaches 0, it falls linearly from 1 for the same year
+	YearScoreRange int `json:"yearScoreRange,omitempty"`
+
+	// How much sharing the original language (e.g. ja, ko or zh) counts compared to the description score
+	LanguageScoreRatio float64 `json:"languageScoreRatio,omitempty"`
+
+	// Matches with these publication statuses e.g. "cancelled" or "hiatus" are ranked after every other
+	// valid match, so they only fill the slots which would otherwise be empty
+	DemotedStatuses []string `json:"demotedStatuses,omitempty"`
+
+	// Never match manga sharing an author or artist, the same as related manga
+	ExcludeSameCreator bool `json:"excludeSameCreator,omitempty"`
+
+	// What to do with related manga keyed by relation type e.g. "sequel" or "doujinshi", unlisted types are excluded
+	// "exclude" never shows them, "allow" matches them like any other manga and "separate" lists them as related matches
+	RelationPolicy map[string]string `json:"relationPolicy,omitempty"`
+
+	// UUIDs and groups of the tags, this is not part of the config file and is set when the corpus is built
+	TagCatalogue TagCatalogue `json:"-"`
+
+	// If set the description tf-idf vectors are reduced to this many latent dimensions with a truncated SVD
+	// so synonyms and paraphrased descriptions can match, note fitting needs the whole term matrix in memory
+	LsaDimensions int `json:"lsaDimensions,omitempty"`
+
+	// Also take the nearest descriptions in the lsa space as candidates, which needs lsaDimensions
+	// Finding them compares every pair of manga, so it costs O(manga² × lsaDimensions) and grows quadratically
+	LsaCandidates bool `json:"lsaCandidates,omitempty"`
+}
+
+// LoadConfig rejects unknown fields, so a misspelt setting isn't silently left at its zero value
+func LoadConfig(fileName string) (Config, error) {
+	config := Config{}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	if err != nil {
+		return config, fmt.Errorf("invalid config %s: %w", fileName, err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return config, fmt.Errorf("invalid config %s: %w", fileName, err)
+	}
+	return config, nil
+}
+
+func (c Config) Validate() error {
+	if c.Version != ConfigVersion {
+		return fmt.Errorf("unsupported version %d, expected %d", c.Version, ConfigVersion)
+	}
+	if c.NumSimToGet < 1 {
+		return errors.New("numSimToGet must be at least 1")
+	}
+	if c.TagScoreRatio < 0 {
+		return errors.New("tagScoreRatio can't be negative")
+	}
+	if c.IgnoreDescScoreUnder < 0 || c.IgnoreDescScoreUnder > 1 {
+		return errors.New("ignoreDescScoreUnder must be between 0 and 1")
+	}
+	if c.AcceptDescScoreOver < 0 || c.AcceptDescScoreOver > 1 {
+		return errors.New("acceptDescScoreOver must be between 0 and 1")
+	}
+	if c.IgnoreTagsUnderCount < 0 {
+		return errors.New("ignoreTagsUnderCount can't be negative")
+	}
+	if c.MinDescriptionWords < 0 {
+		return errors.New("minDescriptionWords can't be negative")
+	}
+	if c.DefaultTagWeight < 0 || c.DefaultTagWeight > 1 {
+		return errors.New("defaultTagWeight must be between 0 and 1")
+	}
+	if c.CreatorScoreRatio < 0 {
+		return errors.New("creatorScoreRatio can't be negative")
+	}
+	if c.YearScoreRatio < 0 {
+		return errors.New("yearScoreRatio can't be negative")
+	}
+	if c.YearScoreRatio > 0 && c.YearScoreRange < 1 {
+		return errors.New("yearScoreRange must be at least 1 when yearScoreRatio is set")
+	}
+	if c.LanguageScoreRatio < 0 {
+		return errors.New("languageScoreRatio can't be negative")
+	}
+	if c.LsaDimensions < 0 {
+		return errors.New("lsaDimensions can't be negative")
+	}
+	if c.LsaCandidates && c.LsaDimensions == 0 {
+		return errors.New("lsaCandidates needs lsaDimensions to be set")
+	}
+	if len(c.OneWayTags) == 0 {
+		return errors.New("oneWayTags can't be missing or empty")
+	}
+	for relation, policy := range c.RelationPolicy {
+		if policy != RelationExclude && policy != RelationAllow && policy != RelationSeparate {
+			return fmt.Errorf("relation policy of %s must be %s, %s or %s", relation, RelationExclude, RelationAllow, RelationSeparate)
+		}
+	}
+	for tag, weight := range c.TagWeights {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("tag weight of %s must be between 0 and 1", tag)
+		}
+	}
+	for group, weight := range c.TagGroupWeights {
+		if !isTagGroup(group) {
+			return fmt.Errorf("unknown tag group %s in tagGroupWeights", group)
+		}
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("tag group weight of %s must be between 0 and 1", group)
+		}
+	}
+	for _, group := range c.OneWayTagGroups {
+		if !isTagGroup(group) {
+			return fmt.Errorf("unknown tag group %s in oneWayTagGroups", group)
+		}
+	}
+	return nil
+}
+
+// TagWeight of the tag the vectoriser knows by name, looked up by UUID, then name, then group
+func (c Config) TagWeight(name string) float64 {
+	id := c.TagCatalogue.Ids[name]
+	if weight, ok := c.TagWeights[id]; ok && id != "" {
+		return weight
+	}
+	if weight, ok := c.TagWeights[name]; ok {
+		return weight
+	}
+	if weight, ok := c.TagGroupWeights[c.TagCatalogue.Groups[id]]; ok && id != "" {
+		return weight
+	}
+	return c.DefaultTagWeight
+}
+
+// IsOneWayTag is true if a match may only have the tag when the current manga also has it
+func (c Config) IsOneWayTag(id string) bool {
+	for _, tagId := range c.OneWayTags {
+		if tagId == id {
+			return true
+		}
+	}
+	if len(c.OneWayTagGroups) == 0 {
+		return false
+	}
+	group := c.TagCatalogue.Groups[id]
+	for _, oneWayGroup := range c.OneWayTagGroups {
+		if group != "" && group == oneWayGroup {
+			return true
+		}
+	}
+	return false
+}
+
+func isTagGroup(group string) bool {
+	for _, tagGroup := range TagGroups {
+		if tagGroup == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Policies of RelationPolicy
+const (
+	RelationExclude  = "exclude"
+	RelationAllow    = "allow"
+	RelationSeparate = "separate"
+)
+
+// RelationPolicyOf the relation type, manga related through an unknown or unlisted type are excluded
+func (c Config) RelationPolicyOf(relation string) string {
+	if policy, ok := c.RelationPolicy[relation]; ok && relation != "" {
+		return policy
+	}
+	return RelationExclude
+}
+
+// MaxScore is the combined score of a perfect match, dividing by it gives a score between 0 and 1
+func (c Config) MaxScore() float64 {
+	return c.TagScoreRatio + 1.0 + c.CreatorScoreRatio + c.YearScoreRatio + c.LanguageScoreRatio
+}
+
+// IsDemotedStatus is true if matches with the publication status are ranked last
+func (c Config) IsDemotedStatus(status string) bool {
+	for _, demotedStatus := range c.DemotedStatuses {
+		if status != "" && status == demotedStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash identifies the tuning which produced a result, equal configs always give the same hash
+func (c Config) Hash() string {
+	jsonConfig, _ := json.Marshal(c)
+	sum := sha256.Sum256(jsonConfig)
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,65 @@
+package similar_helpers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigOfTheRepository(t *testing.T) {
+	config, err := LoadConfig("../../../data/similar_config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.OneWayTags) == 0 {
+		t.Error("loaded without the one-way tags")
+	}
+}
+
+func TestLoadConfigRejectsInvalidConfigs(t *testing.T) {
+	valid := map[string]interface{}{
+		"version":              ConfigVersion,
+		"numSimToGet":          40,
+		"tagScoreRatio":        0.4,
+		"ignoreDescScoreUnder": 0.01,
+		"acceptDescScoreOver":  0.45,
+		"defaultTagWeight":     0.7,
+		"oneWayTags":           []string{"b29d6a3d-1569-4e7a-8caf-7557bc92cd5d"},
+	}
+	tests := []struct {
+		name   string
+		change func(config map[string]interface{})
+		err    string
+	}{
+		{"valid", func(config map[string]interface{}) {}, ""},
+		{"misspelt field", func(config map[string]interface{}) { config["numSimsToGet"] = 10 }, "unknown field"},
+		{"missing oneWayTags", func(config map[string]interface{}) { delete(config, "oneWayTags") }, "oneWayTags"},
+		{"empty oneWayTags", func(config map[string]interface{}) { config["oneWayTags"] = []string{} }, "oneWayTags"},
+		{"lsaCandidates without lsaDimensions", func(config map[string]interface{}) { config["lsaCandidates"] = true }, "lsaDimensions"},
+	}
+	for _, test := range tests {
+		config := map[string]interface{}{}
+		for key, value := range valid {
+			config[key] = value
+		}
+		test.change(config)
+		jsonConfig, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(fileName, jsonConfig, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = LoadConfig(fileName)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want one about %s", test.name, err, test.err)
+		}
+	}
+}
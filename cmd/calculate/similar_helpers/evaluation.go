@@ -0,0 +1,128 @@
+package similar_helpers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Judgements maps a query manga UUID to the relevance of each judged manga UUID
+// A relevance of 0 means the pair was judged as not similar, higher is more similar
+type Judgements map[string]map[string]int
+
+func (j Judgements) Add(queryId string, mangaId string, relevance int) {
+	if _, ok := j[queryId]; !ok {
+		j[queryId] = map[string]int{}
+	}
+	j[queryId][mangaId] = relevance
+}
+
+// LoadJudgements reads a csv file of "queryUUID,matchUUID,relevance" lines
+// Empty lines and lines starting with # are ignored
+func LoadJudgements(fileName string) (Judgements, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	judgements := Judgements{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		relevance, err := strconv.Atoi(strings.TrimSpace(record[2]))
+		if err != nil || relevance < 0 {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: relevance must be a non-negative integer", fileName, line)
+		}
+		judgements.Add(strings.TrimSpace(record[0]), strings.TrimSpace(record[1]), relevance)
+	}
+	return judgements, nil
+}
+
+// RankingMetrics are the averaged ranking quality measures over all evaluated queries
+type RankingMetrics struct {
+	K          int     `json:"k"`
+	NumQueries int     `json:"numQueries"`
+	Precision  float64 `json:"precision"`
+	Recall     float64 `json:"recall"`
+	NDCG       float64 `json:"ndcg"`
+	MRR        float64 `json:"mrr"`
+}
+
+// EvaluateRanking scores a single ranked list of manga UUIDs against the judged relevances of its query
+// Returns false if the query has no relevant judgements, since none of the measures are defined then
+func EvaluateRanking(ranked []string, relevances map[string]int, k int) (RankingMetrics, bool) {
+	var idealGains []int
+	for _, relevance := range relevances {
+		if relevance > 0 {
+			idealGains = append(idealGains, relevance)
+		}
+	}
+	if len(idealGains) == 0 {
+		return RankingMetrics{}, false
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(idealGains)))
+
+	metrics := RankingMetrics{K: k, NumQueries: 1}
+	hits := 0
+	dcg := 0.0
+	for rank, mangaId := range ranked {
+		relevance := relevances[mangaId]
+		if relevance <= 0 {
+			continue
+		}
+		if metrics.MRR == 0 {
+			metrics.MRR = 1 / float64(rank+1)
+		}
+		if rank < k {
+			hits++
+			dcg += discountedGain(relevance, rank)
+		}
+	}
+
+	idcg := 0.0
+	for rank := 0; rank < k && rank < len(idealGains); rank++ {
+		idcg += discountedGain(idealGains[rank], rank)
+	}
+
+	metrics.Precision = float64(hits) / float64(k)
+	metrics.Recall = float64(hits) / float64(len(idealGains))
+	metrics.NDCG = dcg / idcg
+	return metrics, true
+}
+
+func discountedGain(relevance int, rank int) float64 {
+	return (math.Pow(2, float64(relevance)) - 1) / math.Log2(float64(rank+2))
+}
+
+// Accumulate adds the measures of another query into the running averages
+func (m *RankingMetrics) Accumulate(other RankingMetrics) {
+	total := float64(m.NumQueries + other.NumQueries)
+	if total == 0 {
+		return
+	}
+	weight := float64(m.NumQueries) / total
+	otherWeight := float64(other.NumQueries) / total
+	m.Precision = m.Precision*weight + other.Precision*otherWeight
+	m.Recall = m.Recall*weight + other.Recall*otherWeight
+	m.NDCG = m.NDCG*weight + other.NDCG*otherWeight
+	m.MRR = m.MRR*weight + other.MRR*otherWeight
+	m.NumQueries += other.NumQueries
+}
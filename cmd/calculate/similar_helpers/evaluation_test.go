@@ -0,0 +1,158 @@
+package similar_helpers
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestEvaluateRanking(t *testing.T) {
+	tests := []struct {
+		name       string
+		ranked     []string
+		relevances map[string]int
+		k          int
+		ok         bool
+		metrics    RankingMetrics
+	}{
+		{
+			name:       "graded relevances",
+			ranked:     []string{"a", "b", "c", "d"},
+			relevances: map[string]int{"a": 1, "b": 0, "c": 2, "e": 1},
+			k:          3,
+			ok:         true,
+			// dcg = 1/log2(2) + 3/log2(4), idcg = 3/log2(2) + 1/log2(3) + 1/log2(4)
+			metrics: RankingMetrics{K: 3, NumQueries: 1, Precision: 2.0 / 3, Recall: 2.0 / 3, NDCG: 2.5 / (3 + 1/math.Log2(3) + 0.5), MRR: 1},
+		},
+		{
+			name:       "k larger than the ranking",
+			ranked:     []string{"x", "a"},
+			relevances: map[string]int{"a": 1},
+			k:          5,
+			ok:         true,
+			metrics:    RankingMetrics{K: 5, NumQueries: 1, Precision: 1.0 / 5, Recall: 1, NDCG: 1 / math.Log2(3), MRR: 1.0 / 2},
+		},
+		{
+			name:       "relevant manga only past k",
+			ranked:     []string{"x", "y", "a"},
+			relevances: map[string]int{"a": 1},
+			k:          2,
+			ok:         true,
+			metrics:    RankingMetrics{K: 2, NumQueries: 1, MRR: 1.0 / 3},
+		},
+		{
+			name:       "perfect ranking",
+			ranked:     []string{"a", "b"},
+			relevances: map[string]int{"a": 2, "b": 1},
+			k:          2,
+			ok:         true,
+			metrics:    RankingMetrics{K: 2, NumQueries: 1, Precision: 1, Recall: 1, NDCG: 1, MRR: 1},
+		},
+		{
+			name:       "empty ranking",
+			ranked:     nil,
+			relevances: map[string]int{"a": 1},
+			k:          3,
+			ok:         true,
+			metrics:    RankingMetrics{K: 3, NumQueries: 1},
+		},
+		{
+			name:       "no judgements",
+			ranked:     []string{"a"},
+			relevances: map[string]int{},
+			k:          3,
+		},
+		{
+			name:       "only judged not similar",
+			ranked:     []string{"a"},
+			relevances: map[string]int{"a": 0},
+			k:          3,
+		},
+	}
+	for _, test := range tests {
+		metrics, ok := EvaluateRanking(test.ranked, test.relevances, test.k)
+		if ok != test.ok {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if metrics.K != test.metrics.K || metrics.NumQueries != test.metrics.NumQueries ||
+			!closeTo(metrics.Precision, test.metrics.Precision) || !closeTo(metrics.Recall, test.metrics.Recall) ||
+			!closeTo(metrics.NDCG, test.metrics.NDCG) || !closeTo(metrics.MRR, test.metrics.MRR) {
+			t.Errorf("%s: got %+v, want %+v", test.name, metrics, test.metrics)
+		}
+	}
+}
+
+func TestAccumulateAveragesQueries(t *testing.T) {
+	metrics := RankingMetrics{K: 10}
+	metrics.Accumulate(RankingMetrics{K: 10, NumQueries: 1, Precision: 1, Recall: 1, NDCG: 1, MRR: 1})
+	metrics.Accumulate(RankingMetrics{K: 10, NumQueries: 1})
+	metrics.Accumulate(RankingMetrics{K: 10, NumQueries: 2, Precision: 0.5, Recall: 0.5, NDCG: 0.5, MRR: 0.5})
+	want := RankingMetrics{K: 10, NumQueries: 4, Precision: 0.5, Recall: 0.5, NDCG: 0.5, MRR: 0.5}
+	if metrics.NumQueries != want.NumQueries || !closeTo(metrics.Precision, want.Precision) || !closeTo(metrics.Recall, want.Recall) ||
+		!closeTo(metrics.NDCG, want.NDCG) || !closeTo(metrics.MRR, want.MRR) {
+		t.Errorf("got %+v, want %+v", metrics, want)
+	}
+}
+
+func writeJudgements(t *testing.T, contents string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "judgements.csv")
+	if err := os.WriteFile(fileName, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadJudgements(t *testing.T) {
+	fileName := writeJudgements(t, "# query,match,relevance\nq1,a,2\n\nq1, b, 0\nq2,c,1\nq1,a,1\n")
+	judgements, err := LoadJudgements(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Judgements{"q1": {"a": 1, "b": 0}, "q2": {"c": 1}}
+	if len(judgements) != len(want) {
+		t.Fatalf("got %v, want %v", judgements, want)
+	}
+	for queryId, relevances := range want {
+		if len(judgements[queryId]) != len(relevances) {
+			t.Errorf("got %v, want %v", judgements, want)
+			continue
+		}
+		for mangaId, relevance := range relevances {
+			if got, ok := judgements[queryId][mangaId]; !ok || got != relevance {
+				t.Errorf("%s %s: got %d, want %d", queryId, mangaId, got, relevance)
+			}
+		}
+	}
+
+	empty, err := LoadJudgements(writeJudgements(t, "# nothing judged yet\n"))
+	if err != nil || len(empty) != 0 {
+		t.Errorf("got %v %v for a file without judgements", empty, err)
+	}
+}
+
+func TestLoadJudgementsRejectsMalformedFiles(t *testing.T) {
+	tests := map[string]string{
+		"negative relevance": "q1,a,-1\n",
+		"relevance a word":   "q1,a,high\n",
+		"missing relevance":  "q1,a\n",
+		"extra column":       "q1,a,1,2\n",
+	}
+	for name, contents := range tests {
+		if _, err := LoadJudgements(writeJudgements(t, contents)); err == nil {
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+	if _, err := LoadJudgements(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("missing file loaded without an error")
+	}
+}
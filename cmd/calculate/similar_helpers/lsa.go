@@ -0,0 +1,113 @@
+package similar_helpers
+
+import (
+	"errors"
+	"github.com/james-bowman/nlp"
+	"github.com/james-bowman/sparse"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"math/rand"
+)
+
+// Extra random dimensions sampled past k, and the number of power iterations, of the randomised svd
+const svdOversamples = 10
+const svdPowerIterations = 2
+
+// FitTruncatedSVD fits a truncated svd to a term document matrix (terms are rows, manga are columns).
+// The nlp TruncatedSVD.Fit factorises the whole matrix as dense which is far too slow for our corpus,
+// so instead the randomised range finder of Halko et al. 2011 is used, which only ever multiplies the
+// sparse matrix. The result can be saved and loaded as a normal nlp.TruncatedSVD.
+func FitTruncatedSVD(matrix *sparse.CSC, k int) (*nlp.TruncatedSVD, error) {
+	numTerms, numDocs := matrix.Dims()
+	numSamples := k + svdOversamples
+	if numSamples > numTerms {
+		numSamples = numTerms
+	}
+	if numSamples > numDocs {
+		numSamples = numDocs
+	}
+	if k > numSamples {
+		k = numSamples
+	}
+	if k < 1 {
+		return nil, errors.New("can't fit an svd to an empty matrix")
+	}
+
+	// Sample the range of the matrix with a fixed seed so runs are reproducible
+	random := rand.New(rand.NewSource(1))
+	omega := mat.NewDense(numDocs, numSamples, nil)
+	omega.Apply(func(i, j int, v float64) float64 {
+		return random.NormFloat64()
+	}, omega)
+	q := multiplySparse(matrix, omega)
+	orthonormaliseColumns(q)
+	for i := 0; i < svdPowerIterations; i++ {
+		z := multiplySparseT(matrix, q)
+		orthonormaliseColumns(z)
+		q = multiplySparse(matrix, z)
+		orthonormaliseColumns(q)
+	}
+
+	// The svd of the small projected matrix gives the left singular vectors of the original
+	var svd mat.SVD
+	if ok := svd.Factorize(multiplySparseT(matrix, q).T(), mat.SVDThin); !ok {
+		return nil, errors.New("failed svd factorisation of projected matrix")
+	}
+	var u mat.Dense
+	svd.UTo(&u)
+	var components mat.Dense
+	components.Mul(q, u.Slice(0, numSamples, 0, k))
+	return &nlp.TruncatedSVD{Components: &components, K: k}, nil
+}
+
+// ProjectSVD projects the columns of a term document matrix onto the latent dimensions of the svd.
+// This is the same as TruncatedSVD.Transform, but only visits the non-zero values of the matrix.
+func ProjectSVD(svd *nlp.TruncatedSVD, matrix *sparse.CSC) *mat.Dense {
+	return mat.DenseCopyOf(multiplySparseT(matrix, svd.Components).T())
+}
+
+// Returns matrix * dense
+func multiplySparse(matrix *sparse.CSC, dense *mat.Dense) *mat.Dense {
+	numTerms, _ := matrix.Dims()
+	_, c := dense.Dims()
+	result := mat.NewDense(numTerms, c, nil)
+	matrix.DoNonZero(func(i, j int, v float64) {
+		row := result.RawRowView(i)
+		for k, d := range dense.RawRowView(j) {
+			row[k] += v * d
+		}
+	})
+	return result
+}
+
+// Returns transpose(matrix) * dense
+func multiplySparseT(matrix *sparse.CSC, dense *mat.Dense) *mat.Dense {
+	_, numDocs := matrix.Dims()
+	_, c := dense.Dims()
+	result := mat.NewDense(numDocs, c, nil)
+	matrix.DoNonZero(func(i, j int, v float64) {
+		row := result.RawRowView(j)
+		for k, d := range dense.RawRowView(i) {
+			row[k] += v * d
+		}
+	})
+	return result
+}
+
+// Modified Gram-Schmidt, columns which are linearly dependent on the previous ones are zeroed
+func orthonormaliseColumns(dense *mat.Dense) {
+	_, c := dense.Dims()
+	for j := 0; j < c; j++ {
+		column := dense.ColView(j).(*mat.VecDense)
+		for k := 0; k < j; k++ {
+			previous := dense.ColView(k)
+			column.AddScaledVec(column, -mat.Dot(column, previous), previous)
+		}
+		norm := mat.Norm(column, 2)
+		if norm < 1e-10 || math.IsNaN(norm) {
+			column.Zero()
+			continue
+		}
+		column.ScaleVec(1/norm, column)
+	}
+}
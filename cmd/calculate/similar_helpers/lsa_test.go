@@ -0,0 +1,106 @@
+package similar_helpers
+
+import (
+	"github.com/james-bowman/sparse"
+	"gonum.org/v1/gonum/mat"
+	"math/rand"
+	"testing"
+)
+
+// A terms x docs matrix of three topics with clearly separated strengths plus a little noise,
+// each topic only has some of the terms so much of it is zero
+func topicMatrix(numTerms int, numDocs int) *sparse.CSC {
+	random := rand.New(rand.NewSource(7))
+	strengths := []float64{10, 5, 2}
+	dense := mat.NewDense(numTerms, numDocs, nil)
+	for _, strength := range strengths {
+		terms := make([]float64, numTerms)
+		docs := make([]float64, numDocs)
+		for i := range terms {
+			if random.Float64() < 0.4 {
+				terms[i] = random.Float64()
+			}
+		}
+		for j := range docs {
+			docs[j] = random.Float64()
+		}
+		for i := range terms {
+			for j := range docs {
+				dense.Set(i, j, dense.At(i, j)+strength*terms[i]*docs[j]/float64(numTerms))
+			}
+		}
+	}
+	coo := sparse.NewCOO(numTerms, numDocs, nil, nil, nil)
+	for i := 0; i < numTerms; i++ {
+		for j := 0; j < numDocs; j++ {
+			if dense.At(i, j) != 0 {
+				coo.Set(i, j, dense.At(i, j)+0.0001*random.NormFloat64())
+			}
+		}
+	}
+	return coo.ToCSC()
+}
+
+func TestFitTruncatedSVDFindsTheSingularSubspace(t *testing.T) {
+	matrix := topicMatrix(60, 40)
+	for _, k := range []int{1, 3} {
+		fitted, err := FitTruncatedSVD(matrix, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fitted.K != k {
+			t.Errorf("k %d: fitted %d dimensions", k, fitted.K)
+		}
+
+		var svd mat.SVD
+		if ok := svd.Factorize(mat.DenseCopyOf(matrix), mat.SVDThin); !ok {
+			t.Fatal("exact svd failed")
+		}
+		var u mat.Dense
+		svd.UTo(&u)
+		numTerms, _ := u.Dims()
+		exact := u.Slice(0, numTerms, 0, k)
+
+		// Singular vectors are only unique up to sign, and rotation within equal singular values,
+		// so the projections onto the two subspaces are compared rather than the vectors themselves
+		var fittedProjection, exactProjection, diff mat.Dense
+		fittedProjection.Mul(fitted.Components, fitted.Components.T())
+		exactProjection.Mul(exact, exact.T())
+		diff.Sub(&fittedProjection, &exactProjection)
+		if norm := mat.Norm(&diff, 2); norm > 1e-6 {
+			t.Errorf("k %d: subspace differs from the exact svd by %g", k, norm)
+		}
+
+		// The components are orthonormal
+		var gram mat.Dense
+		gram.Mul(fitted.Components.T(), fitted.Components)
+		if !mat.EqualApprox(&gram, identity(k), 1e-9) {
+			t.Errorf("k %d: components are not orthonormal: %v", k, mat.Formatted(&gram))
+		}
+	}
+
+	if _, err := FitTruncatedSVD(sparse.NewCOO(0, 0, nil, nil, nil).ToCSC(), 3); err == nil {
+		t.Error("fitted an svd to an empty matrix")
+	}
+}
+
+func identity(n int) *mat.Dense {
+	dense := mat.NewDense(n, n, nil)
+	for i := 0; i < n; i++ {
+		dense.Set(i, i, 1)
+	}
+	return dense
+}
+
+func TestProjectSVDMatchesTransform(t *testing.T) {
+	matrix := topicMatrix(30, 20)
+	fitted, err := FitTruncatedSVD(matrix, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want mat.Dense
+	want.Mul(fitted.Components.T(), mat.DenseCopyOf(matrix))
+	if got := ProjectSVD(fitted, matrix); !mat.EqualApprox(got, &want, 1e-9) {
+		t.Errorf("projection differs from components' * matrix")
+	}
+}
@@ -2,90 +2,174 @@ package similar_helpers
 
 import (
 	"github.com/similar-manga/similar/internal"
+	"math"
 	"strings"
 )
 
-var oneWayTags = []string{
-	"b11fda93-8f1d-4bef-b2ed-8803d3733170", // 4-Koma
-	"b13b2a48-c720-44a9-9c77-39c9979373fb", //Doujinshi
-	"b29d6a3d-1569-4e7a-8caf-7557bc92cd5d", //Gore
-	"97893a4c-12af-4dac-b6be-0dffb353568e", //Sexual Violence
-	"5920b825-4181-4a17-beeb-9918b0ff7a30", //Boys' Love
-	"a3c67850-4684-404e-9b7f-c69850ee5da6", //"Girls' Love
-	"acc803a4-c95a-4c22-86fc-eb6b582d82a2", //Wuxia
-	"2d1f5d56-a1e5-4d0d-a961-2193588b08ec", //Loli
-	"ddefd648-5140-4e5f-ba18-4eca4071d19b", //Shota
-	"5bd0e105-4481-44ca-b6e7-7544da56b1a3", //Incest
-}
-
-func NotValidMatch(manga internal.Manga, mangaOther internal.Manga) bool {
+// NotValidMatch checks the rules a pair must pass no matter their score, returning the rule that rejected the pair
+func NotValidMatch(manga internal.Manga, mangaOther internal.Manga, config Config) (bool, string) {
 
-	// Enforce that the two do not have another as a *related* manga
-	for _, relatedId := range manga.RelatedIds {
-		if relatedId == mangaOther.Id {
-			return true
+	// Enforce the relation policy if the two have another as a *related* manga
+	if relation, related := RelationBetween(manga, mangaOther); related {
+		switch config.RelationPolicyOf(relation) {
+		case RelationSeparate:
+			return true, "Related " + relation + " (listed separately)"
+		case RelationExclude:
+			return true, strings.TrimSpace("Related " + relation)
 		}
 	}
 
-	for _, relatedId := range mangaOther.RelatedIds {
-		if relatedId == manga.Id {
-			return true
-		}
+	// Optionally treat works by the same author or artist like related manga
+	if config.ExcludeSameCreator && CreatorOverlap(manga, mangaOther) > 0 {
+		return true, "Same Creator"
 	}
 
+	return NotValidRelatedMatch(manga, mangaOther, config)
+}
+
+// NotValidRelatedMatch checks the rules of NotValidMatch which also apply to manga listed separately as related
+// Only the relation policy and the same creator rule are left out, as being related is why they are listed
+func NotValidRelatedMatch(manga internal.Manga, mangaOther internal.Manga, config Config) (bool, string) {
+
 	// Enforce that our two demographics are the same
 	if manga.ContentRating != "" &&
 		manga.ContentRating != mangaOther.ContentRating {
-		return true
+		return true, "Content Rating"
 	}
 
 	// Small check for "promo" titles, don't match to promotional titles
 	title := strings.ToLower((*manga.Title)["en"])
 	titleOther := strings.ToLower((*mangaOther.Title)["en"])
 	if !strings.Contains(title, "(promo)") && strings.Contains(titleOther, "(promo)") {
-		return true
+		return true, "Promo"
 	}
 
 	// Enforce that our two demographics are the same
 	if manga.PublicationDemographic != "" &&
 		manga.PublicationDemographic != mangaOther.PublicationDemographic {
-		return true
+		return true, "Demographic"
 	}
 
 	// No need to check tags for our top level content ratings
 	// They will be a valid match no matter the tags (not that many options thus can't limit)
 	if manga.ContentRating == "erotica" || manga.ContentRating == "pornographic" {
-		return false
+		return false, ""
 	}
 
-	// Next we should enforce the following tags
-	for _, tagId := range oneWayTags {
+	// Next we should enforce the one-way tags
+	// If we have the tag, then no need to check the other manga
+	// If we don't have it, then the other manga shouldn't have it..
+	for _, otherMangaTag := range mangaOther.Tags {
+		if !config.IsOneWayTag(otherMangaTag.Id) {
+			continue
+		}
 
 		// Check to see if this tag is in our first manga
 		hasTag := false
 		for _, currentMangaTag := range manga.Tags {
-			if currentMangaTag.Id == tagId {
+			if currentMangaTag.Id == otherMangaTag.Id {
 				hasTag = true
 				break
 			}
 		}
-
-		// If we have the tag, then no need to check the other manga
-		// If we don't have it, then the other manga shouldn't have it..
-		if hasTag {
-			continue
+		if !hasTag {
+			return true, "One-Way Tag " + otherMangaTag.Id
 		}
 
-		// Check if other does not have the tag
-		for _, otherMangaTag := range mangaOther.Tags {
-			if otherMangaTag.Id == tagId {
-				return true
+	}
+
+	// Else this is a valid match we can use!
+	return false, ""
+
+}
+
+// CreatorIds are the unique author and artist ids of the manga
+func CreatorIds(manga internal.Manga) []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, creators := range [][]internal.Creator{manga.Authors, manga.Artists} {
+		for _, creator := range creators {
+			if !seen[creator.Id] {
+				seen[creator.Id] = true
+				ids = append(ids, creator.Id)
 			}
 		}
+	}
+	return ids
+}
+
+// CreatorOverlap is the jaccard overlap of the authors and artists of the two manga, 0 if either has none
+func CreatorOverlap(manga internal.Manga, mangaOther internal.Manga) float64 {
+	ids := CreatorIds(manga)
+	idsOther := CreatorIds(mangaOther)
+	if len(ids) == 0 || len(idsOther) == 0 {
+		return 0
+	}
+	return Jaccard(ids, idsOther)
+}
+
+// RelationBetween returns how mangaOther is related to manga, checking the relations of both
+// A relation only mangaOther lists is inverted, so it is still from the side of manga, e.g. a sequel's prequel
+// Manga stored before relation types were kept only have related ids, these give an empty relation
+func RelationBetween(manga internal.Manga, mangaOther internal.Manga) (string, bool) {
+	for _, relation := range manga.Relations {
+		if relation.Id == mangaOther.Id {
+			return relation.Type, true
+		}
+	}
+	for _, relation := range mangaOther.Relations {
+		if relation.Id == manga.Id {
+			return InverseRelation(relation.Type), true
+		}
+	}
+	for _, relatedId := range manga.RelatedIds {
+		if relatedId == mangaOther.Id {
+			return "", true
+		}
+	}
+	for _, relatedId := range mangaOther.RelatedIds {
+		if relatedId == manga.Id {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// MangaDex relation types which come in pairs, the types not listed are their own inverse, e.g. alternate_story
+var inverseRelations = map[string]string{
+	"prequel":          "sequel",
+	"sequel":           "prequel",
+	"main_story":       "side_story",
+	"side_story":       "main_story",
+	"monochrome":       "colored",
+	"colored":          "monochrome",
+	"preserialization": "serialization",
+	"serialization":    "preserialization",
+}
 
+// InverseRelation is the relation type as seen from the other manga, e.g. prequel for sequel
+func InverseRelation(relation string) string {
+	if inverse, ok := inverseRelations[relation]; ok {
+		return inverse
 	}
+	return relation
+}
 
-	// Else this is a valid match we can use!
-	return false
+// YearProximity falls linearly from 1 for the same release year to 0 at yearRange years apart, 0 if either year is unknown
+func YearProximity(manga internal.Manga, mangaOther internal.Manga, yearRange int) float64 {
+	if manga.Year == 0 || mangaOther.Year == 0 || yearRange < 1 {
+		return 0
+	}
+	yearsApart := math.Abs(float64(manga.Year - mangaOther.Year))
+	return math.Max(0, 1-yearsApart/float64(yearRange))
+}
 
+// LanguageAffinity is 1 if both were originally published in the same language, regional variants like zh-hk count as zh
+func LanguageAffinity(manga internal.Manga, mangaOther internal.Manga) float64 {
+	language := strings.Split(manga.OriginalLanguage, "-")[0]
+	languageOther := strings.Split(mangaOther.OriginalLanguage, "-")[0]
+	if language == "" || language != languageOther {
+		return 0
+	}
+	return 1
 }
@@ -0,0 +1,78 @@
+package similar_helpers
+
+import (
+	"github.com/similar-manga/similar/internal"
+	"testing"
+)
+
+func testManga(id string, contentRating string) internal.Manga {
+	title := map[string]string{"en": "Manga " + id}
+	return internal.Manga{Id: id, Title: &title, ContentRating: contentRating}
+}
+
+func TestRelationBetweenIsFromTheSideOfManga(t *testing.T) {
+	first := testManga("first", "safe")
+	second := testManga("second", "safe")
+	second.Relations = []internal.Relation{{Id: "first", Type: "prequel"}}
+
+	tests := []struct {
+		name     string
+		manga    internal.Manga
+		other    internal.Manga
+		relation string
+	}{
+		{"listed by manga", second, first, "prequel"},
+		{"listed by the other manga only", first, second, "sequel"},
+	}
+	for _, test := range tests {
+		relation, related := RelationBetween(test.manga, test.other)
+		if !related || relation != test.relation {
+			t.Errorf("%s: got %q %v, want %q", test.name, relation, related, test.relation)
+		}
+	}
+}
+
+func TestInverseRelation(t *testing.T) {
+	tests := map[string]string{
+		"sequel":          "prequel",
+		"side_story":      "main_story",
+		"colored":         "monochrome",
+		"alternate_story": "alternate_story",
+		"":                "",
+	}
+	for relation, inverse := range tests {
+		if got := InverseRelation(relation); got != inverse {
+			t.Errorf("InverseRelation(%q) = %q, want %q", relation, got, inverse)
+		}
+	}
+}
+
+func TestNotValidRelatedMatchKeepsTheContentRules(t *testing.T) {
+	config := Config{
+		OneWayTags:         []string{"one-way"},
+		ExcludeSameCreator: true,
+		RelationPolicy:     map[string]string{"sequel": RelationSeparate},
+	}
+	manga := testManga("manga", "safe")
+	manga.Relations = []internal.Relation{{Id: "related", Type: "sequel"}}
+	manga.Authors = []internal.Creator{{Id: "author"}}
+
+	related := testManga("related", "safe")
+	related.Authors = []internal.Creator{{Id: "author"}}
+	if invalid, reason := NotValidRelatedMatch(manga, related, config); invalid {
+		t.Errorf("related manga by the same author rejected because %s", reason)
+	}
+	if invalid, _ := NotValidMatch(manga, related, config); !invalid {
+		t.Error("related manga with the separate policy accepted as a match")
+	}
+
+	pornographic := testManga("related", "pornographic")
+	if invalid, _ := NotValidRelatedMatch(manga, pornographic, config); !invalid {
+		t.Error("related manga of another content rating accepted")
+	}
+	oneWay := testManga("related", "safe")
+	oneWay.Tags = []internal.Tag{{Id: "one-way"}}
+	if invalid, _ := NotValidRelatedMatch(manga, oneWay, config); !invalid {
+		t.Error("related manga with a one-way tag the manga doesn't have accepted")
+	}
+}
@@ -0,0 +1,236 @@
+package similar_helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caneroj1/stemmer"
+	"github.com/james-bowman/nlp"
+	"github.com/james-bowman/sparse"
+	"github.com/similar-manga/similar/internal"
+	"gonum.org/v1/gonum/mat"
+	"os"
+	"strings"
+)
+
+// ModelVersion is the only model file version this build understands
+const ModelVersion = 2
+
+// Model is everything fitted to the corpus by a full similar run: the tag and description vocabularies,
+// the description idf weights, the optional lsa svd and the tag catalogue the tags were weighted with.
+// Re-using it keeps vectors comparable between runs.
+type Model struct {
+	Version        int            `json:"version"`
+	ConfigHash     string         `json:"configHash"`
+	TagCatalogue   TagCatalogue   `json:"tagCatalogue"`
+	TagVocabulary  map[string]int `json:"tagVocabulary"`
+	DescVocabulary map[string]int `json:"descVocabulary"`
+	DescIdf        []float64      `json:"descIdf"`
+	DescSvd        []byte         `json:"descSvd,omitempty"`
+}
+
+// MangaVectors are the vectors of a single manga in the space of a Model
+type MangaVectors struct {
+	Tags              mat.Vector
+	TagsWeighted      mat.Vector
+	Description       mat.Vector
+	DescriptionLsa    mat.Vector // nil if the model has no lsa stage
+	NumTags           int
+	DescriptionLength int
+}
+
+// NewModel stores the fitted state of the vectorisers, the svd is nil if the config has no lsa stage
+func NewModel(config Config, tagVectoriser *nlp.CountVectoriser, descVectoriser *nlp.CountVectoriser, tfidf *nlp.TfidfTransformer, svd *nlp.TruncatedSVD) (*Model, error) {
+	buf := &bytes.Buffer{}
+	err := tfidf.Save(buf)
+	if err != nil {
+		return nil, err
+	}
+	var idf sparse.DIA
+	_, err = idf.UnmarshalBinaryFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &Model{
+		Version:        ModelVersion,
+		ConfigHash:     config.Hash(),
+		TagCatalogue:   config.TagCatalogue,
+		TagVocabulary:  tagVectoriser.Vocabulary,
+		DescVocabulary: descVectoriser.Vocabulary,
+		DescIdf:        idf.Diagonal(),
+	}
+	if svd != nil {
+		buf.Reset()
+		err = svd.Save(buf)
+		if err != nil {
+			return nil, err
+		}
+		model.DescSvd = buf.Bytes()
+	}
+	return model, nil
+}
+
+func SaveModel(fileName string, model *Model) error {
+	jsonModel, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, jsonModel, 0644)
+}
+
+func LoadModel(fileName string) (*Model, error) {
+	jsonModel, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	model := &Model{}
+	err = json.Unmarshal(jsonModel, model)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model %s: %w", fileName, err)
+	}
+	if model.Version != ModelVersion {
+		return nil, fmt.Errorf("invalid model %s: unsupported version %d, expected %d", fileName, model.Version, ModelVersion)
+	}
+	return model, nil
+}
+
+// NewTagVectoriser returns the tag count vectoriser, using the stored vocabulary if the model is not nil
+func NewTagVectoriser(model *Model) *nlp.CountVectoriser {
+	vectoriser := nlp.NewCountVectoriser([]string{}...)
+	if model != nil {
+		vectoriser.Vocabulary = model.TagVocabulary
+	}
+	return vectoriser
+}
+
+// NewDescriptionVectoriser returns the description count vectoriser, using the stored vocabulary if the model is not nil
+func NewDescriptionVectoriser(model *Model) *nlp.CountVectoriser {
+	stopWordsStemmed := append([]string(nil), StopWords...)
+	stemmer.StemMultipleMutate(&stopWordsStemmed)
+	for i := range stopWordsStemmed {
+		stopWordsStemmed[i] = strings.ToLower(stopWordsStemmed[i])
+	}
+	vectoriser := nlp.NewCountVectoriser(stopWordsStemmed...)
+	if model != nil {
+		vectoriser.Vocabulary = model.DescVocabulary
+	}
+	return vectoriser
+}
+
+// TfidfTransformer applies the stored idf weights
+func (m *Model) TfidfTransformer() (*nlp.TfidfTransformer, error) {
+	buf := &bytes.Buffer{}
+	_, err := sparse.NewDIA(len(m.DescIdf), len(m.DescIdf), m.DescIdf).MarshalBinaryTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	tfidf := nlp.NewTfidfTransformer()
+	err = tfidf.Load(buf)
+	if err != nil {
+		return nil, err
+	}
+	return tfidf, nil
+}
+
+// TruncatedSVD projects onto the stored latent dimensions, it is nil if the model has no lsa stage
+func (m *Model) TruncatedSVD() (*nlp.TruncatedSVD, error) {
+	if len(m.DescSvd) == 0 {
+		return nil, nil
+	}
+	svd := &nlp.TruncatedSVD{}
+	err := svd.Load(bytes.NewReader(m.DescSvd))
+	if err != nil {
+		return nil, err
+	}
+	return svd, nil
+}
+
+// Vectorise a single manga, which does not need to be in the corpus the model was fitted to
+// The config must be the one the model was fitted with, since it decides the tag weights
+func (m *Model) Vectorise(manga internal.Manga, config Config) (MangaVectors, error) {
+	vectors := MangaVectors{}
+	if config.Hash() != m.ConfigHash {
+		return vectors, fmt.Errorf("model was fitted with config %s not %s", m.ConfigHash, config.Hash())
+	}
+	config.TagCatalogue = m.TagCatalogue
+	if manga.Title == nil || manga.Description == nil {
+		return vectors, errors.New("manga has nil title or nil description")
+	}
+
+	tagText := TagText(manga)
+	tagMatrix, err := NewTagVectoriser(m).Transform(tagText)
+	if err != nil {
+		return vectors, err
+	}
+	tagCSC := tagMatrix.(sparse.TypeConverter).ToCSC()
+	vectors.Tags = tagCSC.ColView(0)
+	vectors.TagsWeighted = WeightTags(tagCSC, m.TagVocabulary, config).ColView(0)
+	vectors.NumTags = int(mat.Sum(vectors.Tags))
+
+	tfidf, err := m.TfidfTransformer()
+	if err != nil {
+		return vectors, err
+	}
+	descText := DescriptionText(manga)
+	descMatrix, err := nlp.NewPipeline(NewDescriptionVectoriser(m), tfidf).Transform(descText)
+	if err != nil {
+		return vectors, err
+	}
+	descCSC := descMatrix.(sparse.TypeConverter).ToCSC()
+	vectors.Description = descCSC.ColView(0)
+	vectors.DescriptionLength = len(strings.Split(descText, " "))
+
+	svd, err := m.TruncatedSVD()
+	if err != nil {
+		return vectors, err
+	}
+	if svd != nil {
+		vectors.DescriptionLsa = ProjectSVD(svd, descCSC).ColView(0)
+	}
+	return vectors, nil
+}
+
+// TagText is the document of tag names the tag vectoriser is fitted to
+func TagText(manga internal.Manga) string {
+	tagText := ""
+	for _, tag := range manga.Tags {
+		tagText += TagName((*tag.Name)["en"]) + " "
+	}
+	return tagText
+}
+
+// DescriptionText is the document of cleaned titles and description the description vectoriser is fitted to
+func DescriptionText(manga internal.Manga) string {
+	descText := CleanTitle((*manga.Title)["en"]) + " "
+	for _, altTitle := range manga.AltTitles {
+		if val, ok := altTitle["en"]; ok {
+			if CleanTitle(val) != "" {
+				descText += CleanTitle(val) + " "
+			}
+		}
+	}
+	descText += CleanDescription((*manga.Description)["en"])
+	return descText
+}
+
+// WeightTags returns a copy of the tag count matrix with each present tag set to its configured weight
+func WeightTags(tagCSC *sparse.CSC, tagVocabulary map[string]int, config Config) *sparse.CSC {
+	vocabularyInverse := map[int]string{}
+	for k, v := range tagVocabulary {
+		vocabularyInverse[v] = k
+	}
+
+	// Same sparsity as the counts, with each value set to the weight of its tag
+	raw := tagCSC.RawMatrix()
+	data := make([]float64, len(raw.Data))
+	for i, r := range raw.Ind {
+		if raw.Data[i] <= 0 {
+			continue
+		}
+		data[i] = config.TagWeight(vocabularyInverse[r])
+	}
+	dimR, dimC := tagCSC.Dims()
+	return sparse.NewCSC(dimR, dimC, append([]int(nil), raw.Indptr...), append([]int(nil), raw.Ind...), data)
+}
@@ -0,0 +1,135 @@
+package similar_helpers
+
+import (
+	"encoding/json"
+	"github.com/james-bowman/nlp"
+	"github.com/james-bowman/sparse"
+	"github.com/similar-manga/similar/internal"
+	"gonum.org/v1/gonum/mat"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func modelTestManga(id string, title string, description string, tags ...string) internal.Manga {
+	titles := map[string]string{"en": title}
+	descriptions := map[string]string{"en": description}
+	manga := internal.Manga{Id: id, Title: &titles, Description: &descriptions}
+	for _, tag := range tags {
+		name := map[string]string{"en": tag}
+		manga.Tags = append(manga.Tags, internal.Tag{Id: "tag-" + tag, Name: &name, Group: "genre"})
+	}
+	return manga
+}
+
+func vectorsEqual(a mat.Vector, b mat.Vector) bool {
+	return a.Len() == b.Len() && mat.EqualApprox(a, b, 1e-9)
+}
+
+// Fits the vectorisers to the corpus like a full similar run, saves the model, loads it back and checks the loaded
+// model vectorises every manga exactly as the fitted pipeline did
+func TestSavedModelVectorisesLikeTheFittedPipeline(t *testing.T) {
+	mangaList := []internal.Manga{
+		modelTestManga("m1", "Sword Hero", "A young swordsman travels the kingdom to defeat the demon king.", "Action", "Fantasy"),
+		modelTestManga("m2", "Demon King Returns", "The demon king wakes after a thousand years and the kingdom trembles.", "Fantasy"),
+		modelTestManga("m3", "Cafe Days", "Two friends open a small cafe and serve coffee to their neighbours.", "Slice of Life", "Comedy"),
+		modelTestManga("m4", "Coffee Rivals", "A barista challenges the cafe across the street to a coffee contest.", "Comedy"),
+	}
+	config := Config{Version: ConfigVersion, NumSimToGet: 10, DefaultTagWeight: 0.5, TagWeights: map[string]float64{"tag-Fantasy": 1},
+		OneWayTags: []string{"tag-Comedy"}, LsaDimensions: 2}
+	config.TagCatalogue = NewTagCatalogue(mangaList, nil)
+
+	var corpusTag, corpusDesc []string
+	for _, manga := range mangaList {
+		corpusTag = append(corpusTag, TagText(manga))
+		corpusDesc = append(corpusDesc, DescriptionText(manga))
+	}
+	tagVectoriser := NewTagVectoriser(nil)
+	tagMatrix, err := nlp.NewPipeline(tagVectoriser).FitTransform(corpusTag...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagCSC := tagMatrix.(sparse.TypeConverter).ToCSC()
+	tagWeightedCSC := WeightTags(tagCSC, tagVectoriser.Vocabulary, config)
+	descVectoriser := NewDescriptionVectoriser(nil)
+	tfidf := nlp.NewTfidfTransformer()
+	descMatrix, err := nlp.NewPipeline(descVectoriser, tfidf).FitTransform(corpusDesc...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descCSC := descMatrix.(sparse.TypeConverter).ToCSC()
+	svd, err := FitTruncatedSVD(descCSC, config.LsaDimensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descLsa := ProjectSVD(svd, descCSC)
+
+	model, err := NewModel(config, tagVectoriser, descVectoriser, tfidf, svd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "similar_model.json")
+	if err := SaveModel(fileName, model); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0133 != 0 {
+		t.Errorf("model saved with mode %v, want it neither executable nor writable by others", info.Mode().Perm())
+	}
+	loaded, err := LoadModel(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, manga := range mangaList {
+		vectors, err := loaded.Vectorise(manga, config)
+		if err != nil {
+			t.Fatalf("%s: %v", manga.Id, err)
+		}
+		if !vectorsEqual(vectors.Tags, tagCSC.ColView(index)) {
+			t.Errorf("%s: tag vector differs from the fitted one", manga.Id)
+		}
+		if !vectorsEqual(vectors.TagsWeighted, tagWeightedCSC.ColView(index)) {
+			t.Errorf("%s: weighted tag vector differs from the fitted one", manga.Id)
+		}
+		if !vectorsEqual(vectors.Description, descCSC.ColView(index)) {
+			t.Errorf("%s: description vector differs from the fitted one", manga.Id)
+		}
+		if vectors.DescriptionLsa == nil || !vectorsEqual(vectors.DescriptionLsa, descLsa.ColView(index)) {
+			t.Errorf("%s: lsa vector differs from the fitted one", manga.Id)
+		}
+		if vectors.NumTags != len(manga.Tags) {
+			t.Errorf("%s: got %d tags, want %d", manga.Id, vectors.NumTags, len(manga.Tags))
+		}
+	}
+
+	// The tag weights come from the config, so a model can't be used with another one
+	changed := config
+	changed.DefaultTagWeight = 0.25
+	if _, err := loaded.Vectorise(mangaList[0], changed); err == nil {
+		t.Error("vectorised with a config the model wasn't fitted with")
+	}
+}
+
+func TestLoadModelRejectsOtherVersions(t *testing.T) {
+	for _, version := range []int{0, ModelVersion - 1, ModelVersion + 1} {
+		jsonModel, err := json.Marshal(Model{Version: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(t.TempDir(), "similar_model.json")
+		if err := os.WriteFile(fileName, jsonModel, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadModel(fileName); err == nil || !strings.Contains(err.Error(), "unsupported version") {
+			t.Errorf("version %d: got %v, want an unsupported version error", version, err)
+		}
+	}
+	if _, err := LoadModel(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing model without an error")
+	}
+}
@@ -0,0 +1,107 @@
+package similar_helpers
+
+import "math"
+
+// Jaccard is the overlap of the two lists ignoring their order
+func Jaccard(a []string, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	inA := map[string]bool{}
+	for _, id := range a {
+		inA[id] = true
+	}
+	union := len(inA)
+	intersection := 0
+	seenB := map[string]bool{}
+	for _, id := range b {
+		if seenB[id] {
+			continue
+		}
+		seenB[id] = true
+		if inA[id] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	return float64(intersection) / float64(union)
+}
+
+// RankBiasedOverlap compares two rankings giving more weight to the top ranks, see Webber et al. 2010
+// p is the persistence, the closer it is to 1 the deeper into the rankings the comparison looks
+// This is the extrapolated version, two identical rankings score 1 and disjoint rankings score 0
+func RankBiasedOverlap(a []string, b []string, p float64) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	depth := len(a)
+	if len(b) > depth {
+		depth = len(b)
+	}
+
+	seenA := map[string]bool{}
+	seenB := map[string]bool{}
+	overlap := 0
+	sum := 0.0
+	agreement := 0.0
+	for d := 0; d < depth; d++ {
+		hasA := d < len(a)
+		hasB := d < len(b)
+		if hasA && hasB && a[d] == b[d] {
+			overlap++
+		} else {
+			if hasA && seenB[a[d]] {
+				overlap++
+			}
+			if hasB && seenA[b[d]] {
+				overlap++
+			}
+		}
+		if hasA {
+			seenA[a[d]] = true
+		}
+		if hasB {
+			seenB[b[d]] = true
+		}
+		agreement = float64(overlap) / float64(d+1)
+		sum += math.Pow(p, float64(d)) * agreement
+	}
+	return (1-p)*sum + agreement*math.Pow(p, float64(depth))
+}
+
+// KendallTau is the rank correlation of the items both lists have in common
+// Returns false if fewer than two items are shared since the order can't be compared
+func KendallTau(a []string, b []string) (float64, bool) {
+	rankB := map[string]int{}
+	for i, id := range b {
+		if _, ok := rankB[id]; !ok {
+			rankB[id] = i
+		}
+	}
+	var shared []int
+	seen := map[string]bool{}
+	for _, id := range a {
+		if rank, ok := rankB[id]; ok && !seen[id] {
+			seen[id] = true
+			shared = append(shared, rank)
+		}
+	}
+	if len(shared) < 2 {
+		return 0, false
+	}
+
+	// shared holds the rank in b of each common item, in the order of a
+	concordant := 0
+	discordant := 0
+	for i := 0; i < len(shared); i++ {
+		for j := i + 1; j < len(shared); j++ {
+			if shared[i] < shared[j] {
+				concordant++
+			} else {
+				discordant++
+			}
+		}
+	}
+	return float64(concordant-discordant) / float64(concordant+discordant), true
+}
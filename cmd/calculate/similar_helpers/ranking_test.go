@@ -0,0 +1,74 @@
+package similar_helpers
+
+import "testing"
+
+func TestJaccard(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       []string
+		b       []string
+		jaccard float64
+	}{
+		{"both empty", nil, nil, 1},
+		{"one empty", []string{"a"}, nil, 0},
+		{"identical in another order", []string{"a", "b", "c"}, []string{"c", "a", "b"}, 1},
+		{"half shared", []string{"a", "b", "c"}, []string{"b", "c", "d"}, 2.0 / 4},
+		{"disjoint", []string{"a", "b"}, []string{"c", "d"}, 0},
+		{"duplicates count once", []string{"a", "a", "b"}, []string{"a", "a"}, 1.0 / 2},
+	}
+	for _, test := range tests {
+		if got := Jaccard(test.a, test.b); !closeTo(got, test.jaccard) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.jaccard)
+		}
+	}
+}
+
+func TestRankBiasedOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		p    float64
+		rbo  float64
+	}{
+		{"both empty", nil, nil, 0.9, 1},
+		{"one empty", []string{"a"}, nil, 0.9, 0},
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 0.9, 1},
+		{"disjoint", []string{"a", "b"}, []string{"c", "d"}, 0.9, 0},
+		// agreement 0 at depth 1 and 1 at depth 2: 0.5*(0 + 0.5*1) + 1*0.5^2
+		{"top two swapped", []string{"a", "b"}, []string{"b", "a"}, 0.5, 0.5},
+		// agreement 1 at depth 1 and 1/2 at depth 2: 0.5*(1 + 0.5*0.5) + 0.5*0.5^2
+		{"differ at the second rank", []string{"a", "b"}, []string{"a", "c"}, 0.5, 0.75},
+		// agreement 0 at depth 1 and 1/2 at depth 2: 0.5*(0 + 0.5*0.5) + 0.5*0.5^2
+		{"differ at the first rank", []string{"b", "a"}, []string{"c", "a"}, 0.5, 0.25},
+	}
+	for _, test := range tests {
+		if got := RankBiasedOverlap(test.a, test.b, test.p); !closeTo(got, test.rbo) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.rbo)
+		}
+	}
+}
+
+func TestKendallTau(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		ok   bool
+		tau  float64
+	}{
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"}, true, 1},
+		{"reversed", []string{"a", "b", "c"}, []string{"c", "b", "a"}, true, -1},
+		{"last two swapped", []string{"a", "b", "c"}, []string{"a", "c", "b"}, true, 1.0 / 3},
+		{"only shared items compared", []string{"a", "x", "b"}, []string{"b", "y", "a"}, true, -1},
+		{"one shared item", []string{"a", "b"}, []string{"a", "c"}, false, 0},
+		{"nothing shared", []string{"a"}, []string{"b"}, false, 0},
+		{"empty", nil, nil, false, 0},
+	}
+	for _, test := range tests {
+		tau, ok := KendallTau(test.a, test.b)
+		if ok != test.ok || (ok && !closeTo(tau, test.tau)) {
+			t.Errorf("%s: got %v %v, want %v %v", test.name, tau, ok, test.tau, test.ok)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package similar_helpers
+
+import (
+	"github.com/similar-manga/similar/internal"
+	"regexp"
+	"strings"
+)
+
+// TagGroups are the groups MangaDex puts every tag in
+var TagGroups = []string{"genre", "theme", "format", "content"}
+
+var nonAlphanumeric = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// TagName is the alphanumeric lower case english name the tag vectoriser knows a tag by e.g. "sexualviolence"
+func TagName(name string) string {
+	return strings.ToLower(nonAlphanumeric.ReplaceAllString(name, ""))
+}
+
+// TagCatalogue looks up the UUID and group of the tags the tag vectoriser knows by name
+type TagCatalogue struct {
+	Ids    map[string]string `json:"ids"`    // tag name -> UUID
+	Groups map[string]string `json:"groups"` // UUID -> group
+}
+
+// NewTagCatalogue is built from the tag table synced by "mangadex tags"
+// Tags which are only seen on the manga, e.g. if the table was never synced, are added from there
+func NewTagCatalogue(mangaList []internal.Manga, dbTags []internal.DbTag) TagCatalogue {
+	catalogue := TagCatalogue{Ids: map[string]string{}, Groups: map[string]string{}}
+	for _, tag := range dbTags {
+		catalogue.Ids[TagName(tag.Name)] = tag.Id
+		catalogue.Groups[tag.Id] = tag.Group
+	}
+	for _, manga := range mangaList {
+		for _, tag := range manga.Tags {
+			if tag.Name == nil {
+				continue
+			}
+			// Manga not refreshed since a rename still have the old name, which is the same tag
+			if _, ok := catalogue.Ids[TagName((*tag.Name)["en"])]; !ok {
+				catalogue.Ids[TagName((*tag.Name)["en"])] = tag.Id
+			}
+			if _, ok := catalogue.Groups[tag.Id]; !ok {
+				catalogue.Groups[tag.Id] = tag.Group
+			}
+		}
+	}
+	return catalogue
+}
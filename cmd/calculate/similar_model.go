@@ -0,0 +1,30 @@
+package calculate
+
+import (
+	"bufio"
+	"os"
+)
+
+const similarModelFile = "data/similar_model.json"
+const lastSimilarUpdateFile = "data/last_similar_update.txt"
+
+// Returns the last line of a timestamp file such as data/last_metadata_update.txt
+func readTimestampFile(fileName string) (string, error) {
+	readFile, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer readFile.Close()
+	fileScanner := bufio.NewScanner(readFile)
+	fileScanner.Split(bufio.ScanLines)
+
+	var timestamp string
+	for fileScanner.Scan() {
+		timestamp = fileScanner.Text()
+	}
+	return timestamp, nil
+}
+
+func writeTimestampFile(fileName string, timestamp string) error {
+	return os.WriteFile(fileName, []byte(timestamp), 0755)
+}
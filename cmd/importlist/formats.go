@@ -0,0 +1,194 @@
+package importlist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatMal     = "mal"
+	formatAnilist = "anilist"
+)
+
+// An entry of a reading list, Ids are the site ids in the order they are tried, e.g. al then mal
+type listEntry struct {
+	Ids      []siteId
+	Title    string
+	Status   string
+	Score    float64
+	Progress int
+}
+
+type siteId struct {
+	Site string
+	Id   string
+}
+
+// Reads the list export, gzipped or not, detecting the format from its contents if format is empty
+func readList(fileName string, format string) ([]listEntry, string, error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	// MyAnimeList hands out its exports as .xml.gz
+	if bytes.HasPrefix(contents, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(contents))
+		if err != nil {
+			return nil, "", fmt.Errorf("reading %s: %w", fileName, err)
+		}
+		contents, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, "", fmt.Errorf("reading %s: %w", fileName, err)
+		}
+	}
+
+	if format == "" {
+		trimmed := bytes.TrimSpace(contents)
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("<")):
+			format = formatMal
+		case bytes.HasPrefix(trimmed, []byte("{")):
+			format = formatAnilist
+		default:
+			return nil, "", fmt.Errorf("%s is neither a MyAnimeList xml nor an AniList json export", fileName)
+		}
+	}
+
+	var entries []listEntry
+	switch format {
+	case formatMal:
+		entries, err = parseMalList(contents)
+	case formatAnilist:
+		entries, err = parseAnilistList(contents)
+	default:
+		return nil, "", fmt.Errorf("unknown list format %s, it is either %s or %s", format, formatMal, formatAnilist)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %s as a %s list: %w", fileName, format, err)
+	}
+	return entries, format, nil
+}
+
+// The xml export of https://myanimelist.net/panel.php?go=export
+type malExport struct {
+	Manga []struct {
+		Id           string `xml:"manga_mangadb_id"`
+		Title        string `xml:"manga_title"`
+		Status       string `xml:"my_status"`
+		Score        string `xml:"my_score"`
+		ReadChapters string `xml:"my_read_chapters"`
+	} `xml:"manga"`
+	Anime []struct{} `xml:"anime"`
+}
+
+func parseMalList(contents []byte) ([]listEntry, error) {
+	export := malExport{}
+	if err := xml.Unmarshal(contents, &export); err != nil {
+		return nil, err
+	}
+	if len(export.Manga) == 0 && len(export.Anime) > 0 {
+		return nil, errors.New("it is an anime list, export the manga list instead")
+	}
+	var entries []listEntry
+	for _, manga := range export.Manga {
+		score, _ := strconv.ParseFloat(strings.TrimSpace(manga.Score), 64)
+		progress, _ := strconv.Atoi(strings.TrimSpace(manga.ReadChapters))
+		entries = append(entries, listEntry{
+			Ids:      []siteId{{Site: "mal", Id: strings.TrimSpace(manga.Id)}},
+			Title:    strings.TrimSpace(manga.Title),
+			Status:   normaliseStatus(manga.Status),
+			Score:    score,
+			Progress: progress,
+		})
+	}
+	return entries, nil
+}
+
+// The MediaListCollection of the AniList graphql api, as saved from a query of the user's manga list
+// The {"data": ...} wrapper of the api response is optional
+type anilistExport struct {
+	Data                *anilistExport `json:"data"`
+	MediaListCollection struct {
+		Lists []struct {
+			Entries []struct {
+				Status   string  `json:"status"`
+				Score    float64 `json:"score"`
+				Progress int     `json:"progress"`
+				Media    struct {
+					Id    int  `json:"id"`
+					IdMal *int `json:"idMal"`
+					Title struct {
+						Romaji  string `json:"romaji"`
+						English string `json:"english"`
+					} `json:"title"`
+				} `json:"media"`
+			} `json:"entries"`
+		} `json:"lists"`
+	} `json:"MediaListCollection"`
+}
+
+func parseAnilistList(contents []byte) ([]listEntry, error) {
+	export := anilistExport{}
+	if err := json.Unmarshal(contents, &export); err != nil {
+		return nil, err
+	}
+	if export.Data != nil {
+		export = *export.Data
+	}
+
+	var entries []listEntry
+	// A manga can be in more than one custom list, it is only imported once
+	seen := map[int]bool{}
+	for _, list := range export.MediaListCollection.Lists {
+		for _, entry := range list.Entries {
+			if seen[entry.Media.Id] {
+				continue
+			}
+			seen[entry.Media.Id] = true
+
+			ids := []siteId{{Site: "al", Id: strconv.Itoa(entry.Media.Id)}}
+			if entry.Media.IdMal != nil {
+				ids = append(ids, siteId{Site: "mal", Id: strconv.Itoa(*entry.Media.IdMal)})
+			}
+			title := entry.Media.Title.English
+			if title == "" {
+				title = entry.Media.Title.Romaji
+			}
+			entries = append(entries, listEntry{
+				Ids:      ids,
+				Title:    title,
+				Status:   normaliseStatus(entry.Status),
+				Score:    entry.Score,
+				Progress: entry.Progress,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Brings the statuses of both sites to the same names, e.g. "Plan to Read" and PLANNING to plan_to_read
+func normaliseStatus(status string) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "reading", "current":
+		return "reading"
+	case "completed":
+		return "completed"
+	case "on-hold", "paused":
+		return "on_hold"
+	case "dropped":
+		return "dropped"
+	case "plan to read", "planning":
+		return "plan_to_read"
+	case "repeating":
+		return "rereading"
+	}
+	return strings.ToLower(strings.TrimSpace(status))
+}
@@ -0,0 +1,149 @@
+package importlist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const malXml = `<?xml version="1.0" encoding="UTF-8" ?>
+<myanimelist>
+	<myinfo><user_export_type>2</user_export_type></myinfo>
+	<manga>
+		<manga_mangadb_id>2</manga_mangadb_id>
+		<manga_title><![CDATA[Berserk]]></manga_title>
+		<my_read_chapters>350</my_read_chapters>
+		<my_score>10</my_score>
+		<my_status>Reading</my_status>
+	</manga>
+	<manga>
+		<manga_mangadb_id> 13 </manga_mangadb_id>
+		<manga_title><![CDATA[One Piece]]></manga_title>
+		<my_read_chapters>0</my_read_chapters>
+		<my_score>0</my_score>
+		<my_status>Plan to Read</my_status>
+	</manga>
+</myanimelist>`
+
+const malAnimeXml = `<?xml version="1.0" encoding="UTF-8" ?>
+<myanimelist>
+	<myinfo><user_export_type>1</user_export_type></myinfo>
+	<anime>
+		<series_animedb_id>1</series_animedb_id>
+		<series_title><![CDATA[Cowboy Bebop]]></series_title>
+		<my_status>Completed</my_status>
+	</anime>
+</myanimelist>`
+
+var malEntries = []listEntry{
+	{Ids: []siteId{{Site: "mal", Id: "2"}}, Title: "Berserk", Status: "reading", Score: 10, Progress: 350},
+	{Ids: []siteId{{Site: "mal", Id: "13"}}, Title: "One Piece", Status: "plan_to_read"},
+}
+
+// Berserk is in the custom list as well as the reading list
+const anilistCollection = `{"MediaListCollection": {"lists": [
+	{"name": "Reading", "entries": [
+		{"status": "CURRENT", "score": 9.5, "progress": 350, "media": {"id": 30002, "idMal": 2, "title": {"romaji": "Berserk", "english": "Berserk"}}},
+		{"status": "CURRENT", "score": 0, "progress": 12, "media": {"id": 30013, "idMal": null, "title": {"romaji": "One Piece", "english": null}}}
+	]},
+	{"name": "Favourites", "entries": [
+		{"status": "CURRENT", "score": 9.5, "progress": 350, "media": {"id": 30002, "idMal": 2, "title": {"romaji": "Berserk", "english": "Berserk"}}}
+	]},
+	{"name": "Planning", "entries": [
+		{"status": "PLANNING", "score": 0, "progress": 0, "media": {"id": 86635, "idMal": 103897, "title": {"romaji": "Kimetsu no Yaiba", "english": "Demon Slayer"}}}
+	]}
+]}}`
+
+var anilistEntries = []listEntry{
+	{Ids: []siteId{{Site: "al", Id: "30002"}, {Site: "mal", Id: "2"}}, Title: "Berserk", Status: "reading", Score: 9.5, Progress: 350},
+	{Ids: []siteId{{Site: "al", Id: "30013"}}, Title: "One Piece", Status: "reading", Progress: 12},
+	{Ids: []siteId{{Site: "al", Id: "86635"}, {Site: "mal", Id: "103897"}}, Title: "Demon Slayer", Status: "plan_to_read"},
+}
+
+func gzipped(t *testing.T, contents string) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestReadList(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		format   string
+		detected string
+		entries  []listEntry
+		err      string
+	}{
+		{name: "mal xml", contents: malXml, detected: formatMal, entries: malEntries},
+		{name: "gzipped mal xml", contents: gzipped(t, malXml), detected: formatMal, entries: malEntries},
+		{name: "mal xml given its format", contents: malXml, format: formatMal, detected: formatMal, entries: malEntries},
+		{name: "mal anime export", contents: malAnimeXml, err: "anime list"},
+		{name: "gzipped mal anime export", contents: gzipped(t, malAnimeXml), err: "anime list"},
+		{name: "anilist api response", contents: `{"data": ` + anilistCollection + `}`, detected: formatAnilist, entries: anilistEntries},
+		{name: "anilist without the data wrapper", contents: anilistCollection, detected: formatAnilist, entries: anilistEntries},
+		{name: "anilist with leading whitespace", contents: "\n  " + anilistCollection, detected: formatAnilist, entries: anilistEntries},
+		{name: "anilist given the mal format", contents: anilistCollection, format: formatMal, err: "as a mal list"},
+		{name: "unknown format", contents: malXml, format: "kitsu", err: "unknown list format kitsu, it is either mal or anilist"},
+		{name: "neither xml nor json", contents: "id,title\n2,Berserk\n", err: "is neither a MyAnimeList xml nor an AniList json export"},
+		{name: "empty file", contents: "", err: "is neither a MyAnimeList xml nor an AniList json export"},
+	}
+	for _, test := range tests {
+		fileName := filepath.Join(t.TempDir(), "list")
+		if err := os.WriteFile(fileName, []byte(test.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries, format, err := readList(fileName, test.format)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if format != test.detected {
+			t.Errorf("%s: read as %s, want %s", test.name, format, test.detected)
+		}
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("%s: got %+v, want %+v", test.name, entries, test.entries)
+		}
+	}
+
+	if _, _, err := readList(filepath.Join(t.TempDir(), "missing.xml"), ""); err == nil {
+		t.Error("read a missing file without an error")
+	}
+}
+
+func TestNormaliseStatus(t *testing.T) {
+	tests := map[string]string{
+		"Reading":      "reading",
+		"CURRENT":      "reading",
+		"Completed":    "completed",
+		"On-Hold":      "on_hold",
+		"PAUSED":       "on_hold",
+		"Dropped":      "dropped",
+		"Plan to Read": "plan_to_read",
+		"PLANNING":     "plan_to_read",
+		"REPEATING":    "rereading",
+		" Unknown ":    "unknown",
+	}
+	for status, want := range tests {
+		if got := normaliseStatus(status); got != want {
+			t.Errorf("%q: got %q, want %q", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package importlist
+
+import (
+	"github.com/similar-manga/similar/cmd"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import command",
+	Long: `
+Actions which bring data from other sites in through the external site mappings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			_ = cmd.Help()
+			os.Exit(0)
+		}
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,132 @@
+package importlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list <file>",
+	Short: "Resolve a MyAnimeList or AniList reading list export to MangaDex uuids",
+	Long: `
+Read a reading list export and resolve its entries to MangaDex uuids through the mappings of
+./similar calculate mappings, reporting the entries which aren't mapped.
+
+  ./similar import list animelist_123_-_456.xml.gz
+  ./similar import list anilist.json --output data/my_list.json
+
+A MyAnimeList export is the xml, or xml.gz, of https://myanimelist.net/panel.php?go=export.
+An AniList export is the json response of a MediaListCollection query for the manga list, with the media
+id and idMal of each entry. Entries are resolved by their AniList id and then their MyAnimeList id.
+The format is detected from the file unless --format is given.
+
+The resolved and unresolved entries, with their status, score and progress, are written as json to --output.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runList,
+}
+
+func init() {
+	importCmd.AddCommand(listCmd)
+	listCmd.Flags().StringP("format", "f", "", "Format of the export, mal or anilist, detected from the file if empty")
+	listCmd.Flags().StringP("output", "o", "data/imported_list.json", "File the json of the resolved list is written to")
+}
+
+type importReport struct {
+	File       string        `json:"file"`
+	Format     string        `json:"format"`
+	ImportedAt string        `json:"importedAt"`
+	Resolved   []importEntry `json:"resolved"`
+	Unresolved []importEntry `json:"unresolved"`
+}
+
+type importEntry struct {
+	// Site ids of the entry in the export, keyed by the site key
+	Ids map[string]string `json:"ids"`
+	// Site whose id the MangaDex manga were found by
+	ResolvedBy string   `json:"resolvedBy,omitempty"`
+	MangaIds   []string `json:"mangaIds,omitempty"`
+	Title      string   `json:"title"`
+	Status     string   `json:"status"`
+	Score      float64  `json:"score"`
+	Progress   int      `json:"progress"`
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("format")
+	outputFile, _ := cmd.Flags().GetString("output")
+
+	entries, format, err := readList(args[0], format)
+	internal.CheckErr(err)
+
+	report := importReport{
+		File:       args[0],
+		Format:     format,
+		ImportedAt: time.Now().UTC().Format(time.RFC3339),
+		Resolved:   []importEntry{},
+		Unresolved: []importEntry{},
+	}
+	for _, entry := range entries {
+		imported, err := resolveEntry(entry)
+		internal.CheckErr(err)
+		if len(imported.MangaIds) > 0 {
+			report.Resolved = append(report.Resolved, imported)
+		} else {
+			report.Unresolved = append(report.Unresolved, imported)
+		}
+	}
+
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	internal.CheckErr(err)
+	internal.CheckErr(os.WriteFile(outputFile, jsonReport, 0777))
+
+	for _, entry := range report.Unresolved {
+		fmt.Printf("unresolved %s (%s)\n", entry.Title, formatIds(entry.Ids))
+	}
+	fmt.Printf("Resolved %d of %d %s entries, written to %s\n", len(report.Resolved), len(entries), format, outputFile)
+}
+
+// Looks the ids of the entry up in order, stopping at the first site which has the manga mapped
+func resolveEntry(entry listEntry) (importEntry, error) {
+	imported := importEntry{
+		Ids:      map[string]string{},
+		Title:    entry.Title,
+		Status:   entry.Status,
+		Score:    entry.Score,
+		Progress: entry.Progress,
+	}
+	for _, id := range entry.Ids {
+		imported.Ids[id.Site] = id.Id
+	}
+	for _, id := range entry.Ids {
+		site, ok := internal.GetMappingSite(id.Site)
+		if !ok || id.Id == "" {
+			continue
+		}
+		mangaIds, err := site.LookupMangaIds(id.Id)
+		if err != nil {
+			return imported, err
+		}
+		if len(mangaIds) > 0 {
+			imported.ResolvedBy = id.Site
+			imported.MangaIds = mangaIds
+			return imported, nil
+		}
+	}
+	return imported, nil
+}
+
+// e.g. "al 30013, mal 2"
+func formatIds(ids map[string]string) string {
+	var formatted []string
+	for site, id := range ids {
+		formatted = append(formatted, site+" "+id)
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ", ")
+}
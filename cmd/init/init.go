@@ -2,11 +2,11 @@ package calculate
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/similar-manga/similar/cmd"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
-	"io"
 	"log"
 	"os"
 	"strings"
@@ -16,18 +16,28 @@ import (
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the database data",
-	Long:  `Initializes the database with the stored data in the repository`,
-	Run:   runInit,
+	Long: `Initializes the database with the stored data in the repository.
+The tables are created by the sql migrations in internal/migrations/data, an existing data.db is replaced.
+With --migrate-only the migrations data.db doesn't have yet are applied to it in place, keeping its data.`,
+	Run: runInit,
 }
 
 func init() {
 	cmd.RootCmd.AddCommand(initCmd)
+	initCmd.Flags().Bool("migrate-only", false, "upgrade the schema of the existing data.db instead of recreating it")
 }
 
 func runInit(cmd *cobra.Command, args []string) {
 	fmt.Println("Begin init")
 	startProcessing := time.Now()
 
+	migrateOnly, _ := cmd.Flags().GetBool("migrate-only")
+	if migrateOnly {
+		migrateMangaDB()
+		fmt.Printf("Migrated in %s\n\n", time.Since(startProcessing))
+		return
+	}
+
 	createMangaDB()
 	populateMangaDB()
 	populateMangaUpdatesMappingDB()
@@ -37,28 +47,43 @@ func runInit(cmd *cobra.Command, args []string) {
 
 func createMangaDB() {
 	fmt.Println("Creating manga.db")
-	src, err := os.Open("data/default_empty_data.db")
+	// Reconnect so nothing is still using the old file
+	internal.CheckErr(internal.DB.Close())
+	err := os.Remove(internal.DBFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		internal.CheckErr(err)
+	}
+	internal.ConnectDB()
+	migrateMangaDB()
+}
+
+func migrateMangaDB() {
+	version, err := internal.SchemaVersion(internal.DB)
 	internal.CheckErr(err)
-	defer src.Close()
-	dst, err := os.Create("data/data.db")
+	applied, err := internal.Migrate(internal.DB, internal.MigrationsData)
+	for _, migration := range applied {
+		fmt.Printf("  | applied migration %s\n", migration.Name)
+	}
 	internal.CheckErr(err)
-	defer dst.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := src.Read(buf)
-		if err != nil && err != io.EOF {
-			internal.CheckErr(err)
-			break
-		}
-
-		if n == 0 {
-			break
+	for _, migration := range applied {
+		if migration.Name == "0003_mapping_raw_ids" {
+			normaliseStoredMappings()
 		}
+	}
+	if len(applied) == 0 {
+		fmt.Printf("Schema is up-to-date at version %d\n", version)
+	} else {
+		fmt.Printf("Schema upgraded from version %d to %d\n", version, applied[len(applied)-1].Version)
+	}
+}
 
-		if _, err := dst.Write(buf[:n]); err != nil {
-			internal.CheckErr(err)
-			break
+// The mappings stored before 0003_mapping_raw_ids have the links as MangaDex had them as their ids
+func normaliseStoredMappings() {
+	for _, site := range internal.MappingSites {
+		updated, deleted, err := site.NormaliseStoredIds()
+		internal.CheckErr(err)
+		if updated > 0 || deleted > 0 {
+			fmt.Printf("  | normalised %d %s ids, deleted %d which aren't ids\n", updated, site.Name, deleted)
 		}
 	}
 }
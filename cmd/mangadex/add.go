@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"github.com/antihax/optional"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
 	"github.com/similar-manga/similar/mangadex"
 	"github.com/spf13/cobra"
 	"go.uber.org/ratelimit"
+	"os"
+	"os/signal"
 	"time"
 )
 
@@ -15,48 +18,80 @@ import (
 var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "queries and adds all the new manga UUID's to txt",
-	Long:  `This searches for manga ordered by date added (newest first) and adds the Manga UUID to the txt until an existing UUID is found`,
-	Run:   runAdd,
+	Long: `This walks the manga ordered by date added (oldest first), starting from the newest manga seen by the last run,
+and adds every Manga UUID which isn't in the database yet. Without the timestamp of a previous run it starts from the
+newest manga already in the database, the whole catalogue is only walked with --all or when the database has no manga.`,
+	Run: runAdd,
 }
 
 func init() {
 	mangadexCmd.AddCommand(addCmd)
+	addCmd.Flags().BoolP("all", "a", false, "walk the whole catalogue instead of starting from the last run")
 }
 
-func runAdd(cmd *cobra.Command, args []string) {
-
-	rateLimiter := ratelimit.New(1, ratelimit.Per(2*time.Second))
+const lastMangaAddFile = "data/last_manga_add.txt"
 
-	client := CreateMangaDexClient()
-	ctx := context.Background()
-
-	currentLimit := int32(100)
-	maxOffset := int32(10000)
-	done := false
-	count := 0
+func runAdd(cmd *cobra.Command, args []string) {
+	addAll, _ := cmd.Flags().GetBool("all")
 
-	for currentOffset := int32(0); currentOffset < maxOffset && done == false; currentOffset += currentLimit {
+	client := CreateMangaDexClient(ratelimit.New(1, ratelimit.Per(2*time.Second)))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary := internal.NewErrorSummary()
 
-		opts := mangadex.MangaApiGetSearchMangaOpts{}
-		opts.OrderCreatedAt = optional.NewString("desc")
-		opts.Limit = optional.NewInt32(currentLimit)
-		opts.Offset = optional.NewInt32(currentOffset)
+	since := ""
+	if !addAll {
+		var err error
+		since, err = readLastTimestamp(lastMangaAddFile)
+		summary.CheckErr(err)
+		if since == "" {
+			since, err = internal.GetNewestMangaCreatedAt()
+			summary.CheckErr(err)
+			if since != "" {
+				fmt.Printf("No %s, starting from the newest manga in the database\n", lastMangaAddFile)
+			}
+		}
+	}
+	if since != "" {
+		fmt.Printf("Getting manga created since -> %s\n", since)
+	} else {
+		fmt.Printf("Getting every manga on MangaDex\n")
+	}
 
-		mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
+	opts := mangadex.MangaApiGetSearchMangaOpts{}
+	opts.Limit = optional.NewInt32(100)
+	paginator := mangadex.NewMangaPaginator(client, mangadex.PaginateCreatedAt, since, opts)
 
-		for _, apiManga := range mangaList.Data {
-			if !ExistsInDatabase(apiManga.Id) {
-				count++
-				UpsertManga(apiManga)
-				fmt.Printf("Inserting manga with ID: %s\n", apiManga.Id)
-			} else {
-				done = true
-				break
+	count := 0
+	lastCreatedAt := since
+	for paginator.Next(ctx) {
+		apiManga := paginator.Manga()
+		lastCreatedAt = apiManga.Attributes.CreatedAt
+		exists, err := ExistsInDatabase(apiManga.Id)
+		if err != nil {
+			summary.Add("inserting manga", err)
+			continue
+		}
+		if !exists {
+			if err := UpsertManga(apiManga); err != nil {
+				summary.Add("inserting manga", err)
+				continue
 			}
+			count++
+			fmt.Printf("Inserting manga with ID: %s\n", apiManga.Id)
 		}
 	}
 	fmt.Printf("Inserted %d manga\n", count)
+	summary.CheckErr(paginator.Err())
+
+	// Only written once the walk finished without skipping any manga, so an interrupted run starts from the same place again
+	if summary.Len() == 0 {
+		summary.CheckErr(writeLastTimestamp(lastMangaAddFile, lastCreatedAt))
+	} else {
+		fmt.Printf("Not moving %s on, so the skipped manga are tried again next run\n", lastMangaAddFile)
+	}
 
-	ExportManga()
+	summary.Add("exporting manga", ExportManga())
+	summary.Print()
 
 }
@@ -10,25 +10,35 @@ import (
 	"github.com/similar-manga/similar/internal"
 	"github.com/similar-manga/similar/mangadex"
 	"go.uber.org/ratelimit"
-	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 )
 
-func ApiMangaToJson(apiManga mangadex.Manga) []byte {
+func ApiMangaToJson(apiManga mangadex.Manga) ([]byte, error) {
 	tags := make([]internal.Tag, 0, len(apiManga.Attributes.Tags))
 	for _, r := range apiManga.Attributes.Tags {
 		tags = append(tags, internal.Tag{
-			Id:   r.Id,
-			Name: r.Attributes.Name,
+			Id:    r.Id,
+			Name:  r.Attributes.Name,
+			Group: r.Attributes.Group,
 		})
 	}
 	var relatedIds []string
+	var relations []internal.Relation
+	var authors []internal.Creator
+	var artists []internal.Creator
 	for _, r := range apiManga.Relationships {
 		if r.Related != "" {
 			relatedIds = append(relatedIds, r.Id)
+			relations = append(relations, internal.Relation{Id: r.Id, Type: r.Related})
+		}
+		switch r.Type_ {
+		case "author":
+			authors = append(authors, apiRelationshipToCreator(r))
+		case "artist":
+			artists = append(artists, apiRelationshipToCreator(r))
 		}
 	}
 
@@ -38,124 +48,183 @@ func ApiMangaToJson(apiManga mangadex.Manga) []byte {
 		AltTitles:                    apiManga.Attributes.AltTitles,
 		Description:                  apiManga.Attributes.Description,
 		LastChapter:                  apiManga.Attributes.LastChapter,
+		LastVolume:                   apiManga.Attributes.LastVolume,
+		Status:                       apiManga.Attributes.Status,
+		Year:                         apiManga.Attributes.Year,
 		AvailableTranslatedLanguages: apiManga.Attributes.AvailableTranslatedLanguages,
 		RelatedIds:                   relatedIds,
+		Relations:                    relations,
 		Links:                        apiManga.Attributes.Links,
 		OriginalLanguage:             apiManga.Attributes.OriginalLanguage,
 		PublicationDemographic:       apiManga.Attributes.PublicationDemographic,
 		ContentRating:                apiManga.Attributes.ContentRating,
 		Tags:                         tags,
+		Authors:                      authors,
+		Artists:                      artists,
+		CreatedAt:                    apiManga.Attributes.CreatedAt,
+		UpdatedAt:                    apiManga.Attributes.UpdatedAt,
 	}
 
 	dst := &bytes.Buffer{}
-	jsonManga, _ := json.Marshal(manga)
-	err := json.Compact(dst, jsonManga)
-	internal.CheckErr(err)
-	return dst.Bytes()
+	jsonManga, err := json.Marshal(manga)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Compact(dst, jsonManga)
+	if err != nil {
+		return nil, err
+	}
+	return dst.Bytes(), nil
 }
 
-func CreateMangaDexClient() *mangadex.APIClient {
+// The name is only there when the author and artist references are expanded, which the search always requests
+func apiRelationshipToCreator(r mangadex.Relationship) internal.Creator {
+	creator := internal.Creator{Id: r.Id}
+	if r.Attributes != nil {
+		if attributes, ok := (*r.Attributes).(map[string]interface{}); ok {
+			creator.Name, _ = attributes["name"].(string)
+		}
+	}
+	return creator
+}
+
+// The client retries and waits out rate limits in its transport, so commands don't need their own retry loops
+// Requests are spaced out by rateLimiter, each command keeps the rate it always had
+func CreateMangaDexClient(rateLimiter ratelimit.Limiter) *mangadex.APIClient {
 	config := mangadex.NewConfiguration()
 	config.UserAgent = "similar-manga v3.0"
-	config.HTTPClient = &http.Client{
-		Timeout: 30 * time.Second,
+	transport := mangadex.NewRateLimitTransport(internal.Transport)
+	transport.Limiter = rateLimiter
+	if internal.Offline {
+		// Recorded and stubbed responses aren't rate limited, but a cassette holds the retries of the recording
+		// e.g. a 429 and then the 200 of its retry, so the retries still have to happen, only without waiting
+		transport.Limiter = ratelimit.NewUnlimited()
+		transport.NoWait = true
 	}
+	config.HTTPClient = &http.Client{Transport: transport}
 	return mangadex.NewAPIClient(config)
 }
 
-func SearchMangaDex(rateLimiter ratelimit.Limiter, client *mangadex.APIClient, ctx context.Context, opts mangadex.MangaApiGetSearchMangaOpts) mangadex.MangaList {
-	maxRetries := 10
-	mangaList := mangadex.MangaList{}
-	resp := &http.Response{}
-	err := errors.New("startup")
-
-	for retryCount := 0; retryCount <= maxRetries && err != nil; retryCount++ {
-		rateLimiter.Take()
-		mangaList, resp, err = client.MangaApi.GetSearchManga(ctx, &opts)
-		if err != nil {
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): Status Code %v : %v\u001B[0m\n", retryCount, maxRetries, resp.StatusCode, err)
-			if err.Error() == "undefined response type text/html; charset=utf-8" {
-				fmt.Println("Sleeping 5 secs since we likely hit the soft rate limit")
-				time.Sleep(5 * time.Second)
-			}
-		} else if resp == nil {
-			err = errors.New("invalid response object")
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): respose object is nil\u001B[0m\n", retryCount, maxRetries)
-			continue
-		} else if resp.StatusCode != 200 && resp.StatusCode != 204 {
-			err = errors.New("invalid http error code")
-			fmt.Printf("\u001B[1;31mMANGA ERROR (%d of %d): http code %d\u001B[0m\n", retryCount, maxRetries, resp.StatusCode)
-		} else if resp.StatusCode == 429 {
-			err = errors.New("rate limited")
-			fmt.Printf("\u001B[1;31mRate Limited!! Sleeping. (%d of %d): http code %d\u001B[0m\n", retryCount, maxRetries, resp.StatusCode)
-			time.Sleep(time.Duration(int64(500)) * time.Millisecond)
-
+func SearchMangaDex(client *mangadex.APIClient, ctx context.Context, opts mangadex.MangaApiGetSearchMangaOpts) (mangadex.MangaList, error) {
+	mangaList, resp, err := client.MangaApi.GetSearchManga(ctx, &opts)
+	if err != nil {
+		if resp != nil {
+			return mangaList, fmt.Errorf("manga search failed with http code %d: %w", resp.StatusCode, err)
 		}
+		return mangaList, fmt.Errorf("manga search failed: %w", err)
+	}
+	return mangaList, nil
+}
 
-		if err == nil {
-			//ignore the error if it fails to close
-			_ = resp.Body.Close()
-		}
+// Reads the last line of a timestamp file, empty if there is no file yet
+func readLastTimestamp(fileName string) (string, error) {
+	contents, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
 	}
-	return mangaList
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
 
+func writeLastTimestamp(fileName string, timestamp string) error {
+	return os.WriteFile(fileName, []byte(timestamp), 0755)
 }
 
-func ExistsInDatabase(uuid string) bool {
-	rows, err := internal.DB.Query("SELECT UUID FROM " + internal.TableManga + " WHERE UUID= '" + uuid + "'")
-	defer rows.Close()
-	internal.CheckErr(err)
-	return rows.Next()
+func ExistsInDatabase(uuid string) (bool, error) {
+	var count int
+	err := internal.DB.QueryRow("SELECT COUNT(*) FROM "+internal.TableManga+" WHERE UUID = ?", uuid).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("looking up manga %s: %w", uuid, err)
+	}
+	return count > 0, nil
 }
 
-func UpsertManga(apiManga mangadex.Manga) {
-	jsonManga := ApiMangaToJson(apiManga)
+func UpsertManga(apiManga mangadex.Manga) error {
+	jsonManga, err := ApiMangaToJson(apiManga)
+	if err != nil {
+		return fmt.Errorf("converting manga %s: %w", apiManga.Id, err)
+	}
 	currentDate := strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0]
-	_, err := internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", apiManga.Id, jsonManga, currentDate)
-	internal.CheckErr(err)
+	_, err = internal.DB.Exec("INSERT INTO "+internal.TableManga+" (UUID, JSON, DATE) VALUES (?, ?, ?) ON CONFLICT (UUID) DO UPDATE SET JSON=excluded.JSON", apiManga.Id, jsonManga, currentDate)
+	if err != nil {
+		return fmt.Errorf("storing manga %s: %w", apiManga.Id, err)
+	}
+	return nil
+}
+
+// TombstoneManga marks a manga as no longer on MangaDex, returning false if it was already tombstoned
+// Upserting the manga again, if it comes back, clears the tombstone
+func TombstoneManga(uuid string, tombstonedAt string) (bool, error) {
+	result, err := internal.DB.Exec("UPDATE "+internal.TableManga+" SET JSON = json_set(JSON, '$.tombstonedAt', ?) WHERE UUID = ? AND json_extract(JSON, '$.tombstonedAt') IS NULL", tombstonedAt, uuid)
+	if err != nil {
+		return false, fmt.Errorf("tombstoning manga %s: %w", uuid, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("tombstoning manga %s: %w", uuid, err)
+	}
+	return rowsAffected > 0, nil
 }
 
-func getDBManga() []internal.DbManga {
+func getDBManga() ([]internal.DbManga, error) {
 	rows, err := internal.DB.Query("SELECT UUID, JSON, DATE FROM " + internal.TableManga + " ORDER BY DATE ASC")
+	if err != nil {
+		return nil, fmt.Errorf("reading manga: %w", err)
+	}
 	defer rows.Close()
-	internal.CheckErr(err)
 
 	var mangaList []internal.DbManga
 	for rows.Next() {
 		manga := internal.DbManga{}
-		rows.Scan(&manga.Id, &manga.JSON, &manga.DATE)
-		internal.CheckErr(err)
+		if err := rows.Scan(&manga.Id, &manga.JSON, &manga.DATE); err != nil {
+			return nil, fmt.Errorf("reading manga: %w", err)
+		}
 		mangaList = append(mangaList, manga)
 	}
-	return mangaList
+	return mangaList, rows.Err()
 }
 
-func ExportManga() {
+func ExportManga() error {
 	fmt.Printf("Exporting All Manga to txt files\n")
+	mangaList, err := getDBManga()
+	if err != nil {
+		return err
+	}
 	os.RemoveAll("data/manga/")
 	os.MkdirAll("data/manga/", 0777)
-	mangaList := getDBManga()
 	suffix := 1
-	file := createMangaFile(suffix)
+	file, err := createMangaFile(suffix)
+	if err != nil {
+		return err
+	}
 	for index, manga := range mangaList {
 		if index > 0 && index%1000 == 0 {
 			suffix++
 			file.Close()
-			file = createMangaFile(suffix)
+			file, err = createMangaFile(suffix)
+			if err != nil {
+				return err
+			}
 		}
 
-		file.WriteString(manga.Id + ":::||@!@||:::" + manga.DATE + ":::||@!@||:::" + manga.JSON + "\n")
+		if _, err := file.WriteString(manga.Id + ":::||@!@||:::" + manga.DATE + ":::||@!@||:::" + manga.JSON + "\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("exporting manga %s: %w", manga.Id, err)
+		}
 
 	}
 
-	file.Close()
+	return file.Close()
 
 }
 
-func createMangaFile(number int) *os.File {
+func createMangaFile(number int) (*os.File, error) {
 	file, err := os.Create("data/manga/manga_" + fmt.Sprintf("%04d", number) + ".txt")
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting manga: %w", err)
 	}
-	return file
+	return file, nil
 }
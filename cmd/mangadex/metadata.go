@@ -1,8 +1,9 @@
 package mangadex
 
 import (
-	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/antihax/optional"
 	_ "github.com/mattn/go-sqlite3"
@@ -11,6 +12,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/ratelimit"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"time"
@@ -25,26 +27,48 @@ var metadataCmd = &cobra.Command{
 
 func init() {
 	mangadexCmd.AddCommand(metadataCmd)
-	metadataCmd.Flags().BoolP("all", "a", false, "queries and updates the entire database")
+	metadataCmd.Flags().BoolP("all", "a", false, "queries and updates the entire database, tombstoning manga no longer on MangaDex")
 	metadataCmd.Flags().StringP("id", "i", "", "update metadata for a specific uuid in the database")
 
 }
 
+const lastMetadataUpdateFile = "data/last_metadata_update.txt"
+const tombstoneReportFile = "data/tombstone_report.json"
+
+// Manga tombstoned by a "metadata --all" run
+type tombstoneReport struct {
+	GeneratedAt string            `json:"generatedAt"`
+	Removed     []tombstonedManga `json:"removed"`
+}
+
+type tombstonedManga struct {
+	Id           string `json:"id"`
+	Title        string `json:"title"`
+	TombstonedAt string `json:"tombstonedAt"`
+}
+
 func runMetadata(cmd *cobra.Command, args []string) {
 	start := time.Now()
 
 	updateAll, _ := cmd.Flags().GetBool("all")
 	updateId, _ := cmd.Flags().GetString("id")
 
-	client := CreateMangaDexClient()
-	ctx := context.Background()
+	// Batches of known ids go at one request a second, paging through the recent updates at one every 2 seconds
+	rateLimiter := ratelimit.New(1)
+	if !updateAll && updateId == "" {
+		rateLimiter = ratelimit.New(1, ratelimit.Per(2*time.Second))
+	}
+	client := CreateMangaDexClient(rateLimiter)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary := internal.NewErrorSummary()
 
 	if updateAll {
 		fmt.Printf("Getting mangadex metadata for all entries\n")
 
-		rateLimiter := ratelimit.New(1)
-
-		mangaIdArray := collectAllMangaIds()
+		mangaIdArray, err := collectAllMangaIds()
+		summary.CheckErr(err)
+		report := tombstoneReport{GeneratedAt: strings.Split(start.UTC().Format(time.RFC3339), "Z")[0], Removed: []tombstonedManga{}}
 
 		for index, ids := range mangaIdArray {
 
@@ -55,93 +79,149 @@ func runMetadata(cmd *cobra.Command, args []string) {
 
 			fmt.Printf("Getting mangadex metadata for batch group %d/%d\n", index+1, len(mangaIdArray))
 
-			mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
+			// The transport already retried, so skip the batch rather than tombstoning everything in it
+			mangaList, err := SearchMangaDex(client, ctx, opts)
+			summary.CheckErr(ctx.Err())
+			if err != nil {
+				summary.Add("updating manga", fmt.Errorf("batch %d/%d: %w", index+1, len(mangaIdArray), err))
+				continue
+			}
+
+			// A batch with none of its manga is more likely a bad response than all of them removed at once
+			if len(mangaList.Data) == 0 {
+				summary.Add("updating manga", fmt.Errorf("batch %d/%d: none of its %d manga were returned, not tombstoning them", index+1, len(mangaIdArray), len(ids)))
+				continue
+			}
 
+			returnedIds := map[string]bool{}
 			for _, apiManga := range mangaList.Data {
-				UpsertManga(apiManga)
+				returnedIds[apiManga.Id] = true
+				summary.Add("updating manga", UpsertManga(apiManga))
+			}
+
+			// Every content rating is requested, so ids missing from the batch were deleted or merged on MangaDex,
+			// unless the batch came back short, so each is asked for on its own before it is tombstoned
+			for _, uuid := range ids {
+				if returnedIds[uuid] {
+					continue
+				}
+				removed, err := confirmMangaRemoved(client, ctx, uuid)
+				summary.CheckErr(ctx.Err())
+				if err != nil {
+					summary.Add("tombstoning manga", err)
+					continue
+				}
+				if !removed {
+					continue
+				}
+				tombstoned, err := TombstoneManga(uuid, report.GeneratedAt)
+				if err != nil {
+					summary.Add("tombstoning manga", err)
+					continue
+				}
+				if tombstoned {
+					fmt.Printf("\u001B[1;33mTombstoned manga %s, it is no longer on MangaDex\u001B[0m\n", uuid)
+					title, err := getDBMangaTitle(uuid)
+					summary.Add("tombstoning manga", err)
+					report.Removed = append(report.Removed, tombstonedManga{Id: uuid, Title: title, TombstonedAt: report.GeneratedAt})
+				}
 			}
 		}
 
+		fmt.Printf("Tombstoned %d manga, see %s\n", len(report.Removed), tombstoneReportFile)
+		jsonReport, err := json.MarshalIndent(report, "", "  ")
+		summary.CheckErr(err)
+		err = os.WriteFile(tombstoneReportFile, jsonReport, 0644)
+		summary.CheckErr(err)
+
 	} else if updateId != "" {
 		fmt.Printf("Updating MangaDex metadata for %s\n", updateId)
-		rateLimiter := ratelimit.New(1)
 
 		opts := mangadex.MangaApiGetSearchMangaOpts{}
 		opts.OrderCreatedAt = optional.NewString("desc")
 		opts.Limit = optional.NewInt32(1)
 		opts.Ids = optional.NewInterface([]string{updateId})
-		mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
+		mangaList, err := SearchMangaDex(client, ctx, opts)
+		summary.CheckErr(err)
 		for _, apiManga := range mangaList.Data {
-			UpsertManga(apiManga)
+			summary.CheckErr(UpsertManga(apiManga))
 		}
 
 	} else {
-		rateLimiter := ratelimit.New(1, ratelimit.Per(2*time.Second))
-
-		readFile, err := os.Open("data/last_metadata_update.txt")
-		internal.CheckErr(err)
-		fileScanner := bufio.NewScanner(readFile)
-
-		fileScanner.Split(bufio.ScanLines)
-
-		var lastUpdatedTime string
-		for fileScanner.Scan() {
-			lastUpdatedTime = fileScanner.Text()
-		}
-
+		lastUpdatedTime, err := readLastTimestamp(lastMetadataUpdateFile)
+		summary.CheckErr(err)
 		fmt.Printf("Getting mangadex metadata since last updated time -> %s\n", lastUpdatedTime)
 
-		readFile.Close()
-
-		currentLimit := int32(100)
-		maxOffset := int32(10000)
-		done := false
-
-		for currentOffset := int32(0); currentOffset < maxOffset && done == false; currentOffset += currentLimit {
-
-			opts := mangadex.MangaApiGetSearchMangaOpts{}
-			opts.UpdatedAtSince = optional.NewString(lastUpdatedTime)
-			opts.Limit = optional.NewInt32(currentLimit)
-			opts.Offset = optional.NewInt32(currentOffset)
-			fmt.Printf("Getting mangadex metadata for offset %d\n", currentOffset)
-
-			mangaList := SearchMangaDex(rateLimiter, client, ctx, opts)
-
-			if len(mangaList.Data) != 0 {
-				for _, apiManga := range mangaList.Data {
-					UpsertManga(apiManga)
-				}
-			} else {
-				done = true
+		opts := mangadex.MangaApiGetSearchMangaOpts{}
+		opts.Limit = optional.NewInt32(100)
+		paginator := mangadex.NewMangaPaginator(client, mangadex.PaginateUpdatedAt, lastUpdatedTime, opts)
+		count := 0
+		for paginator.Next(ctx) {
+			if err := UpsertManga(paginator.Manga()); err != nil {
+				summary.Add("updating manga", err)
+				continue
+			}
+			count++
+			if count%1000 == 0 {
+				fmt.Printf("Updated metadata of %d manga\n", count)
 			}
 		}
+		summary.CheckErr(paginator.Err())
+		fmt.Printf("Updated metadata of %d manga\n", count)
 
 	}
 
-	metadataFile, err := os.OpenFile("data/last_metadata_update.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-	internal.CheckErr(err)
-	_, err = metadataFile.WriteString(strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0])
-	internal.CheckErr(err)
-	metadataFile.Close()
+	// The start time, so manga updated while this ran are picked up by the next run
+	// Skipped manga would be missed by that, so the time is only moved on when there were none
+	if summary.Len() == 0 {
+		summary.CheckErr(writeLastTimestamp(lastMetadataUpdateFile, strings.Split(start.UTC().Format(time.RFC3339), "Z")[0]))
+	} else {
+		fmt.Printf("Not moving %s on, so the skipped manga are tried again next run\n", lastMetadataUpdateFile)
+	}
 
-	ExportManga()
+	summary.Add("exporting manga", ExportManga())
 
 	fmt.Printf("\t- Finished in %s\n", time.Since(start))
+	summary.Print()
 }
 
-func collectAllMangaIds() [][]string {
+// Asks MangaDex for the one manga, updating it if it is still there, true when MangaDex has nothing under the id
+func confirmMangaRemoved(client *mangadex.APIClient, ctx context.Context, uuid string) (bool, error) {
+	opts := mangadex.MangaApiGetSearchMangaOpts{}
+	opts.Limit = optional.NewInt32(1)
+	opts.Ids = optional.NewInterface([]string{uuid})
+	mangaList, err := SearchMangaDex(client, ctx, opts)
+	if err != nil {
+		return false, fmt.Errorf("confirming manga %s was removed: %w", uuid, err)
+	}
+	for _, apiManga := range mangaList.Data {
+		if apiManga.Id == uuid {
+			return false, UpsertManga(apiManga)
+		}
+	}
+	return true, nil
+}
+
+func collectAllMangaIds() ([][]string, error) {
 	var mangaIdArray [][]string
 	processing := true
 	dbOffset := 0
 
 	for processing {
-		rows, _ := internal.DB.Query("SELECT UUID FROM " + internal.TableManga + " ORDER BY UUID LIMIT 100 OFFSET " + strconv.Itoa(dbOffset))
+		rows, err := internal.DB.Query("SELECT UUID FROM " + internal.TableManga + " ORDER BY UUID LIMIT 100 OFFSET " + strconv.Itoa(dbOffset))
+		if err != nil {
+			return nil, fmt.Errorf("reading manga ids: %w", err)
+		}
 		var mangaIds []string
 		for rows.Next() {
 			var uuid string
-			rows.Scan(&uuid)
+			if err := rows.Scan(&uuid); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("reading manga ids: %w", err)
+			}
 			mangaIds = append(mangaIds, uuid)
 		}
+		rows.Close()
 
 		if len(mangaIds) == 0 {
 			processing = false
@@ -150,7 +230,15 @@ func collectAllMangaIds() [][]string {
 
 		mangaIdArray = append(mangaIdArray, mangaIds)
 		dbOffset = dbOffset + 100
-		rows.Close()
 	}
-	return mangaIdArray
+	return mangaIdArray, nil
+}
+
+func getDBMangaTitle(uuid string) (string, error) {
+	var title sql.NullString
+	err := internal.DB.QueryRow("SELECT json_extract(JSON, '$.title.en') FROM "+internal.TableManga+" WHERE UUID = ?", uuid).Scan(&title)
+	if err != nil {
+		return "", fmt.Errorf("reading title of manga %s: %w", uuid, err)
+	}
+	return title.String, nil
 }
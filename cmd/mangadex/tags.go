@@ -0,0 +1,105 @@
+package mangadex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	similar "github.com/similar-manga/similar/cmd/calculate/similar_helpers"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/mangadex"
+	"github.com/spf13/cobra"
+	"go.uber.org/ratelimit"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var tagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "Sync the MangaDex tag catalogue",
+	Long: `
+Query every MangaDex tag with its group (genre, theme, format or content) and store them in the TAG table.
+The similar config can then weight tags by UUID or group, so a renamed tag keeps its weight.
+Tags which are no longer on MangaDex are removed from the table.`,
+	Run: runTags,
+}
+
+func init() {
+	mangadexCmd.AddCommand(tagsCmd)
+}
+
+func runTags(cmd *cobra.Command, args []string) {
+	start := time.Now()
+
+	client := CreateMangaDexClient(ratelimit.New(1))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tagList, resp, err := client.MangaApi.GetMangaTag(ctx)
+	if err != nil && resp != nil {
+		err = fmt.Errorf("tag list failed with http code %d: %w", resp.StatusCode, err)
+	}
+	internal.CheckErr(err)
+	if len(tagList.Data) == 0 {
+		fmt.Printf("\u001B[1;31mMangaDex returned no tags, keeping the stored catalogue\u001B[0m\n")
+		os.Exit(1)
+	}
+
+	// Replace the whole catalogue, so removed tags don't linger
+	oldTags := map[string]internal.DbTag{}
+	dbTags, err := internal.GetAllTags()
+	internal.CheckErr(err)
+	for _, tag := range dbTags {
+		oldTags[tag.Id] = tag
+	}
+	groupCounts, err := replaceTags(tagList.Data, oldTags)
+	internal.CheckErr(err)
+
+	fmt.Printf("Stored %d tags\n", len(tagList.Data))
+	for _, group := range similar.TagGroups {
+		fmt.Printf("  | %-8s %d tags\n", group, groupCounts[group])
+	}
+	fmt.Printf("\t- Finished in %s\n", time.Since(start))
+}
+
+// Stores the tags in place of the old ones, printing what changed, returning the number of tags in each group
+// Nothing is changed if any tag fails
+func replaceTags(tags []mangadex.Tag, oldTags map[string]internal.DbTag) (map[string]int, error) {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM " + internal.TableTag)
+	if err != nil {
+		return nil, fmt.Errorf("clearing tags: %w", err)
+	}
+	groupCounts := map[string]int{}
+	for _, tag := range tags {
+		name := ""
+		if tag.Attributes.Name != nil {
+			name = (*tag.Attributes.Name)["en"]
+		}
+		jsonTag, err := json.Marshal(tag.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("storing tag %s: %w", tag.Id, err)
+		}
+		_, err = tx.Exec("INSERT INTO "+internal.TableTag+" (UUID, NAME, TAG_GROUP, JSON) VALUES (?, ?, ?, ?)", tag.Id, name, tag.Attributes.Group, jsonTag)
+		if err != nil {
+			return nil, fmt.Errorf("storing tag %s: %w", tag.Id, err)
+		}
+		groupCounts[tag.Attributes.Group]++
+
+		if oldTag, ok := oldTags[tag.Id]; !ok {
+			fmt.Printf("  | added %s (%s) %s\n", name, tag.Attributes.Group, tag.Id)
+		} else if similar.TagName(oldTag.Name) != similar.TagName(name) {
+			fmt.Printf("  | renamed %s to %s %s\n", oldTag.Name, name, tag.Id)
+		}
+		delete(oldTags, tag.Id)
+	}
+	for _, oldTag := range oldTags {
+		fmt.Printf("  | removed %s (%s) %s\n", oldTag.Name, oldTag.Group, oldTag.Id)
+	}
+	return groupCounts, tx.Commit()
+}
@@ -0,0 +1,187 @@
+package mappings
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup",
+	Short: "Look up the MangaDex uuids of an external id, or the external ids of a uuid",
+	Long: `
+Look up the MangaDex manga an external site id is mapped to, or a MangaDex uuid, and print every
+site id known for each manga as a json line.
+
+  ./similar mappings lookup --site al --id 30013
+  ./similar mappings lookup --uuid 801513ba-a712-498c-8f57-cae55b38cc92
+  ./similar mappings lookup --site mal --batch ids.txt
+  cat lookups.csv | ./similar mappings lookup --batch -
+
+A batch is read as csv from a file, or stdin for "-", and prints a json line for each record, which is one of
+  <id>          an id of --site
+  <site>,<id>   an id of the site, e.g. mal,2
+  <uuid>        a MangaDex uuid, when --site isn't given
+A header line such as site,id and lines starting with # are skipped. Sites are given by their key, e.g. al, mal or mu.
+Manga tombstoned by mangadex metadata, since they are no longer on MangaDex, are never returned.`,
+	Run: runLookup,
+}
+
+func init() {
+	mappingsCmd.AddCommand(lookupCmd)
+	lookupCmd.Flags().StringP("site", "s", "", "Site of the ids looked up, e.g. al or mal")
+	lookupCmd.Flags().StringP("id", "i", "", "Id of the site to look up")
+	lookupCmd.Flags().StringP("uuid", "u", "", "MangaDex uuid to look up")
+	lookupCmd.Flags().StringP("batch", "b", "", "Csv file of lookups, - reads stdin")
+}
+
+// The lookup and what it found, Manga is empty if nothing is mapped
+type lookupResult struct {
+	Site  string          `json:"site,omitempty"`
+	Id    string          `json:"id,omitempty"`
+	Uuid  string          `json:"uuid,omitempty"`
+	Manga []mangaMappings `json:"manga"`
+	Error string          `json:"error,omitempty"`
+}
+
+type mangaMappings struct {
+	Uuid string `json:"uuid"`
+	// Id of each site the manga is mapped to, keyed by the site key
+	Mappings map[string]string `json:"mappings"`
+}
+
+func runLookup(cmd *cobra.Command, args []string) {
+	siteKey, _ := cmd.Flags().GetString("site")
+	id, _ := cmd.Flags().GetString("id")
+	uuid, _ := cmd.Flags().GetString("uuid")
+	batch, _ := cmd.Flags().GetString("batch")
+
+	given := 0
+	for _, value := range []string{id, uuid, batch} {
+		if value != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		log.Fatal("give exactly one of --id, --uuid or --batch")
+	}
+	if id != "" && siteKey == "" {
+		log.Fatal("--id needs the --site it is an id of")
+	}
+	if siteKey != "" {
+		if _, ok := internal.GetMappingSite(siteKey); !ok {
+			log.Fatalf("unknown site %s, the sites are %s", siteKey, strings.Join(siteKeys(), ", "))
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if batch == "" {
+		result := lookup(siteKey, id, uuid)
+		internal.CheckErr(encoder.Encode(result))
+		if result.Error != "" {
+			log.Fatal(result.Error)
+		}
+		return
+	}
+
+	input := os.Stdin
+	if batch != "-" {
+		file, err := os.Open(batch)
+		internal.CheckErr(err)
+		defer file.Close()
+		input = file
+	}
+	reader := csv.NewReader(input)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	total, resolved := 0, 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		internal.CheckErr(err)
+		if total == 0 && isHeader(record) {
+			continue
+		}
+
+		var result lookupResult
+		switch {
+		case len(record) == 1 && siteKey != "":
+			result = lookup(siteKey, record[0], "")
+		case len(record) == 1:
+			result = lookup("", "", record[0])
+		case len(record) == 2:
+			result = lookup(record[0], record[1], "")
+		default:
+			result = lookupResult{Manga: []mangaMappings{}, Error: fmt.Sprintf("record %q isn't <id>, <site>,<id> or <uuid>", strings.Join(record, ","))}
+		}
+		internal.CheckErr(encoder.Encode(result))
+		total++
+		if len(result.Manga) > 0 {
+			resolved++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Resolved %d of %d lookups\n", resolved, total)
+}
+
+// Looks up the id of the site, or the uuid if siteKey is empty
+// Errors are returned in the result so a batch carries on with the next lookup
+func lookup(siteKey string, id string, uuid string) lookupResult {
+	result := lookupResult{Site: siteKey, Id: strings.TrimSpace(id), Uuid: strings.TrimSpace(uuid), Manga: []mangaMappings{}}
+	mangaIds := []string{result.Uuid}
+	if siteKey != "" {
+		site, ok := internal.GetMappingSite(siteKey)
+		if !ok {
+			result.Error = "unknown site " + siteKey
+			return result
+		}
+		var err error
+		mangaIds, err = site.LookupMangaIds(result.Id)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	for _, mangaId := range mangaIds {
+		mappings, err := internal.GetMangaMappings(mangaId)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if len(mappings) > 0 {
+			result.Manga = append(result.Manga, mangaMappings{Uuid: mangaId, Mappings: mappings})
+		}
+	}
+	return result
+}
+
+func isHeader(record []string) bool {
+	for _, field := range record {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field != "site" && field != "id" && field != "uuid" {
+			return false
+		}
+	}
+	return true
+}
+
+func siteKeys() []string {
+	var keys []string
+	for _, site := range internal.MappingSites {
+		keys = append(keys, site.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,24 @@
+package mappings
+
+import (
+	"github.com/similar-manga/similar/cmd"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var mappingsCmd = &cobra.Command{
+	Use:   "mappings",
+	Short: "mappings command",
+	Long: `
+Actions which read the external site mappings calculated by ./similar calculate mappings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			_ = cmd.Help()
+			os.Exit(0)
+		}
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(mappingsCmd)
+}
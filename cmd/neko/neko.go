@@ -2,12 +2,13 @@ package neko
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/similar-manga/similar/cmd"
 	"github.com/similar-manga/similar/internal"
 	"github.com/spf13/cobra"
-	"io"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -24,108 +25,87 @@ func init() {
 
 func runNeko(command *cobra.Command, args []string) {
 	initialStart := time.Now()
+	summary := internal.NewErrorSummary()
 
-	nekoDb := createNekoMappingDB()
+	nekoDb, err := createNekoMappingDB()
+	summary.CheckErr(err)
 	fmt.Println("Starting neko export")
-	mangaList := internal.GetAllManga()
-	tx, _ := nekoDb.Begin()
+	mangaList, err := internal.GetAllManga()
+	summary.CheckErr(summary.SkipRows("reading manga", err))
+	summary.CheckErr(exportNekoMappings(nekoDb, mangaList, summary))
 
-	for _, manga := range mangaList {
-		nekoEntry := internal.DbNeko{}
-		nekoEntry.UUID = manga.Id
-		generic := getGeneric(internal.TableAnilist, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.ANILIST = generic.ID
-		}
-
-		generic = getGeneric(internal.TableAnimePlanet, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.ANIMEPLANET = generic.ID
-		}
-
-		generic = getGeneric(internal.TableBookWalker, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.BOOKWALKER = generic.ID
-		}
+	fmt.Printf("Finished neko export in %s\n", time.Since(initialStart))
+	summary.Print()
+}
 
-		generic = getGeneric(internal.TableKitsu, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.KITSU = generic.ID
+// Writes the mapping ids of every manga into the neko database, one column for each site of the registry which has one
+// Manga whose ids can't be read or written are skipped and added to summary
+func exportNekoMappings(nekoDb *sql.DB, mangaList []internal.Manga, summary *internal.ErrorSummary) error {
+	tx, err := nekoDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	columns := []string{"mdex"}
+	var sites []internal.MappingSite
+	for _, site := range internal.MappingSites {
+		if site.NekoColumn != "" {
+			columns = append(columns, site.NekoColumn)
+			sites = append(sites, site)
 		}
+	}
+	insert := "INSERT INTO " + internal.TableNekoMappings + " (" + strings.Join(columns, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(sites)) + ")"
 
-		generic = getGeneric(internal.TableMyanimelist, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.MYANIMELIST = generic.ID
+	for _, manga := range mangaList {
+		values := []interface{}{manga.Id}
+		var mangaErr error
+		for _, site := range sites {
+			generic, err := getGeneric(site.Table, manga.Id)
+			if err != nil {
+				mangaErr = err
+				break
+			}
+			values = append(values, generic.ID)
 		}
-
-		generic = getGeneric(internal.TableMangaupdates, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.MANGAUPDATES = generic.ID
+		if mangaErr == nil {
+			_, mangaErr = tx.Exec(insert, values...)
 		}
-
-		generic = getGeneric(internal.TableMangaupdatesNewId, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.MANGAUPDATES_NEW = generic.ID
+		if mangaErr != nil {
+			summary.Add("exporting neko mappings", fmt.Errorf("manga %s: %w", manga.Id, mangaErr))
 		}
-
-		generic = getGeneric(internal.TableNovelUpdates, manga.Id)
-		if generic.UUID != "" {
-			nekoEntry.NOVEL_UPDATES = generic.ID
-		}
-
-		insertNekoEntry(tx, nekoEntry)
 	}
 
-	err := tx.Commit()
-	internal.CheckErr(err)
-	fmt.Printf("Finished neko export in %s\n", time.Since(initialStart))
+	return tx.Commit()
 }
 
-func createNekoMappingDB() *sql.DB {
+// Creates a new neko mapping database named after today, its tables come from the migrations in internal/migrations/neko
+func createNekoMappingDB() (*sql.DB, error) {
 	fmt.Println("Creating neko_mapping.db")
-	src, err := os.Open("data/default_empty_neko_mapping.db")
 	currentTime := time.Now().Format(time.DateOnly)
-	internal.CheckErr(err)
 	dbName := currentTime + "_neko_mapping"
-	defer src.Close()
-	dst, err := os.Create("data/" + dbName + ".db")
-	internal.CheckErr(err)
-	defer dst.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := src.Read(buf)
-		if err != nil && err != io.EOF {
-			internal.CheckErr(err)
-			break
-		}
-
-		if n == 0 {
-			break
-		}
-
-		if _, err := dst.Write(buf[:n]); err != nil {
-			internal.CheckErr(err)
-			break
-		}
+	err := os.Remove("data/" + dbName + ".db")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
-	return internal.ConnectNekoDB(dbName)
+	nekoDb := internal.ConnectNekoDB(dbName)
+	if _, err := internal.Migrate(nekoDb, internal.MigrationsNeko); err != nil {
+		nekoDb.Close()
+		return nil, fmt.Errorf("creating %s: %w", dbName, err)
+	}
+	return nekoDb, nil
 }
 
-func getGeneric(table string, uuid string) internal.DbGeneric {
-	rows, err := internal.DB.Query("SELECT UUID, ID FROM " + table + " WHERE UUID = '" + uuid + "'")
-	defer rows.Close()
-	internal.CheckErr(err)
+// The mapping of the manga in table, empty if it has none
+func getGeneric(table string, uuid string) (internal.DbGeneric, error) {
 	generic := internal.DbGeneric{}
-	if rows.Next() {
-		rows.Scan(&generic.UUID, &generic.ID)
-	} else {
+	err := internal.DB.QueryRow("SELECT UUID, ID FROM "+table+" WHERE UUID = ?", uuid).Scan(&generic.UUID, &generic.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return internal.DbGeneric{}, nil
 	}
-	return generic
-}
-
-func insertNekoEntry(tx *sql.Tx, nekoEntry internal.DbNeko) {
-	_, err := tx.Exec("INSERT INTO "+internal.TableNekoMappings+" (mdex, al, ap, bw, mu, mu_new, nu, kt , mal) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", nekoEntry.UUID, nekoEntry.ANILIST, nekoEntry.ANIMEPLANET, nekoEntry.BOOKWALKER, nekoEntry.MANGAUPDATES, nekoEntry.MANGAUPDATES_NEW, nekoEntry.NOVEL_UPDATES, nekoEntry.KITSU, nekoEntry.MYANIMELIST)
-	internal.CheckErr(err)
+	if err != nil {
+		return generic, fmt.Errorf("reading %s id of %s: %w", table, uuid, err)
+	}
+	return generic, nil
 }
@@ -0,0 +1,24 @@
+package pipeline
+
+import (
+	"github.com/similar-manga/similar/cmd"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "pipeline command",
+	Long: `
+Actions which run several of the other commands as a single refresh.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			_ = cmd.Help()
+			os.Exit(0)
+		}
+	},
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(pipelineCmd)
+}
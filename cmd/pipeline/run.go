@@ -0,0 +1,394 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the full refresh from init to the neko export",
+	Long: `
+Run init, mangadex add, mangadex metadata, mangadex tags, calculate mappings, calculate similar and neko in dependency order.
+Each stage runs as its own process, if it fails data.db, the last_* timestamps and the similar model are restored
+to how they were before the stage, stages which depend on it are not run, and the run report records where to resume from.
+The exports in data/manga/, data/mappings/, data/similar/ and the neko database are not backed up,
+a failed stage may leave them half written until it is rerun.`,
+	Run: runPipeline,
+}
+
+func init() {
+	pipelineCmd.AddCommand(runCmd)
+	runCmd.Flags().StringSliceP("skip", "s", []string{}, "Stages to skip, e.g. --skip init,neko")
+	runCmd.Flags().StringP("from", "f", "", "Skip every stage before this one")
+	runCmd.Flags().BoolP("resume", "r", false, "Skip the stages which succeeded in the last run report")
+	runCmd.Flags().BoolP("incremental", "i", false, "Run calculate similar incrementally")
+	runCmd.Flags().Bool("no-backup", false, "Don't back up the database and timestamps before each stage, a failed stage may leave them half updated")
+	runCmd.Flags().String("report", "data/pipeline_report.json", "File the json run report is written to")
+}
+
+const databaseFile = "data/data.db"
+const backupSuffix = ".pipeline-backup"
+
+// Backed up before each stage and restored if it fails, the timestamps go with the database so a rerun
+// picks up from the same place
+var backedUpFiles = []string{
+	databaseFile,
+	"data/last_manga_add.txt",
+	"data/last_metadata_update.txt",
+	"data/last_similar_update.txt",
+	"data/similar_model.json",
+}
+
+// Exports which are rewritten from the database when their stage reruns, a failed stage may leave them half written
+var notBackedUpFiles = []string{"data/manga/", "data/mappings/", "data/similar/", "data/<date>_neko_mapping.db"}
+
+type pipelineStage struct {
+	Name       string
+	Args       []string
+	DependsOn  []string
+	ModifiesDB bool
+}
+
+// Listed in the order they are run when there is no dependency between them
+var pipelineStages = []pipelineStage{
+	{Name: "init", Args: []string{"init"}, ModifiesDB: true},
+	{Name: "add", Args: []string{"mangadex", "add"}, DependsOn: []string{"init"}, ModifiesDB: true},
+	{Name: "metadata", Args: []string{"mangadex", "metadata"}, DependsOn: []string{"add"}, ModifiesDB: true},
+	{Name: "tags", Args: []string{"mangadex", "tags"}, DependsOn: []string{"init"}, ModifiesDB: true},
+	{Name: "mappings", Args: []string{"calculate", "mappings"}, DependsOn: []string{"metadata"}, ModifiesDB: true},
+	{Name: "similar", Args: []string{"calculate", "similar"}, DependsOn: []string{"metadata", "tags"}, ModifiesDB: true},
+	{Name: "neko", Args: []string{"neko"}, DependsOn: []string{"mappings"}},
+}
+
+const (
+	stageOk      = "ok"
+	stageFailed  = "failed"
+	stageSkipped = "skipped"
+	stageBlocked = "blocked"
+	stageResumed = "resumed"
+)
+
+type pipelineReport struct {
+	StartedAt   string        `json:"startedAt"`
+	FinishedAt  string        `json:"finishedAt,omitempty"`
+	Success     bool          `json:"success"`
+	BackedUp    []string      `json:"backedUp"`
+	NotBackedUp []string      `json:"notBackedUp"`
+	Stages      []stageReport `json:"stages"`
+}
+
+type stageReport struct {
+	Name            string  `json:"name"`
+	Command         string  `json:"command"`
+	Status          string  `json:"status"`
+	StartedAt       string  `json:"startedAt,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+func runPipeline(cmd *cobra.Command, args []string) {
+	skip, _ := cmd.Flags().GetStringSlice("skip")
+	from, _ := cmd.Flags().GetString("from")
+	resume, _ := cmd.Flags().GetBool("resume")
+	incremental, _ := cmd.Flags().GetBool("incremental")
+	noBackup, _ := cmd.Flags().GetBool("no-backup")
+	reportFile, _ := cmd.Flags().GetString("report")
+
+	stages, err := orderStages(pipelineStages)
+	internal.CheckErr(err)
+
+	var lastReport *pipelineReport
+	if resume {
+		report, err := loadPipelineReport(reportFile)
+		if err != nil {
+			log.Fatalf("can't resume without the last run report: %v", err)
+		}
+		lastReport = &report
+	}
+	selection, err := selectStages(stages, skip, from, lastReport)
+	internal.CheckErr(err)
+
+	// init recreates data.db with the latest schema, without it the stages need data.db to be up-to-date already
+	if selection.Skipped["init"] || selection.Resumed["init"] {
+		internal.CheckErr(internal.CheckSchema())
+	}
+
+	executable, err := os.Executable()
+	internal.CheckErr(err)
+
+	startProcessing := time.Now()
+	report := pipelineReport{StartedAt: startProcessing.UTC().Format(time.RFC3339), Success: true, NotBackedUp: notBackedUpFiles}
+	if !noBackup {
+		report.BackedUp = backedUpFiles
+	}
+	failed := map[string]bool{}
+	for _, stage := range stages {
+		stageArgs := append([]string(nil), stage.Args...)
+		if stage.Name == "similar" && incremental {
+			stageArgs = append(stageArgs, "--incremental")
+		}
+		// Stages run offline too when the pipeline is
+		for _, flag := range []string{"http-replay", "http-stub"} {
+			if value, _ := cmd.Flags().GetString(flag); value != "" {
+				stageArgs = append(stageArgs, "--"+flag, value)
+			}
+		}
+		result := stageReport{Name: stage.Name, Command: strings.Join(stageArgs, " ")}
+
+		status, blockedBy := selection.status(stage, failed)
+		if status == stageBlocked {
+			failed[stage.Name] = true
+			result.Status = stageBlocked
+			result.Error = "depends on failed stage " + blockedBy
+			fmt.Printf("\u001B[1;33mStage %s blocked by %s\u001B[0m\n\n", stage.Name, blockedBy)
+		} else if status == stageResumed {
+			result.Status = stageResumed
+			fmt.Printf("Skipping stage %s, it succeeded in the last run\n\n", stage.Name)
+		} else if status == stageSkipped {
+			result.Status = stageSkipped
+			fmt.Printf("Skipping stage %s\n\n", stage.Name)
+		} else {
+			fmt.Printf("\u001B[1;36m==> Stage %s: %s\u001B[0m\n", stage.Name, result.Command)
+			start := time.Now()
+			result.StartedAt = start.UTC().Format(time.RFC3339)
+			err := runStage(executable, stage, stageArgs, !noBackup)
+			result.DurationSeconds = time.Since(start).Seconds()
+			if err != nil {
+				failed[stage.Name] = true
+				result.Status = stageFailed
+				result.Error = err.Error()
+				fmt.Printf("\u001B[1;31mStage %s failed after %s: %v\u001B[0m\n\n", stage.Name, time.Since(start), err)
+			} else {
+				result.Status = stageOk
+				fmt.Printf("Stage %s finished in %s\n\n", stage.Name, time.Since(start))
+			}
+		}
+		if failed[stage.Name] {
+			report.Success = false
+		}
+
+		// Written after every stage so the report survives the pipeline being killed
+		report.Stages = append(report.Stages, result)
+		writePipelineReport(reportFile, report)
+	}
+	report.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	writePipelineReport(reportFile, report)
+
+	fmt.Printf("Pipeline finished in %s\n", time.Since(startProcessing))
+	for _, stage := range report.Stages {
+		fmt.Printf("  | %-10s %-8s %10.1fs %s\n", stage.Name, stage.Status, stage.DurationSeconds, stage.Error)
+	}
+	if !report.Success {
+		log.Fatalf("\u001B[1;31mPipeline failed, fix the failed stage and rerun with --resume\u001B[0m\n")
+	}
+}
+
+// The stages which the flags skip, and the ones which succeeded in the last run report when resuming
+type stageSelection struct {
+	Skipped map[string]bool
+	Resumed map[string]bool
+}
+
+// Works out which stages --skip, --from and --resume leave out, lastReport is nil when not resuming
+func selectStages(stages []pipelineStage, skip []string, from string, lastReport *pipelineReport) (stageSelection, error) {
+	selection := stageSelection{Skipped: map[string]bool{}, Resumed: map[string]bool{}}
+	for _, name := range skip {
+		if !hasStage(stages, name) {
+			return selection, fmt.Errorf("unknown stage %s", name)
+		}
+		selection.Skipped[name] = true
+	}
+	if from != "" {
+		if !hasStage(stages, from) {
+			return selection, fmt.Errorf("unknown stage %s", from)
+		}
+		for _, stage := range stages {
+			if stage.Name == from {
+				break
+			}
+			selection.Skipped[stage.Name] = true
+		}
+	}
+	if lastReport != nil {
+		for _, stage := range lastReport.Stages {
+			if stage.Status == stageOk || stage.Status == stageResumed {
+				selection.Resumed[stage.Name] = true
+			}
+		}
+	}
+	return selection, nil
+}
+
+// The status of a stage which isn't run, empty when it has to run, and the failed dependency blocking it
+// Stages are blocked when something they need failed, skipped ones are trusted to be up-to-date
+func (selection stageSelection) status(stage pipelineStage, failed map[string]bool) (string, string) {
+	for _, dependency := range stage.DependsOn {
+		if failed[dependency] {
+			return stageBlocked, dependency
+		}
+	}
+	if selection.Resumed[stage.Name] {
+		return stageResumed, ""
+	}
+	if selection.Skipped[stage.Name] {
+		return stageSkipped, ""
+	}
+	return "", ""
+}
+
+// Runs the stage as a child process, restoring the backed up files if it fails
+func runStage(executable string, stage pipelineStage, stageArgs []string, backup bool) error {
+	var backedUp []string
+	if backup && stage.ModifiesDB {
+		var err error
+		backedUp, err = backupFiles(backedUpFiles)
+		if err != nil {
+			return fmt.Errorf("failed to back up: %w", err)
+		}
+	}
+
+	process := exec.Command(executable, stageArgs...)
+	process.Stdin = os.Stdin
+	process.Stdout = os.Stdout
+	process.Stderr = os.Stderr
+	err := process.Run()
+
+	if backup && stage.ModifiesDB {
+		if err != nil {
+			// A stale journal would be rolled back into the restored database, so it is removed too
+			_ = os.Remove(databaseFile + "-journal")
+			if restoreErr := restoreFiles(backedUpFiles, backedUp); restoreErr != nil {
+				return fmt.Errorf("%v, and failed to restore from the backup: %v", err, restoreErr)
+			}
+			fmt.Printf("Restored %s to before stage %s\n", strings.Join(backedUpFiles, ", "), stage.Name)
+		} else {
+			for _, fileName := range backedUp {
+				_ = os.Remove(fileName + backupSuffix)
+			}
+		}
+	}
+	return err
+}
+
+// Copies each file which exists to its backup file, returning the files which were backed up
+func backupFiles(fileNames []string) ([]string, error) {
+	var backedUp []string
+	for _, fileName := range fileNames {
+		err := copyFile(fileName, fileName+backupSuffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		backedUp = append(backedUp, fileName)
+	}
+	return backedUp, nil
+}
+
+// Moves the backups back, files which didn't exist before are removed again
+func restoreFiles(fileNames []string, backedUp []string) error {
+	for _, fileName := range fileNames {
+		var err error
+		if contains(backedUp, fileName) {
+			err = os.Rename(fileName+backupSuffix, fileName)
+		} else if err = os.Remove(fileName); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Orders the stages so each runs after the stages it depends on, otherwise keeping their listed order
+func orderStages(stages []pipelineStage) ([]pipelineStage, error) {
+	var ordered []pipelineStage
+	done := map[string]bool{}
+	for len(ordered) < len(stages) {
+		progress := false
+		for _, stage := range stages {
+			if done[stage.Name] {
+				continue
+			}
+			ready := true
+			for _, dependency := range stage.DependsOn {
+				if !done[dependency] {
+					ready = false
+				}
+			}
+			if ready {
+				ordered = append(ordered, stage)
+				done[stage.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("pipeline stages have a missing or circular dependency")
+		}
+	}
+	return ordered, nil
+}
+
+func hasStage(stages []pipelineStage, name string) bool {
+	for _, stage := range stages {
+		if stage.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func copyFile(srcFile string, dstFile string) error {
+	src, err := os.Open(srcFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func loadPipelineReport(fileName string) (pipelineReport, error) {
+	report := pipelineReport{}
+	jsonReport, err := os.ReadFile(fileName)
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(jsonReport, &report)
+	return report, err
+}
+
+func writePipelineReport(fileName string, report pipelineReport) {
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	internal.CheckErr(err)
+	err = os.WriteFile(fileName, jsonReport, 0777)
+	internal.CheckErr(err)
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Walks the stages as runPipeline does, with the stages in fails failing when they are run
+func simulateRun(t *testing.T, skip []string, from string, lastReport *pipelineReport, fails map[string]bool) string {
+	t.Helper()
+	stages, err := orderStages(pipelineStages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selection, err := selectStages(stages, skip, from, lastReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]bool{}
+	var statuses []string
+	for _, stage := range stages {
+		status, _ := selection.status(stage, failed)
+		if status == "" {
+			status = stageOk
+			if fails[stage.Name] {
+				status = stageFailed
+			}
+		}
+		if status == stageBlocked || status == stageFailed {
+			failed[stage.Name] = true
+		}
+		statuses = append(statuses, stage.Name+":"+status)
+	}
+	return strings.Join(statuses, " ")
+}
+
+func TestStageSelection(t *testing.T) {
+	failedMetadata := &pipelineReport{Stages: []stageReport{
+		{Name: "init", Status: stageOk},
+		{Name: "add", Status: stageResumed},
+		{Name: "metadata", Status: stageFailed},
+		{Name: "tags", Status: stageOk},
+		{Name: "mappings", Status: stageBlocked},
+		{Name: "similar", Status: stageBlocked},
+		{Name: "neko", Status: stageBlocked},
+	}}
+	tests := []struct {
+		name       string
+		skip       []string
+		from       string
+		lastReport *pipelineReport
+		fails      map[string]bool
+		statuses   string
+	}{
+		{
+			name:     "everything runs",
+			statuses: "init:ok add:ok metadata:ok tags:ok mappings:ok similar:ok neko:ok",
+		},
+		{
+			name:     "skip",
+			skip:     []string{"init", "neko"},
+			statuses: "init:skipped add:ok metadata:ok tags:ok mappings:ok similar:ok neko:skipped",
+		},
+		{
+			name:     "from",
+			from:     "tags",
+			statuses: "init:skipped add:skipped metadata:skipped tags:ok mappings:ok similar:ok neko:ok",
+		},
+		{
+			name:     "from and skip",
+			from:     "mappings",
+			skip:     []string{"similar"},
+			statuses: "init:skipped add:skipped metadata:skipped tags:skipped mappings:ok similar:skipped neko:ok",
+		},
+		{
+			name:     "failed stage blocks its dependents",
+			fails:    map[string]bool{"metadata": true},
+			statuses: "init:ok add:ok metadata:failed tags:ok mappings:blocked similar:blocked neko:blocked",
+		},
+		{
+			name:     "blocked dependents block theirs, a skipped dependent doesn't fail",
+			skip:     []string{"similar"},
+			fails:    map[string]bool{"add": true},
+			statuses: "init:ok add:failed metadata:blocked tags:ok mappings:blocked similar:blocked neko:blocked",
+		},
+		{
+			name:       "resume after a failure",
+			lastReport: failedMetadata,
+			statuses:   "init:resumed add:resumed metadata:ok tags:resumed mappings:ok similar:ok neko:ok",
+		},
+		{
+			name:       "resume failing again",
+			lastReport: failedMetadata,
+			fails:      map[string]bool{"metadata": true},
+			statuses:   "init:resumed add:resumed metadata:failed tags:resumed mappings:blocked similar:blocked neko:blocked",
+		},
+	}
+	for _, test := range tests {
+		if got := simulateRun(t, test.skip, test.from, test.lastReport, test.fails); got != test.statuses {
+			t.Errorf("%s:\n got %s\nwant %s", test.name, got, test.statuses)
+		}
+	}
+}
+
+func TestStageSelectionRejectsUnknownStages(t *testing.T) {
+	if _, err := selectStages(pipelineStages, []string{"nope"}, "", nil); err == nil {
+		t.Error("skipping an unknown stage gave no error")
+	}
+	if _, err := selectStages(pipelineStages, nil, "nope", nil); err == nil {
+		t.Error("starting from an unknown stage gave no error")
+	}
+}
+
+func TestOrderStages(t *testing.T) {
+	stages := []pipelineStage{
+		{Name: "c", DependsOn: []string{"b"}},
+		{Name: "a"},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "d"},
+	}
+	ordered, err := orderStages(stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stage := range ordered {
+		names = append(names, stage.Name)
+	}
+	if got := strings.Join(names, ","); got != "a,b,d,c" {
+		t.Errorf("got order %s, want a,b,d,c", got)
+	}
+
+	circular := []pipelineStage{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}
+	if _, err := orderStages(circular); err == nil {
+		t.Error("circular dependency gave no error")
+	}
+	if _, err := orderStages([]pipelineStage{{Name: "a", DependsOn: []string{"missing"}}}); err == nil {
+		t.Error("missing dependency gave no error")
+	}
+}
+
+func TestRestoreFilesUndoesAFailedStage(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "last_manga_add.txt")
+	created := filepath.Join(dir, "similar_model.json")
+	if err := os.WriteFile(existing, []byte("before"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileNames := []string{existing, created}
+
+	backedUp, err := backupFiles(fileNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backedUp) != 1 || backedUp[0] != existing {
+		t.Fatalf("backed up %v, want only %s", backedUp, existing)
+	}
+
+	// The stage changes the existing file and creates the other
+	_ = os.WriteFile(existing, []byte("after"), 0644)
+	_ = os.WriteFile(created, []byte("{}"), 0644)
+	if err := restoreFiles(fileNames, backedUp); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := os.ReadFile(existing); err != nil || string(contents) != "before" {
+		t.Errorf("restored %q %v, want before", contents, err)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("file created by the stage still exists: %v", err)
+	}
+	if _, err := os.Stat(existing + backupSuffix); !os.IsNotExist(err) {
+		t.Errorf("backup left behind: %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/internal/fixtures"
 	"github.com/spf13/cobra"
+	"log"
+	"net/url"
 	"os"
 )
 
@@ -16,15 +19,43 @@ var RootCmd = &cobra.Command{
  1. ./similar init
  2. ./similar mangadex add
  3. ./similar mangadex metadata
+ 3. ./similar mangadex tags
  3. ./similar calculate mappings
  4. ./similar calculate similar
  
  After all the files you can generate the neko mapping db using
   ./similar neko
 
+ All of the above can also be run in order as a single refresh, see ./similar pipeline run --help
+
+ The similar results, mappings and manga can also be served over http using
+  ./similar serve
+
+ A single external id or uuid, or a batch of them, can be looked up in the mappings using
+  ./similar mappings lookup --site al --id 30013
+
+ A MyAnimeList or AniList reading list export can be resolved to MangaDex uuids using
+  ./similar import list <file>
+
+ The MangaDex, MangaUpdates and Kitsu requests of any command can be recorded with --http-record, then run
+ offline from the recording with --http-replay, or from hand written stub data with --http-stub.
+
+ The database tables are created by the sql migrations in internal/migrations, after pulling a version
+ with new migrations upgrade an existing database, keeping its data, using
+  ./similar init --migrate-only
+
  If you are running again after a while make sure you pull the latest from git, then rerun from scratch as the manga mappings and 
  manga update mappings are updated frequently.
 `,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		checkSchema(cmd)
+		configureHTTP(cmd, args)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if recorder != nil {
+			internal.CheckErr(recorder.Close())
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			_ = cmd.Help()
@@ -42,4 +73,53 @@ func Execute() {
 
 func init() {
 	internal.ConnectDB()
+	RootCmd.PersistentFlags().String("http-record", "", "Record every MangaDex, MangaUpdates and Kitsu request and response into this cassette file")
+	RootCmd.PersistentFlags().String("http-replay", "", "Answer MangaDex, MangaUpdates and Kitsu requests from this cassette file instead of the network")
+	RootCmd.PersistentFlags().String("http-stub", "", "Answer MangaDex, MangaUpdates and Kitsu requests from a local stub server serving this stub data file")
+}
+
+// Refuses to run against a data.db which is missing migrations, init creates or upgrades it so is left to run
+// pipeline run starts with init unless it is skipped, so it checks the schema itself when it is
+func checkSchema(cmd *cobra.Command) {
+	if cmd.Name() == "init" || cmd.Name() == "help" {
+		return
+	}
+	if cmd.Name() == "run" && cmd.HasParent() && cmd.Parent().Name() == "pipeline" {
+		return
+	}
+	internal.CheckErr(internal.CheckSchema())
+}
+
+// Set by --http-record, closed once the command finishes
+var recorder *fixtures.Recorder
+
+// Swaps the transport of the external services for a recorder, replayer or stub server
+func configureHTTP(cmd *cobra.Command, args []string) {
+	record, _ := cmd.Flags().GetString("http-record")
+	replay, _ := cmd.Flags().GetString("http-replay")
+	stub, _ := cmd.Flags().GetString("http-stub")
+
+	if replay != "" && (record != "" || stub != "") {
+		log.Fatal("--http-replay can't be used with --http-record or --http-stub")
+	}
+	if replay != "" {
+		cassette, err := fixtures.LoadCassette(replay)
+		internal.CheckErr(err)
+		internal.Transport = fixtures.NewReplayer(cassette)
+		internal.Offline = true
+	}
+	if stub != "" {
+		data, err := fixtures.LoadStubData(stub)
+		internal.CheckErr(err)
+		server := fixtures.NewStubServer(data)
+		target, err := url.Parse(server.URL)
+		internal.CheckErr(err)
+		internal.Transport = &fixtures.RedirectTransport{Base: internal.Transport, Target: target}
+		internal.Offline = true
+	}
+	// Recording the stub server is a way to write cassettes without network access
+	if record != "" {
+		recorder = fixtures.NewRecorder(internal.Transport, record)
+		internal.Transport = recorder
+	}
 }
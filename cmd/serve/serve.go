@@ -0,0 +1,263 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/spf13/cobra"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the similar results, mappings and manga over http",
+	Long: `
+Serve the SIMILAR, MANGA and mapping tables as json over http.
+
+  GET /similar/{uuid}       similar matches of a manga
+  GET /manga/{uuid}         stored MangaDex metadata of a manga
+  GET /mapping/{site}/{id}  MangaDex uuids of an external site id, e.g. /mapping/al/30013
+
+The similar and manga endpoints take comma separated "lang" and "contentRating" query filters,
+e.g. /similar/{uuid}?lang=en,fr&contentRating=safe,suggestive`,
+	Run: runServe,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringP("address", "a", ":8080", "Address to listen on")
+}
+
+type mappingResponse struct {
+	Site     string   `json:"site"`
+	Id       string   `json:"id"`
+	Url      string   `json:"url"`
+	MangaIds []string `json:"mangaIds"`
+}
+
+func runServe(command *cobra.Command, args []string) {
+	address, _ := command.Flags().GetString("address")
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           newServeMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Serving on %s\n", address)
+	log.Fatal(server.ListenAndServe())
+}
+
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/similar/", handleSimilar)
+	mux.HandleFunc("/manga/", handleManga)
+	mux.HandleFunc("/mapping/", handleMapping)
+	return mux
+}
+
+func handleSimilar(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := pathParams(w, r, "/similar/", 1)
+	if !ok {
+		return
+	}
+	filter := newQueryFilter(r)
+
+	similarManga := internal.SimilarManga{}
+	if !getJsonRow(w, internal.TableSimilar, uuid[0], &similarManga) {
+		return
+	}
+	var matches []internal.SimilarMatch
+	for _, match := range similarManga.SimilarMatches {
+		if filter.allows(match.ContentRating, match.Languages) {
+			matches = append(matches, match)
+		}
+	}
+	similarManga.SimilarMatches = matches
+	var relatedMatches []internal.SimilarMatch
+	for _, match := range similarManga.RelatedMatches {
+		if filter.allows(match.ContentRating, match.Languages) {
+			relatedMatches = append(relatedMatches, match)
+		}
+	}
+	similarManga.RelatedMatches = relatedMatches
+	writeJson(w, r, similarManga, similarManga.UpdatedAt, filter)
+}
+
+func handleManga(w http.ResponseWriter, r *http.Request) {
+	uuid, ok := pathParams(w, r, "/manga/", 1)
+	if !ok {
+		return
+	}
+	filter := newQueryFilter(r)
+
+	manga := internal.Manga{}
+	if !getJsonRow(w, internal.TableManga, uuid[0], &manga) {
+		return
+	}
+	if manga.TombstonedAt != "" {
+		http.Error(w, uuid[0]+" is no longer on MangaDex", http.StatusGone)
+		return
+	}
+	if !filter.allows(manga.ContentRating, manga.AvailableTranslatedLanguages) {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, r, manga, manga.UpdatedAt, filter)
+}
+
+func handleMapping(w http.ResponseWriter, r *http.Request) {
+	params, ok := pathParams(w, r, "/mapping/", 2)
+	if !ok {
+		return
+	}
+	site, ok := internal.GetMappingSite(params[0])
+	if !ok {
+		http.Error(w, "unknown site "+params[0], http.StatusNotFound)
+		return
+	}
+
+	// Stored ids are normalised, so e.g. an upper-case slug finds the same manga
+	mangaIds, err := site.LookupMangaIds(params[1])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	id := site.NormaliseId(params[1])
+	response := mappingResponse{Site: params[0], Id: id, Url: site.URL(id), MangaIds: mangaIds}
+	if len(response.MangaIds) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, r, response, "", queryFilter{})
+}
+
+// Splits the path after prefix into exactly count non-empty parts, writing the error response if it can't
+// The last part is the rest of the path, so ids such as BookWalker's series/12345 can have slashes
+func pathParams(w http.ResponseWriter, r *http.Request, prefix string, count int) ([]string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	params := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", count)
+	if len(params) != count {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	for _, param := range params {
+		if param == "" {
+			http.NotFound(w, r)
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Reads the JSON column of the row with the uuid into value, writing the error response if it can't
+func getJsonRow(w http.ResponseWriter, table string, uuid string, value interface{}) bool {
+	var jsonRow []byte
+	err := internal.DB.QueryRow("SELECT JSON FROM "+table+" WHERE UUID = ?", uuid).Scan(&jsonRow)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no entry for "+uuid, http.StatusNotFound)
+		return false
+	}
+	if err == nil {
+		err = json.Unmarshal(jsonRow, value)
+	}
+	if err != nil {
+		serverError(w, err)
+		return false
+	}
+	return true
+}
+
+// Writes the value as json with caching headers, so clients can make conditional requests
+// With updatedAt the ETag is derived from it and it is the Last-Modified, otherwise the ETag is a hash of the body
+// and there is no Last-Modified, e.g. a mapping has no update time of its own
+func writeJson(w http.ResponseWriter, r *http.Request, value interface{}, updatedAt string, filter queryFilter) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	modTime := time.Time{}
+	sum := sha256.Sum256(body)
+	if updated, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+		modTime = updated
+		sum = sha256.Sum256([]byte(updatedAt + "|" + filter.key()))
+	}
+	w.Header().Set("ETag", "\""+hex.EncodeToString(sum[:])[:16]+"\"")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	log.Printf("ERROR: %v\n", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// Languages and content ratings requested with the "lang" and "contentRating" query parameters
+// An empty set allows everything
+type queryFilter struct {
+	languages      []string
+	contentRatings []string
+}
+
+func newQueryFilter(r *http.Request) queryFilter {
+	return queryFilter{
+		languages:      splitQueryList(r.URL.Query().Get("lang")),
+		contentRatings: splitQueryList(r.URL.Query().Get("contentRating")),
+	}
+}
+
+func splitQueryList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (f queryFilter) allows(contentRating string, languages []string) bool {
+	if len(f.contentRatings) > 0 && !contains(f.contentRatings, contentRating) {
+		return false
+	}
+	if len(f.languages) == 0 {
+		return true
+	}
+	for _, language := range languages {
+		if contains(f.languages, language) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies the filter in the ETag, since filtered responses differ from unfiltered ones
+func (f queryFilter) key() string {
+	return strings.Join(f.languages, ",") + "|" + strings.Join(f.contentRatings, ",")
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package serve
+
+import (
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/similar-manga/similar/internal"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	mangaSafe       = "00000000-0000-0000-0000-000000000001"
+	mangaTombstoned = "00000000-0000-0000-0000-000000000002"
+	mangaErotica    = "00000000-0000-0000-0000-000000000003"
+)
+
+// Serves from a freshly migrated data.db of its own, with three manga, the similar matches of the first and mappings
+func setupServeDB(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.Migrate(db, internal.MigrationsData); err != nil {
+		t.Fatal(err)
+	}
+	previous := internal.DB
+	internal.DB = db
+	t.Cleanup(func() {
+		internal.DB = previous
+		db.Close()
+	})
+
+	mangaList := []internal.Manga{
+		{Id: mangaSafe, ContentRating: "safe", AvailableTranslatedLanguages: []string{"en"}, UpdatedAt: "2023-01-02T03:04:05+00:00"},
+		{Id: mangaTombstoned, ContentRating: "safe", UpdatedAt: "2023-01-02T03:04:05+00:00", TombstonedAt: "2023-02-01T00:00:00"},
+		{Id: mangaErotica, ContentRating: "erotica", AvailableTranslatedLanguages: []string{"fr"}, UpdatedAt: "2023-01-02T03:04:05+00:00"},
+	}
+	for _, manga := range mangaList {
+		insertJson(t, "INSERT INTO "+internal.TableManga+" (UUID, DATE, JSON) VALUES (?, '2023-01-01', ?)", manga.Id, manga)
+	}
+	similarManga := internal.SimilarManga{
+		Id:        mangaSafe,
+		UpdatedAt: "2023-03-04T05:06:07Z",
+		SimilarMatches: []internal.SimilarMatch{
+			{Id: "match-en", ContentRating: "safe", Languages: []string{"en"}},
+			{Id: "match-fr", ContentRating: "suggestive", Languages: []string{"fr", "de"}},
+		},
+		RelatedMatches: []internal.SimilarMatch{
+			{Id: "related-ja", ContentRating: "safe", Languages: []string{"ja"}, Relation: "sequel"},
+		},
+	}
+	insertJson(t, "INSERT INTO "+internal.TableSimilar+" (UUID, JSON) VALUES (?, ?)", mangaSafe, similarManga)
+
+	statements := []string{
+		"INSERT INTO " + internal.TableAnilist + " (UUID, ID, RAW) VALUES ('" + mangaSafe + "', '30013', '30013')",
+		"INSERT INTO " + internal.TableAnilist + " (UUID, ID, RAW) VALUES ('" + mangaTombstoned + "', '30013', '30013')",
+		"INSERT INTO " + internal.TableBookWalker + " (UUID, ID, RAW) VALUES ('" + mangaSafe + "', 'series/12345', 'series/12345')",
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func insertJson(t *testing.T, query string, uuid string, value interface{}) {
+	t.Helper()
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.DB.Exec(query, uuid, string(jsonValue)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serveRequest(method string, path string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	newServeMux().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServeStatusCodes(t *testing.T) {
+	setupServeDB(t)
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/manga/" + mangaSafe, http.StatusOK},
+		{"HEAD", "/manga/" + mangaSafe, http.StatusOK},
+		{"GET", "/manga/" + mangaTombstoned, http.StatusGone},
+		{"GET", "/manga/unknown", http.StatusNotFound},
+		{"GET", "/manga/", http.StatusNotFound},
+		{"POST", "/manga/" + mangaSafe, http.StatusMethodNotAllowed},
+		{"GET", "/similar/" + mangaSafe, http.StatusOK},
+		{"GET", "/similar/" + mangaErotica, http.StatusNotFound},
+		{"GET", "/mapping/al/30013", http.StatusOK},
+		{"GET", "/mapping/al/99999", http.StatusNotFound},
+		{"GET", "/mapping/xx/30013", http.StatusNotFound},
+		{"GET", "/mapping/al", http.StatusNotFound},
+		{"GET", "/mapping/al/", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if got := serveRequest(test.method, test.path, nil).Code; got != test.status {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.path, got, test.status)
+		}
+	}
+}
+
+func TestServeMappingIds(t *testing.T) {
+	setupServeDB(t)
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{"/mapping/al/30013", "30013"},
+		{"/mapping/bw/series/12345", "series/12345"},
+		{"/mapping/bw/SERIES/12345/", "series/12345"},
+	}
+	for _, test := range tests {
+		recorder := serveRequest("GET", test.path, nil)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: got %d", test.path, recorder.Code)
+			continue
+		}
+		response := mappingResponse{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		// The tombstoned manga also mapped to AniList 30013 is left out
+		if response.Id != test.id || len(response.MangaIds) != 1 || response.MangaIds[0] != mangaSafe {
+			t.Errorf("%s: got %+v, want id %s of only %s", test.path, response, test.id, mangaSafe)
+		}
+	}
+}
+
+func TestServeFilters(t *testing.T) {
+	setupServeDB(t)
+	tests := []struct {
+		query   string
+		matches []string
+		related []string
+	}{
+		{"", []string{"match-en", "match-fr"}, []string{"related-ja"}},
+		{"?lang=fr", []string{"match-fr"}, nil},
+		{"?lang=de,ja", []string{"match-fr"}, []string{"related-ja"}},
+		{"?contentRating=safe", []string{"match-en"}, []string{"related-ja"}},
+		{"?lang=en&contentRating=suggestive", nil, nil},
+	}
+	for _, test := range tests {
+		recorder := serveRequest("GET", "/similar/"+mangaSafe+test.query, nil)
+		similarManga := internal.SimilarManga{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &similarManga); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if got := matchIds(similarManga.SimilarMatches); !equalIds(got, test.matches) {
+			t.Errorf("%q: got matches %v, want %v", test.query, got, test.matches)
+		}
+		if got := matchIds(similarManga.RelatedMatches); !equalIds(got, test.related) {
+			t.Errorf("%q: got related matches %v, want %v", test.query, got, test.related)
+		}
+	}
+
+	if got := serveRequest("GET", "/manga/"+mangaErotica+"?contentRating=safe,suggestive", nil).Code; got != http.StatusNotFound {
+		t.Errorf("filtered out manga: got %d, want %d", got, http.StatusNotFound)
+	}
+	if got := serveRequest("GET", "/manga/"+mangaErotica+"?lang=fr&contentRating=erotica", nil).Code; got != http.StatusOK {
+		t.Errorf("manga allowed by the filter: got %d, want %d", got, http.StatusOK)
+	}
+}
+
+func matchIds(matches []internal.SimilarMatch) []string {
+	var ids []string
+	for _, match := range matches {
+		ids = append(ids, match.Id)
+	}
+	return ids
+}
+
+func equalIds(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestServeCachingHeaders(t *testing.T) {
+	setupServeDB(t)
+	tests := []struct {
+		path         string
+		lastModified string
+	}{
+		{"/manga/" + mangaSafe, "Mon, 02 Jan 2023 03:04:05 GMT"},
+		{"/similar/" + mangaSafe, "Sat, 04 Mar 2023 05:06:07 GMT"},
+		{"/mapping/al/30013", ""},
+	}
+	for _, test := range tests {
+		recorder := serveRequest("GET", test.path, nil)
+		etag := recorder.Header().Get("ETag")
+		if recorder.Code != http.StatusOK || etag == "" {
+			t.Errorf("%s: got %d with ETag %q", test.path, recorder.Code, etag)
+			continue
+		}
+		if got := recorder.Header().Get("Last-Modified"); got != test.lastModified {
+			t.Errorf("%s: got Last-Modified %q, want %q", test.path, got, test.lastModified)
+		}
+
+		notModified := serveRequest("GET", test.path, http.Header{"If-None-Match": {etag}})
+		if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+			t.Errorf("%s with If-None-Match: got %d and %d bytes, want %d and none", test.path, notModified.Code, notModified.Body.Len(), http.StatusNotModified)
+		}
+		if got := serveRequest("GET", test.path, http.Header{"If-None-Match": {`"stale"`}}).Code; got != http.StatusOK {
+			t.Errorf("%s with a stale ETag: got %d, want %d", test.path, got, http.StatusOK)
+		}
+		if test.lastModified != "" {
+			if got := serveRequest("GET", test.path, http.Header{"If-Modified-Since": {test.lastModified}}).Code; got != http.StatusNotModified {
+				t.Errorf("%s with If-Modified-Since: got %d, want %d", test.path, got, http.StatusNotModified)
+			}
+		}
+	}
+
+	// A filtered response differs from the unfiltered one, so it can't share its ETag
+	unfiltered := serveRequest("GET", "/similar/"+mangaSafe, nil).Header().Get("ETag")
+	filtered := serveRequest("GET", "/similar/"+mangaSafe+"?lang=fr", nil).Header().Get("ETag")
+	if unfiltered == filtered {
+		t.Errorf("filtered and unfiltered responses have the same ETag %s", filtered)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/similar-manga/similar/cmd"
+	"github.com/similar-manga/similar/internal"
+	"github.com/similar-manga/similar/internal/fixtures"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	stubManga1 = "00000000-0000-0000-0000-000000000001"
+	stubManga2 = "00000000-0000-0000-0000-000000000002"
+	stubManga3 = "00000000-0000-0000-0000-000000000003"
+)
+
+// Runs the commands in an empty data directory of their own, with a freshly migrated data.db
+func setupWorkDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, sub := range []string{"data/manga", "data/mappings", "data/similar"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	internal.DB.Close()
+	internal.ConnectDB()
+	t.Cleanup(func() {
+		internal.DB.Close()
+		_ = os.Chdir(wd)
+		internal.ConnectDB()
+	})
+	runCommand(t, "init", "--migrate-only")
+}
+
+// Resolved before any test moves to its own data directory
+var testdataDir, _ = filepath.Abs("testdata")
+
+func testdataFile(name string) string {
+	return filepath.Join(testdataDir, name)
+}
+
+// Runs the command like the similar binary would, then puts back the flags and http transport it changed
+func runCommand(t *testing.T, args ...string) {
+	t.Helper()
+	transport := internal.Transport
+	defer func() {
+		internal.Transport = transport
+		internal.Offline = false
+		resetFlags(cmd.RootCmd)
+	}()
+	cmd.RootCmd.SetArgs(args)
+	if err := cmd.RootCmd.Execute(); err != nil {
+		t.Fatalf("similar %v: %v", args, err)
+	}
+}
+
+func resetFlags(command *cobra.Command) {
+	command.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			_ = flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+		}
+	})
+	for _, child := range command.Commands() {
+		resetFlags(child)
+	}
+}
+
+func storedManga(t *testing.T) map[string]internal.Manga {
+	t.Helper()
+	rows, err := internal.DB.Query("SELECT JSON FROM " + internal.TableManga)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	mangaList := map[string]internal.Manga{}
+	for rows.Next() {
+		var jsonManga []byte
+		manga := internal.Manga{}
+		if err := rows.Scan(&jsonManga); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(jsonManga, &manga); err != nil {
+			t.Fatal(err)
+		}
+		mangaList[manga.Id] = manga
+	}
+	return mangaList
+}
+
+func storedMapping(t *testing.T, table string) map[string]string {
+	t.Helper()
+	rows, err := internal.DB.Query("SELECT UUID, ID FROM " + table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	mapping := map[string]string{}
+	for rows.Next() {
+		var uuid, id string
+		if err := rows.Scan(&uuid, &id); err != nil {
+			t.Fatal(err)
+		}
+		mapping[uuid] = id
+	}
+	return mapping
+}
+
+// Checks the database after add, metadata --all against stub_metadata.json and calculate mappings
+// stub_metadata.json renames manga 1 and no longer has manga 3
+func checkAddMetadataMappings(t *testing.T) {
+	t.Helper()
+	mangaList := storedManga(t)
+	if len(mangaList) != 3 {
+		t.Fatalf("got %d manga, want 3", len(mangaList))
+	}
+	if title := (*mangaList[stubManga1].Title)["en"]; title != "Stub Manga 1 Renamed" {
+		t.Errorf("metadata didn't update manga 1, its title is %q", title)
+	}
+	if mangaList[stubManga2].TombstonedAt != "" {
+		t.Errorf("manga 2 is still on MangaDex but was tombstoned")
+	}
+	if mangaList[stubManga3].TombstonedAt == "" {
+		t.Errorf("manga 3 is no longer on MangaDex but wasn't tombstoned")
+	}
+
+	tests := []struct {
+		table   string
+		mapping map[string]string
+	}{
+		{internal.TableMangaupdates, map[string]string{stubManga1: "1abcdef", stubManga2: "12345"}},
+		{internal.TableMangaupdatesNewId, map[string]string{stubManga1: "2800497111", stubManga2: "12345"}},
+		{internal.TableAnilist, map[string]string{stubManga1: "30013"}},
+		{internal.TableKitsu, map[string]string{stubManga1: "41"}},
+		{internal.TableMyanimelist, map[string]string{stubManga2: "2"}},
+	}
+	for _, test := range tests {
+		mapping := storedMapping(t, test.table)
+		if len(mapping) != len(test.mapping) {
+			t.Errorf("%s: got %v, want %v", test.table, mapping, test.mapping)
+			continue
+		}
+		for uuid, id := range test.mapping {
+			if mapping[uuid] != id {
+				t.Errorf("%s: got %v, want %v", test.table, mapping, test.mapping)
+				break
+			}
+		}
+	}
+}
+
+func TestCommandsAgainstStubServer(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+	if mangaList := storedManga(t); len(mangaList) != 3 {
+		t.Fatalf("add stored %d manga, want 3", len(mangaList))
+	}
+	runCommand(t, "--http-stub", testdataFile("stub_metadata.json"), "mangadex", "metadata", "--all")
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "calculate", "mappings")
+	checkAddMetadataMappings(t)
+}
+
+func TestMappingOfALinkWhichIsNoLongerAnIdIsDeleted(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+
+	// Stored by an earlier run, before the MangaUpdates link of manga 3 stopped being an id
+	_, err := internal.DB.Exec("INSERT INTO "+internal.TableMangaupdates+" (UUID, ID, RAW) VALUES (?, ?, ?)", stubManga3, "54321", "54321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "calculate", "mappings")
+	if id, ok := storedMapping(t, internal.TableMangaupdates)[stubManga3]; ok {
+		t.Errorf("manga 3 is still mapped to %s", id)
+	}
+}
+
+func TestAddWithoutTimestampStartsFromTheNewestStoredManga(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+
+	// Manga 1 was created first, so it is only found again by walking the whole catalogue
+	if err := os.Remove("data/last_manga_add.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.DB.Exec("DELETE FROM "+internal.TableManga+" WHERE UUID = ?", stubManga1); err != nil {
+		t.Fatal(err)
+	}
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+	if _, ok := storedManga(t)[stubManga1]; ok {
+		t.Errorf("manga 1 was added again, the whole catalogue was walked")
+	}
+	if contents, err := os.ReadFile("data/last_manga_add.txt"); err != nil || string(contents) != "2021-01-03T00:00:00+00:00" {
+		t.Errorf("last manga add is %q %v, want the createdAt of manga 3", contents, err)
+	}
+
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add", "--all")
+	if _, ok := storedManga(t)[stubManga1]; !ok {
+		t.Errorf("manga 1 wasn't added by walking the whole catalogue")
+	}
+}
+
+func TestMappingAuditChangesNothing(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+
+	// As if Kitsu was added to the registry after the database was made
+	if _, err := internal.DB.Exec("DROP TABLE " + internal.TableKitsu); err != nil {
+		t.Fatal(err)
+	}
+	runCommand(t, "calculate", "mappings", "--audit", "--report", filepath.Join(t.TempDir(), "audit.json"))
+	kitsu, _ := internal.GetMappingSite("kt")
+	if exists, err := kitsu.TableExists(); err != nil || exists {
+		t.Errorf("audit created the missing %s table %v", internal.TableKitsu, err)
+	}
+	if mapping := storedMapping(t, internal.TableAnilist); len(mapping) != 0 {
+		t.Errorf("audit stored AniList mappings %v", mapping)
+	}
+}
+
+// Writes stub.json changed by edit to a file of its own
+func editedStub(t *testing.T, edit func(data *fixtures.StubData)) string {
+	t.Helper()
+	data, err := fixtures.LoadStubData(testdataFile("stub.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(data)
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "stub_edited.json")
+	if err := os.WriteFile(fileName, jsonData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// Writes stub.json with the Kitsu slugs it knows replaced
+func stubWithKitsuSlugs(t *testing.T, slugs map[string]string) string {
+	t.Helper()
+	return editedStub(t, func(data *fixtures.StubData) {
+		data.KitsuSlugs = slugs
+	})
+}
+
+func TestMetadataDoesNotTombstoneAnEmptyBatch(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+
+	// As if MangaDex answered the batch with an empty list
+	runCommand(t, "--http-stub", editedStub(t, func(data *fixtures.StubData) {
+		data.Manga = nil
+	}), "mangadex", "metadata", "--all")
+	for uuid, manga := range storedManga(t) {
+		if manga.TombstonedAt != "" {
+			t.Errorf("manga %s was tombstoned from an empty batch", uuid)
+		}
+	}
+	if _, err := os.Stat("data/last_metadata_update.txt"); err == nil {
+		t.Errorf("last metadata update moved on after skipping the batch")
+	}
+
+	info, err := os.Stat("data/tombstone_report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0133 != 0 {
+		t.Errorf("tombstone report written with mode %v, want it neither executable nor writable by others", info.Mode().Perm())
+	}
+}
+
+func TestKitsuSlugsAreResolvedToIds(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+
+	// A slug Kitsu doesn't know isn't mapped
+	runCommand(t, "--http-stub", stubWithKitsuSlugs(t, nil), "calculate", "mappings")
+	if id, ok := storedMapping(t, internal.TableKitsu)[stubManga1]; ok {
+		t.Errorf("unknown slug mapped to %s", id)
+	}
+
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "calculate", "mappings")
+	if id := storedMapping(t, internal.TableKitsu)[stubManga1]; id != "41" {
+		t.Errorf("slug berserk resolved to %q, want 41", id)
+	}
+
+	// The id resolved by the last run is reused without asking Kitsu again, which would no longer know the slug
+	runCommand(t, "--http-stub", stubWithKitsuSlugs(t, nil), "calculate", "mappings")
+	if id := storedMapping(t, internal.TableKitsu)[stubManga1]; id != "41" {
+		t.Errorf("got %q after resolving again, want the stored 41", id)
+	}
+	kitsu, _ := internal.GetMappingSite("kt")
+	if mangaIds, err := kitsu.LookupMangaIds("https://kitsu.app/manga/41"); err != nil || len(mangaIds) != 1 || mangaIds[0] != stubManga1 {
+		t.Errorf("got %v %v for Kitsu 41, want manga 1", mangaIds, err)
+	}
+}
+
+func TestCommandsAgainstCassettes(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-replay", testdataFile("cassette_add.json"), "mangadex", "add")
+	runCommand(t, "--http-replay", testdataFile("cassette_metadata.json"), "mangadex", "metadata", "--all")
+	runCommand(t, "--http-replay", testdataFile("cassette_mappings.json"), "calculate", "mappings")
+	checkAddMetadataMappings(t)
+}
+
+func TestLookupLeavesOutTombstonedManga(t *testing.T) {
+	setupWorkDir(t)
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "mangadex", "add")
+	runCommand(t, "--http-stub", testdataFile("stub.json"), "calculate", "mappings")
+
+	// Both manga 1 and 3 link AniList 30013, until manga 3 is tombstoned
+	anilist, _ := internal.GetMappingSite("al")
+	if mangaIds, err := anilist.LookupMangaIds("30013"); err != nil || len(mangaIds) != 2 {
+		t.Fatalf("got %v %v for AniList 30013 before the tombstoning, want manga 1 and 3", mangaIds, err)
+	}
+	runCommand(t, "--http-stub", testdataFile("stub_metadata.json"), "mangadex", "metadata", "--all")
+
+	mangaIds, err := anilist.LookupMangaIds("30013")
+	if err != nil || len(mangaIds) != 1 || mangaIds[0] != stubManga1 {
+		t.Errorf("got %v %v for AniList 30013, want only manga 1", mangaIds, err)
+	}
+	if mappings, err := internal.GetMangaMappings(stubManga3); err != nil || len(mappings) != 0 {
+		t.Errorf("got mappings %v %v of tombstoned manga 3, want none", mappings, err)
+	}
+	if mappings, err := internal.GetMangaMappings(stubManga1); err != nil || mappings["al"] != "30013" {
+		t.Errorf("got mappings %v %v of manga 1, want AniList 30013 among them", mappings, err)
+	}
+}
+
+// A cassette recorded against the real api holds the rate limited responses the recording retried
+func TestCassetteWithRetriedResponsesReplays(t *testing.T) {
+	setupWorkDir(t)
+	cassette, err := fixtures.LoadCassette(testdataFile("cassette_add.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateLimited := cassette.Interactions[0]
+	rateLimited.Response = fixtures.RecordedResponse{StatusCode: 429, Header: map[string][]string{"Retry-After": {"60"}}, Body: `{"result":"error"}`}
+	cassette.Interactions = append([]fixtures.Interaction{rateLimited}, cassette.Interactions...)
+	fileName := filepath.Join(t.TempDir(), "cassette.json")
+	if err := cassette.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	runCommand(t, "--http-replay", fileName, "mangadex", "add")
+	if mangaList := storedManga(t); len(mangaList) != 3 {
+		t.Fatalf("add stored %d manga, want 3", len(mangaList))
+	}
+}
@@ -3,8 +3,8 @@ package internal
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 )
 
 const TableMangaupdates = "MANGAUPDATES_OLD"
@@ -17,13 +17,16 @@ const TableNovelUpdates = "NOVEL_UPDATES"
 const TableKitsu = "KITSU"
 const TableBookWalker = "BOOK_WALKER"
 const TableAnimePlanet = "ANIME_PLANET"
+const TableTag = "TAG"
 
 const TableNekoMappings = "mappings"
 
 var DB *sql.DB
 
+const DBFile = "data/data.db"
+
 func ConnectDB() {
-	db, err := sql.Open("sqlite3", "data/data.db")
+	db, err := sql.Open("sqlite3", DBFile)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -40,28 +43,101 @@ func ConnectNekoDB(name string) *sql.DB {
 	return db
 }
 
-func CheckErr(err error) {
+// GetAllManga returns every manga which is still on MangaDex, tombstoned manga are left out
+// Rows which can't be read are skipped and returned joined as *RowError, alongside the rest of the manga
+// Invalid json would fail the json_extract of the whole query, so those rows are selected to be reported
+func GetAllManga() ([]Manga, error) {
+	rows, err := DB.Query("SELECT UUID, JSON FROM " + TableManga + " WHERE NOT json_valid(JSON) OR json_extract(JSON, '$.tombstonedAt') IS NULL ORDER BY UUID ASC ")
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("reading manga: %w", err)
 	}
-}
-
-func GetAllManga() []Manga {
-	rows, err := DB.Query("SELECT JSON FROM " + TableManga + " ORDER BY UUID ASC ")
 	defer rows.Close()
-	CheckErr(err)
 
 	var mangaList []Manga
+	var rowErrs []error
 	for rows.Next() {
 		manga := Manga{}
+		var uuid string
 		var jsonManga []byte
-		rows.Scan(&jsonManga)
-		err := json.Unmarshal(jsonManga, &manga)
-		if err != nil {
-			fmt.Printf(string(jsonManga))
+		if err := rows.Scan(&uuid, &jsonManga); err != nil {
+			return nil, fmt.Errorf("reading manga: %w", err)
+		}
+		if err := json.Unmarshal(jsonManga, &manga); err != nil {
+			rowErrs = append(rowErrs, &RowError{Table: TableManga, UUID: uuid, Err: err})
+			continue
 		}
-		CheckErr(err)
 		mangaList = append(mangaList, manga)
 	}
-	return mangaList
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading manga: %w", err)
+	}
+	return mangaList, errors.Join(rowErrs...)
+}
+
+// GetMangaIdsChangedSince returns the manga which were added, updated or tombstoned at or after the given date
+// Manga stored before updatedAt was recorded, or with invalid json, are always returned since we can't tell if they changed
+func GetMangaIdsChangedSince(date string) (map[string]bool, error) {
+	rows, err := DB.Query("SELECT UUID FROM "+TableManga+" WHERE DATE >= ? OR NOT json_valid(JSON) OR json_extract(JSON, '$.updatedAt') IS NULL OR json_extract(JSON, '$.updatedAt') >= ? OR json_extract(JSON, '$.tombstonedAt') >= ?", date, date, date)
+	if err != nil {
+		return nil, fmt.Errorf("reading manga changed since %s: %w", date, err)
+	}
+	defer rows.Close()
+
+	mangaIds := map[string]bool{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, fmt.Errorf("reading manga changed since %s: %w", date, err)
+		}
+		mangaIds[uuid] = true
+	}
+	return mangaIds, rows.Err()
+}
+
+// GetNewestMangaCreatedAt returns the latest createdAt of the stored manga, tombstoned ones included, empty if there are none
+func GetNewestMangaCreatedAt() (string, error) {
+	var createdAt sql.NullString
+	err := DB.QueryRow("SELECT MAX(json_extract(JSON, '$.createdAt')) FROM " + TableManga + " WHERE json_valid(JSON)").Scan(&createdAt)
+	if err != nil {
+		return "", fmt.Errorf("reading the newest manga: %w", err)
+	}
+	return createdAt.String, nil
+}
+
+// GetTombstonedMangaIds returns the manga which were deleted or merged on MangaDex
+func GetTombstonedMangaIds() ([]string, error) {
+	rows, err := DB.Query("SELECT UUID FROM " + TableManga + " WHERE json_valid(JSON) AND json_extract(JSON, '$.tombstonedAt') IS NOT NULL ORDER BY UUID ASC")
+	if err != nil {
+		return nil, fmt.Errorf("reading tombstoned manga: %w", err)
+	}
+	defer rows.Close()
+
+	var mangaIds []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, fmt.Errorf("reading tombstoned manga: %w", err)
+		}
+		mangaIds = append(mangaIds, uuid)
+	}
+	return mangaIds, rows.Err()
+}
+
+// GetAllTags returns the tag catalogue synced from MangaDex, empty if it was never synced
+func GetAllTags() ([]DbTag, error) {
+	rows, err := DB.Query("SELECT UUID, NAME, TAG_GROUP, JSON FROM " + TableTag + " ORDER BY TAG_GROUP ASC, NAME ASC")
+	if err != nil {
+		return nil, fmt.Errorf("reading tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []DbTag
+	for rows.Next() {
+		tag := DbTag{}
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Group, &tag.JSON); err != nil {
+			return nil, fmt.Errorf("reading tags: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
 }
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckErr aborts the command, it is only for errors the command can't carry on from
+// Everything else returns its error so the command can retry, skip and log it, or abort
+func CheckErr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// RowError is a row which couldn't be read, the rest of the table still was
+type RowError struct {
+	Table string
+	UUID  string
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s row %s: %v", e.Table, e.UUID, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorSummary collects the errors a command skipped over, so they can be listed once it finished
+// It is safe to add to from several goroutines
+type ErrorSummary struct {
+	mutex  sync.Mutex
+	steps  []string
+	errors map[string][]error
+}
+
+// Number of errors of each step which are printed, the rest are only counted
+const errorSummaryExamples = 5
+
+func NewErrorSummary() *ErrorSummary {
+	return &ErrorSummary{errors: map[string][]error{}}
+}
+
+// Add records err as skipped during step, nil errors are ignored
+// Joined errors, e.g. the row errors of a table, are recorded one by one
+func (s *ErrorSummary) Add(step string, err error) {
+	if err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			s.Add(step, e)
+		}
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.errors[step]; !ok {
+		s.steps = append(s.steps, step)
+	}
+	s.errors[step] = append(s.errors[step], err)
+}
+
+// SkipRows records the row errors in err as skipped and returns whatever else went wrong
+//
+//	mangaList, err := internal.GetAllManga()
+//	summary.CheckErr(summary.SkipRows("reading manga", err))
+func (s *ErrorSummary) SkipRows(step string, err error) error {
+	if err == nil {
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	var others []error
+	for _, e := range errs {
+		var rowErr *RowError
+		if errors.As(e, &rowErr) {
+			s.Add(step, e)
+		} else {
+			others = append(others, e)
+		}
+	}
+	return errors.Join(others...)
+}
+
+func (s *ErrorSummary) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, errs := range s.errors {
+		count += len(errs)
+	}
+	return count
+}
+
+// CheckErr aborts the command like internal.CheckErr, listing what was skipped before that first
+func (s *ErrorSummary) CheckErr(err error) {
+	if err != nil {
+		s.Print()
+		log.Fatal(err)
+	}
+}
+
+// Print lists the skipped errors by step, in the order the steps first failed
+func (s *ErrorSummary) Print() {
+	s.Fprint(os.Stdout)
+}
+
+func (s *ErrorSummary) Fprint(w io.Writer) {
+	count := s.Len()
+	if count == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(w, "\u001B[1;31mFinished with %d skipped errors\u001B[0m\n", count)
+	for _, step := range s.steps {
+		errs := s.errors[step]
+		fmt.Fprintf(w, "  | %s: %d errors\n", step, len(errs))
+		messages := make([]string, 0, len(errs))
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		sort.Strings(messages)
+		for i, message := range messages {
+			if i == errorSummaryExamples {
+				fmt.Fprintf(w, "  |   - ... and %d more\n", len(messages)-errorSummaryExamples)
+				break
+			}
+			fmt.Fprintf(w, "  |   - %s\n", message)
+		}
+	}
+}
+
+// Retry calls fn until it succeeds or has been tried attempts times, doubling the wait in between each time
+// It returns the last error, only use it for errors which may go away such as network errors
+func Retry(attempts int, wait time.Duration, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt < attempts && !Offline {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Cassette is a recording of http requests and the responses they got, stored as json so it can be edited by hand
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+func LoadCassette(fileName string) (*Cassette, error) {
+	jsonCassette, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if !json.Valid(jsonCassette) {
+		jsonCassette = repairRecording(jsonCassette)
+	}
+	err = json.Unmarshal(jsonCassette, cassette)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", fileName, err)
+	}
+	return cassette, nil
+}
+
+// A recording cut short by the command exiting before the Recorder was closed has no closing brackets, and
+// maybe half an interaction, so it is loaded up to its last complete interaction
+func repairRecording(contents []byte) []byte {
+	lines := bytes.Split(bytes.TrimRight(contents, "\n"), []byte("\n"))
+	for ; len(lines) > 0; lines = lines[:len(lines)-1] {
+		repaired := bytes.TrimSuffix(bytes.Join(lines, []byte("\n")), []byte(","))
+		repaired = append(repaired, "\n]}\n"...)
+		if json.Valid(repaired) {
+			return repaired
+		}
+	}
+	return contents
+}
+
+func (c *Cassette) Save(fileName string) error {
+	jsonCassette, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, jsonCassette, 0777)
+}
+
+// Recorder is a http.RoundTripper which sends requests through Base and records them into the cassette file
+// Each interaction is appended to the file as a line of its own, and Close writes the closing brackets
+// A recording which is never closed, e.g. because the command exited with an error, is still loaded by LoadCassette
+type Recorder struct {
+	Base     http.RoundTripper
+	FileName string
+
+	mutex        sync.Mutex
+	file         *os.File
+	interactions int
+	closed       bool
+}
+
+func NewRecorder(base http.RoundTripper, fileName string) *Recorder {
+	return &Recorder{Base: base, FileName: fileName}
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.Base.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	// Read the body so it can be both recorded and returned
+	responseBody, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	err = r.record(Interaction{
+		Request:  RecordedRequest{Method: request.Method, URL: request.URL.String(), Body: requestBody},
+		Response: RecordedResponse{StatusCode: response.StatusCode, Header: response.Header, Body: string(responseBody)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record into cassette %s: %w", r.FileName, err)
+	}
+	return response, nil
+}
+
+func (r *Recorder) record(interaction Interaction) error {
+	jsonInteraction, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return errors.New("the recorder is closed")
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	separator := ",\n"
+	if r.interactions == 0 {
+		separator = "\n"
+	}
+	if _, err := r.file.WriteString(separator + string(jsonInteraction)); err != nil {
+		return err
+	}
+	r.interactions++
+	return nil
+}
+
+// Creates the cassette file the first time it is needed, the mutex has to be held
+func (r *Recorder) open() error {
+	if r.file != nil {
+		return nil
+	}
+	file, err := os.Create(r.FileName)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(`{"interactions": [`); err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	return nil
+}
+
+// Close finishes the cassette file, which is written even if nothing was recorded
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.open(); err != nil {
+		return err
+	}
+	if _, err := r.file.WriteString("\n]}\n"); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// Replayer is a http.RoundTripper which answers requests from a cassette without any network access
+// Requests are matched on method, url and body, each recording is used once in order and the last one is
+// re-used by later identical requests, since the last is the answer the retries of the recording ended with
+// A request which was never recorded is an error.
+type Replayer struct {
+	Cassette *Cassette
+
+	mutex sync.Mutex
+	used  []bool
+}
+
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{Cassette: cassette, used: make([]bool, len(cassette.Interactions))}
+}
+
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	recorded := RecordedRequest{Method: request.Method, URL: request.URL.String(), Body: requestBody}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	match := -1
+	for i, interaction := range r.Cassette.Interactions {
+		if interaction.Request != recorded {
+			continue
+		}
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if match == -1 {
+		return nil, fmt.Errorf("no recorded response for %s %s", request.Method, request.URL)
+	}
+	r.used[match] = true
+
+	response := r.Cassette.Interactions[match].Response
+	header := response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+		StatusCode:    response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(response.Body))),
+		ContentLength: int64(len(response.Body)),
+		Request:       request,
+	}, nil
+}
+
+// Reads the body of the request, leaving it readable for whoever sends it
+func readRequestBody(request *http.Request) (string, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return "", nil
+	}
+	body, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	return string(body), nil
+}
@@ -0,0 +1,182 @@
+package fixtures
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func interaction(method string, url string, body string, statusCode int, responseBody string) Interaction {
+	return Interaction{
+		Request:  RecordedRequest{Method: method, URL: url, Body: body},
+		Response: RecordedResponse{StatusCode: statusCode, Body: responseBody},
+	}
+}
+
+func replay(t *testing.T, replayer *Replayer, method string, url string, body string) (int, string, error) {
+	t.Helper()
+	var requestBody io.Reader
+	if body != "" {
+		requestBody = strings.NewReader(body)
+	}
+	request, err := http.NewRequest(method, url, requestBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := replayer.RoundTrip(request)
+	if err != nil {
+		return 0, "", err
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(responseBody), nil
+}
+
+func TestReplayerMatching(t *testing.T) {
+	cassette := &Cassette{Interactions: []Interaction{
+		interaction("GET", "https://api.mangadex.org/manga?offset=0", "", 429, "rate limited"),
+		interaction("GET", "https://api.mangadex.org/manga?offset=0", "", 200, "page 1"),
+		interaction("GET", "https://api.mangadex.org/manga?offset=100", "", 200, "page 2"),
+		interaction("POST", "https://api.mangadex.org/manga?offset=0", `{"a":1}`, 201, "created a"),
+		interaction("POST", "https://api.mangadex.org/manga?offset=0", `{"a":2}`, 201, "created b"),
+	}}
+	replayer := NewReplayer(cassette)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{"first recording", "GET", "https://api.mangadex.org/manga?offset=0", "", 429, "rate limited"},
+		{"next recording of the same request", "GET", "https://api.mangadex.org/manga?offset=0", "", 200, "page 1"},
+		{"last recording is re-used", "GET", "https://api.mangadex.org/manga?offset=0", "", 200, "page 1"},
+		{"matched on url", "GET", "https://api.mangadex.org/manga?offset=100", "", 200, "page 2"},
+		{"matched on body", "POST", "https://api.mangadex.org/manga?offset=0", `{"a":2}`, 201, "created b"},
+		{"matched on method", "POST", "https://api.mangadex.org/manga?offset=0", `{"a":1}`, 201, "created a"},
+	}
+	for _, test := range tests {
+		statusCode, response, err := replay(t, replayer, test.method, test.url, test.body)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if statusCode != test.statusCode || response != test.response {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, statusCode, response, test.statusCode, test.response)
+		}
+	}
+
+	unrecorded := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"GET", "https://api.mangadex.org/manga?offset=200", ""},
+		{"PUT", "https://api.mangadex.org/manga?offset=0", ""},
+		{"POST", "https://api.mangadex.org/manga?offset=0", `{"a":3}`},
+	}
+	for _, request := range unrecorded {
+		if _, _, err := replay(t, replayer, request.method, request.url, request.body); err == nil {
+			t.Errorf("%s %s %s was never recorded but got a response", request.method, request.url, request.body)
+		}
+	}
+}
+
+type staticTransport struct {
+	responses map[string]string
+}
+
+func (s staticTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(s.responses[request.URL.String()]))}, nil
+}
+
+func record(t *testing.T, recorder *Recorder, urls ...string) {
+	t.Helper()
+	for _, url := range urls {
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := recorder.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != "body of "+url {
+			t.Errorf("recorder returned %q for %s", body, url)
+		}
+	}
+}
+
+func TestRecorderAppendsInteractions(t *testing.T) {
+	urls := []string{"https://a.test/1", "https://a.test/2", "https://a.test/3"}
+	base := staticTransport{responses: map[string]string{}}
+	for _, url := range urls {
+		base.responses[url] = "body of " + url
+	}
+	fileName := filepath.Join(t.TempDir(), "cassette.json")
+	recorder := NewRecorder(base, fileName)
+	record(t, recorder, urls...)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cassette, err := LoadCassette(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != len(urls) {
+		t.Fatalf("got %d interactions, want %d", len(cassette.Interactions), len(urls))
+	}
+	for i, url := range urls {
+		if got := cassette.Interactions[i]; got.Request.URL != url || got.Response.Body != "body of "+url {
+			t.Errorf("interaction %d is %+v, want %s", i, got, url)
+		}
+	}
+}
+
+func TestRecordingCutShortIsLoaded(t *testing.T) {
+	urls := []string{"https://a.test/1", "https://a.test/2"}
+	base := staticTransport{responses: map[string]string{}}
+	for _, url := range urls {
+		base.responses[url] = "body of " + url
+	}
+	fileName := filepath.Join(t.TempDir(), "cassette.json")
+	recorder := NewRecorder(base, fileName)
+	record(t, recorder, urls...)
+
+	// Never closed, as when the command exits with an error
+	if err := recorder.file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	cassette, err := LoadCassette(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 2 {
+		t.Errorf("got %d interactions, want 2", len(cassette.Interactions))
+	}
+
+	// Half written last interaction
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, contents[:len(contents)-10], 0666); err != nil {
+		t.Fatal(err)
+	}
+	cassette, err = LoadCassette(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 1 || cassette.Interactions[0].Request.URL != urls[0] {
+		t.Errorf("got %+v, want only the interaction of %s", cassette.Interactions, urls[0])
+	}
+}
@@ -0,0 +1,181 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/similar-manga/similar/mangadex"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StubData is what the stub server answers with, in place of the real MangaDex, MangaUpdates and Kitsu
+type StubData struct {
+	Manga []mangadex.Manga `json:"manga"`
+	Tags  []mangadex.Tag   `json:"tags"`
+
+	// Series ids the MangaUpdates api knows
+	MangaUpdatesSeries []string `json:"mangaUpdatesSeries"`
+
+	// Legacy website ids of MangaUpdates, to the series id their page links to
+	MangaUpdatesLegacy map[string]string `json:"mangaUpdatesLegacy"`
+
+	// Kitsu slugs to the numeric id the Kitsu api has them under
+	KitsuSlugs map[string]string `json:"kitsuSlugs"`
+}
+
+func LoadStubData(fileName string) (*StubData, error) {
+	jsonData, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	data := &StubData{}
+	err = json.Unmarshal(jsonData, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stub data %s: %w", fileName, err)
+	}
+	return data, nil
+}
+
+// NewStubHandler serves the endpoints the commands use from data
+//
+//	GET /manga                MangaDex manga search, with the ids, since, order, limit and offset filters
+//	GET /manga/tag            MangaDex tag list
+//	GET /v1/series/{id}       MangaUpdates api series
+//	GET /series.html?id={id}  MangaUpdates website series page
+//	GET /api/edge/manga       Kitsu api manga, with the filter[slug] filter
+func NewStubHandler(data *StubData) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manga", func(w http.ResponseWriter, r *http.Request) {
+		stubSearchManga(w, r, data)
+	})
+	mux.HandleFunc("/manga/tag", func(w http.ResponseWriter, r *http.Request) {
+		writeStubJson(w, mangadex.TagResponse{Result: "ok", Response: "collection", Data: data.Tags, Limit: int32(len(data.Tags)), Total: int32(len(data.Tags))})
+	})
+	mux.HandleFunc("/v1/series/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/series/")
+		for _, seriesId := range data.MangaUpdatesSeries {
+			if seriesId == id {
+				writeStubJson(w, map[string]interface{}{"series_id": id})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/series.html", func(w http.ResponseWriter, r *http.Request) {
+		seriesId, ok := data.MangaUpdatesLegacy[r.URL.Query().Get("id")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		rssUrl := html.EscapeString("https://api.mangaupdates.com/v1/series/" + seriesId + "/rss")
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, `<html><body><div id="main_content"><div></div><div><div class="row no-gutters"><div class="col-12 p-2"><a href="%s">RSS</a></div></div></div></div></body></html>`, rssUrl)
+	})
+	mux.HandleFunc("/api/edge/manga", func(w http.ResponseWriter, r *http.Request) {
+		found := []map[string]string{}
+		if id, ok := data.KitsuSlugs[r.URL.Query().Get("filter[slug]")]; ok {
+			found = append(found, map[string]string{"id": id, "type": "manga"})
+		}
+		writeStubJson(w, map[string]interface{}{"data": found})
+	})
+	return mux
+}
+
+// NewStubServer starts a local server with the stub endpoints, it must be closed when done
+func NewStubServer(data *StubData) *httptest.Server {
+	return httptest.NewServer(NewStubHandler(data))
+}
+
+// RedirectTransport sends every request to Target whatever its host, so a stub server can stand in for every service
+type RedirectTransport struct {
+	Base   http.RoundTripper
+	Target *url.URL
+}
+
+func (t *RedirectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	redirected := request.Clone(request.Context())
+	redirected.URL.Scheme = t.Target.Scheme
+	redirected.URL.Host = t.Target.Host
+	redirected.Host = ""
+	return t.Base.RoundTrip(redirected)
+}
+
+// Answers like the MangaDex search, including refusing offsets past mangadex.MaxSearchOffset
+func stubSearchManga(w http.ResponseWriter, r *http.Request, data *StubData) {
+	query := r.URL.Query()
+	limit, offset := 10, 0
+	if value := query.Get("limit"); value != "" {
+		limit, _ = strconv.Atoi(value)
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, _ = strconv.Atoi(value)
+	}
+	if limit < 0 || offset < 0 || offset+limit > mangadex.MaxSearchOffset {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"result":"error","errors":[{"status":400,"title":"Bad Request","detail":"offset + limit is over the maximum"}]}`))
+		return
+	}
+
+	ids := map[string]bool{}
+	for _, id := range query["ids[]"] {
+		ids[id] = true
+	}
+	var mangaList []mangadex.Manga
+	for _, manga := range data.Manga {
+		if len(ids) > 0 && !ids[manga.Id] {
+			continue
+		}
+		if since := query.Get("createdAtSince"); since != "" && stubTimestamp(manga.Attributes.CreatedAt) < since {
+			continue
+		}
+		if since := query.Get("updatedAtSince"); since != "" && stubTimestamp(manga.Attributes.UpdatedAt) < since {
+			continue
+		}
+		mangaList = append(mangaList, manga)
+	}
+
+	order := func(manga mangadex.Manga) string { return manga.Id }
+	descending := false
+	if value := query.Get("order[createdAt]"); value != "" {
+		order = func(manga mangadex.Manga) string { return manga.Attributes.CreatedAt }
+		descending = value == "desc"
+	} else if value := query.Get("order[updatedAt]"); value != "" {
+		order = func(manga mangadex.Manga) string { return manga.Attributes.UpdatedAt }
+		descending = value == "desc"
+	}
+	sort.SliceStable(mangaList, func(i, j int) bool {
+		if descending {
+			return order(mangaList[i]) > order(mangaList[j])
+		}
+		return order(mangaList[i]) < order(mangaList[j])
+	})
+
+	total := len(mangaList)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	writeStubJson(w, mangadex.MangaList{Result: "ok", Response: "collection", Data: mangaList[offset:end], Limit: int32(limit), Offset: int32(offset), Total: int32(total)})
+}
+
+func stubTimestamp(timestamp string) string {
+	if len(timestamp) > len("2006-01-02T15:04:05") {
+		return timestamp[:len("2006-01-02T15:04:05")]
+	}
+	return timestamp
+}
+
+func writeStubJson(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
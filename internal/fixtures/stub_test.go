@@ -0,0 +1,86 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/similar-manga/similar/mangadex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func stubManga(count int) *StubData {
+	data := &StubData{}
+	for i := 1; i <= count; i++ {
+		data.Manga = append(data.Manga, mangadex.Manga{
+			Id: fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			Attributes: &mangadex.MangaAttributes{
+				CreatedAt: fmt.Sprintf("2021-01-%02dT00:00:00+00:00", i),
+				UpdatedAt: fmt.Sprintf("2022-01-%02dT00:00:00+00:00", count+1-i),
+			},
+		})
+	}
+	return data
+}
+
+func searchStub(t *testing.T, data *StubData, query string) (int, mangadex.MangaList) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	stubSearchManga(recorder, httptest.NewRequest("GET", "/manga?"+query, nil), data)
+	mangaList := mangadex.MangaList{}
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &mangaList); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code, mangaList
+}
+
+func mangaNumbers(mangaList mangadex.MangaList) []int {
+	var numbers []int
+	for _, manga := range mangaList.Data {
+		var number int
+		fmt.Sscanf(manga.Id[len(manga.Id)-12:], "%d", &number)
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+func TestStubSearchMangaPaging(t *testing.T) {
+	data := stubManga(5)
+	tests := []struct {
+		name   string
+		query  string
+		total  int32
+		manga  []int
+		status int
+	}{
+		{"default limit", "", 5, []int{1, 2, 3, 4, 5}, http.StatusOK},
+		{"first page", "limit=2&offset=0", 5, []int{1, 2}, http.StatusOK},
+		{"middle page", "limit=2&offset=2", 5, []int{3, 4}, http.StatusOK},
+		{"last page is short", "limit=2&offset=4", 5, []int{5}, http.StatusOK},
+		{"offset past the end", "limit=2&offset=10", 5, nil, http.StatusOK},
+		{"created order descending", "limit=2&order[createdAt]=desc", 5, []int{5, 4}, http.StatusOK},
+		{"updated order", "limit=2&order[updatedAt]=asc", 5, []int{5, 4}, http.StatusOK},
+		{"created since", "createdAtSince=2021-01-04T00:00:00", 2, []int{4, 5}, http.StatusOK},
+		{"ids", "ids[]=00000000-0000-0000-0000-000000000002&ids[]=00000000-0000-0000-0000-000000000004", 2, []int{2, 4}, http.StatusOK},
+		{"offset and limit over the maximum", fmt.Sprintf("limit=100&offset=%d", mangadex.MaxSearchOffset-99), 0, nil, http.StatusBadRequest},
+		{"offset and limit at the maximum", fmt.Sprintf("limit=100&offset=%d", mangadex.MaxSearchOffset-100), 5, nil, http.StatusOK},
+	}
+	for _, test := range tests {
+		status, mangaList := searchStub(t, data, test.query)
+		if status != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, status, test.status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if mangaList.Total != test.total {
+			t.Errorf("%s: got total %d, want %d", test.name, mangaList.Total, test.total)
+		}
+		if got := mangaNumbers(mangaList); fmt.Sprint(got) != fmt.Sprint(test.manga) {
+			t.Errorf("%s: got manga %v, want %v", test.name, got, test.manga)
+		}
+	}
+}
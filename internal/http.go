@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+)
+
+// Transport is the base http.RoundTripper of every client talking to MangaDex, MangaUpdates or Kitsu
+// It is replaced to record, replay or stub those services, see the --http-* flags of the root command
+var Transport http.RoundTripper = defaultTransport()
+
+// Offline is set when requests never reach the real services, so there are no rate limits to keep to
+var Offline = false
+
+func defaultTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return transport
+}
+
+// NewHTTPClient returns a client which sends its requests through Transport
+// There is no overall timeout since it would include the time spent waiting on rate limits
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: Transport}
+}
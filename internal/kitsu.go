@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Manga endpoint of the Kitsu api, which looks up slugs with its filter[slug] parameter
+const kitsuApiUrl = "https://kitsu.io/api/edge/manga"
+
+// ErrUnknownId is returned when the site has nothing under an id, so the link is wrong rather than the request failing
+var ErrUnknownId = errors.New("unknown to the site")
+
+type kitsuMangaList struct {
+	Data []struct {
+		Id string `json:"id"`
+	} `json:"data"`
+}
+
+// Asks the Kitsu api for the numeric id of the manga with the slug, through Transport
+func resolveKitsuSlug(slug string) (string, error) {
+	request, err := http.NewRequest("GET", kitsuApiUrl+"?"+url.Values{"filter[slug]": {slug}}.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "application/vnd.api+json")
+	var response *http.Response
+	err = Retry(3, 2*time.Second, func() error {
+		var err error
+		response, err = NewHTTPClient().Do(request)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("kitsu slug %s: %w", slug, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kitsu slug %s: http code %d", slug, response.StatusCode)
+	}
+
+	mangaList := kitsuMangaList{}
+	if err := json.NewDecoder(response.Body).Decode(&mangaList); err != nil {
+		return "", fmt.Errorf("kitsu slug %s: %w", slug, err)
+	}
+	if len(mangaList.Data) == 0 {
+		return "", fmt.Errorf("kitsu slug %s: %w", slug, ErrUnknownId)
+	}
+	id := mangaList.Data[0].Id
+	if err := validateNumericId(id); err != nil {
+		return "", fmt.Errorf("kitsu slug %s resolved to %q which %w", slug, id, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MappingSite is an external site whose ids of MangaDex manga are mapped
+// The mapping calculation, its exports, the neko export and the mapping endpoint are all driven by MappingSites
+type MappingSite struct {
+	// Identifies the site, e.g. in the /mapping/{site}/{id} endpoint
+	Key  string
+	Name string
+	// Key of the site in the links of the MangaDex manga, empty if the ids are worked out some other way
+	LinkKey string
+	// Table the mapping is stored in, it is created if missing, all have the same (UUID, ID) layout
+	Table string
+	// The mapping is exported to data/mappings/<ExportFile>.txt
+	ExportFile string
+	// Column of the neko mappings table, empty if the neko database has none for the site
+	NekoColumn string
+	// Page of an id on the site, {id} is replaced by the id
+	URLTemplate string
+	// Turns the id of the link into the one stored and exported, an error skips the link, nil stores it as it is
+	// The link as MangaDex has it is kept in the RAW column
+	Normalise func(id string) (string, error)
+	// Checks the id has the form the site uses, the reason it doesn't is reported by the mapping audit
+	Validate func(id string) error
+	// Asks the site for the id of a normalised id which doesn't validate, e.g. a slug, nil if there is no such lookup
+	// The ids are resolved by calculate mappings, which reuses the id stored for the same link by an earlier run
+	Resolve func(id string) (string, error)
+}
+
+// MappingSites in the order they are calculated
+// A new site which MangaDex has links for only needs an entry here, the neko column also needs a neko migration
+var MappingSites = []MappingSite{
+	{Key: "al", Name: "AniList", LinkKey: "al", Table: TableAnilist, ExportFile: "anilist2mdex", NekoColumn: "al", URLTemplate: "https://anilist.co/manga/{id}",
+		Normalise: normaliseNumericId("manga"), Validate: validateNumericId},
+	{Key: "ap", Name: "AnimePlanet", LinkKey: "ap", Table: TableAnimePlanet, ExportFile: "animeplanet2mdex", NekoColumn: "ap", URLTemplate: "https://www.anime-planet.com/manga/{id}",
+		Normalise: normaliseSlug("manga"), Validate: validateSlug},
+	{Key: "bw", Name: "BookWalker", LinkKey: "bw", Table: TableBookWalker, ExportFile: "bookwalker2mdex", NekoColumn: "bw", URLTemplate: "https://bookwalker.jp/{id}",
+		Normalise: normaliseBookWalkerId, Validate: validatePath},
+	{Key: "nu", Name: "NovelUpdates", LinkKey: "nu", Table: TableNovelUpdates, ExportFile: "novelupdates2mdex", NekoColumn: "nu", URLTemplate: "https://www.novelupdates.com/series/{id}",
+		Normalise: normaliseSlug("series"), Validate: validateSlug},
+	// MangaDex allows either the numeric id or the slug, only the id is stable so slugs are resolved with the Kitsu api
+	{Key: "kt", Name: "Kitsu", LinkKey: "kt", Table: TableKitsu, ExportFile: "kitsu2mdex", NekoColumn: "kt", URLTemplate: "https://kitsu.app/manga/{id}",
+		Normalise: normaliseKitsuId, Validate: validateNumericId, Resolve: resolveKitsuSlug},
+	{Key: "mal", Name: "MyAnimeList", LinkKey: "mal", Table: TableMyanimelist, ExportFile: "myanimelist2mdex", NekoColumn: "mal", URLTemplate: "https://myanimelist.net/manga/{id}",
+		Normalise: normaliseNumericId("manga"), Validate: validateNumericId},
+	// Either the legacy numeric id or the 7 character base36 id of the new website
+	{Key: "mu", Name: "MangaUpdates", LinkKey: "mu", Table: TableMangaupdates, ExportFile: "mangaupdates2mdex", NekoColumn: "mu", URLTemplate: "https://www.mangaupdates.com/series.html?id={id}",
+		Normalise: normaliseMangaUpdatesId, Validate: validateMangaUpdatesId},
+	// Worked out from the MangaUpdates link by asking the MangaUpdates api, see calculate mappings
+	{Key: "mu_new", Name: "MangaUpdates New Id", Table: TableMangaupdatesNewId, ExportFile: "mangaupdates_new2mdex", NekoColumn: "mu_new", URLTemplate: "https://api.mangaupdates.com/v1/series/{id}",
+		Validate: validateNumericId},
+}
+
+var (
+	numericIdPattern      = regexp.MustCompile(`^[0-9]+$`)
+	slugPattern           = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~%-]*$`)
+	pathPattern           = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~%/-]*$`)
+	mangaUpdatesIdPattern = regexp.MustCompile(`^([0-9]+|[0-9a-z]{7})$`)
+)
+
+// The reasons every site shares, a full url or whitespace instead of an id
+func validateId(id string) error {
+	switch {
+	case id == "":
+		return errors.New("is empty")
+	case strings.Contains(id, "://") || strings.HasPrefix(id, "www."):
+		return errors.New("is a url, not an id")
+	case strings.TrimSpace(id) != id:
+		return errors.New("has surrounding whitespace")
+	}
+	return nil
+}
+
+func validateNumericId(id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+	if !numericIdPattern.MatchString(id) {
+		return errors.New("is not a numeric id")
+	}
+	return nil
+}
+
+func validateSlug(id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+	if !slugPattern.MatchString(id) {
+		return errors.New("is not a slug")
+	}
+	return nil
+}
+
+func validatePath(id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+	if !pathPattern.MatchString(id) {
+		return errors.New("is not a url path")
+	}
+	return nil
+}
+
+func validateMangaUpdatesId(id string) error {
+	if err := validateId(id); err != nil {
+		return err
+	}
+	if !mangaUpdatesIdPattern.MatchString(id) {
+		return errors.New("is neither a numeric legacy id nor a 7 character id")
+	}
+	return nil
+}
+
+// Parses the link if MangaDex has a full url instead of an id
+func parseLinkURL(id string) (*url.URL, bool) {
+	if strings.HasPrefix(id, "www.") {
+		id = "https://" + id
+	}
+	if !strings.Contains(id, "://") {
+		return nil, false
+	}
+	link, err := url.Parse(id)
+	return link, err == nil
+}
+
+// The path segment following the first one named after, e.g. 30013 of /manga/30013/Title for "manga"
+// The last segment if there is no segment named after
+func segmentAfter(path string, after string) string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == after {
+			return segments[i+1]
+		}
+	}
+	if len(segments) == 0 {
+		return ""
+	}
+	return segments[len(segments)-1]
+}
+
+// The id out of the url the site has its ids in after segment, if the link is one
+func linkId(id string, segment string) string {
+	id = strings.TrimSpace(id)
+	if link, ok := parseLinkURL(id); ok {
+		return segmentAfter(link.Path, segment)
+	}
+	return strings.Trim(id, "/")
+}
+
+func normaliseNumericId(segment string) func(string) (string, error) {
+	return func(id string) (string, error) {
+		id = linkId(id, segment)
+		return id, validateNumericId(id)
+	}
+}
+
+func normaliseSlug(segment string) func(string) (string, error) {
+	return func(id string) (string, error) {
+		id = strings.ToLower(linkId(id, segment))
+		return id, validateSlug(id)
+	}
+}
+
+// Either an id or a lower-cased slug, from any of the website and api urls, slugs are left for Resolve
+func normaliseKitsuId(id string) (string, error) {
+	id = strings.ToLower(linkId(id, "manga"))
+	if err := validateNumericId(id); err == nil {
+		return id, nil
+	}
+	return id, validateSlug(id)
+}
+
+// The path on the website, e.g. series/12345 or de<uuid>, without the tracking query MangaDex links sometimes have
+func normaliseBookWalkerId(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if link, ok := parseLinkURL(id); ok {
+		id = link.Path
+	}
+	id, _, _ = strings.Cut(id, "?")
+	id, _, _ = strings.Cut(id, "#")
+	id = strings.ToLower(strings.Trim(id, "/"))
+	return id, validatePath(id)
+}
+
+// Either the legacy numeric id of series.html?id= or the 7 character id of /series/{id}/{title}
+func normaliseMangaUpdatesId(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if link, ok := parseLinkURL(id); ok {
+		id = link.Query().Get("id")
+		if id == "" {
+			id = segmentAfter(link.Path, "series")
+		}
+	} else if _, query, ok := strings.Cut(id, "id="); ok {
+		// Links such as series.html?id=123 or id=123 which lost the start of the url
+		id, _, _ = strings.Cut(query, "&")
+	}
+	id = strings.ToLower(strings.Trim(id, "/"))
+	return id, validateMangaUpdatesId(id)
+}
+
+// NormaliseId is the id as it is stored for the site, the id itself if it can't be normalised
+func (s MappingSite) NormaliseId(id string) string {
+	if s.Normalise == nil {
+		return id
+	}
+	normalised, err := s.Normalise(id)
+	if err != nil {
+		return id
+	}
+	return normalised
+}
+
+func GetMappingSite(key string) (MappingSite, bool) {
+	for _, site := range MappingSites {
+		if site.Key == key {
+			return site, true
+		}
+	}
+	return MappingSite{}, false
+}
+
+func (s MappingSite) URL(id string) string {
+	return strings.ReplaceAll(s.URLTemplate, "{id}", id)
+}
+
+// CreateTable creates the mapping table of a site added to the registry after the database was made
+func (s MappingSite) CreateTable() error {
+	_, err := DB.Exec("CREATE TABLE IF NOT EXISTS " + s.Table + "(UUID TEXT PRIMARY KEY, ID TEXT, RAW TEXT)")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", s.Table, err)
+	}
+	return nil
+}
+
+// TableExists is false for a site added to the registry after the database was made, until its table is created
+func (s MappingSite) TableExists() (bool, error) {
+	var tables int
+	err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", s.Table).Scan(&tables)
+	if err != nil {
+		return false, fmt.Errorf("looking up %s: %w", s.Table, err)
+	}
+	return tables > 0, nil
+}
+
+// Condition on the UUID column of table leaving out manga which were deleted or merged on MangaDex
+// Mapped uuids without a manga row are kept, like GetAllManga keeps rows it can't read
+func notTombstoned(table string) string {
+	return "NOT EXISTS (SELECT 1 FROM " + TableManga + " WHERE " + TableManga + ".UUID = " + table + ".UUID" +
+		" AND json_valid(" + TableManga + ".JSON) AND json_extract(" + TableManga + ".JSON, '$.tombstonedAt') IS NOT NULL)"
+}
+
+// LookupMangaIds returns the uuids of the MangaDex manga the id of the site is mapped to, the id is normalised first
+// Tombstoned manga are left out, so an id is only resolved to manga which are still on MangaDex
+func (s MappingSite) LookupMangaIds(id string) ([]string, error) {
+	rows, err := DB.Query("SELECT UUID FROM "+s.Table+" WHERE ID = ? AND "+notTombstoned(s.Table)+" ORDER BY UUID ASC", s.NormaliseId(id))
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s id %s: %w", s.Key, id, err)
+	}
+	defer rows.Close()
+
+	var mangaIds []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, fmt.Errorf("looking up %s id %s: %w", s.Key, id, err)
+		}
+		mangaIds = append(mangaIds, uuid)
+	}
+	return mangaIds, rows.Err()
+}
+
+// GetMangaMappings returns the id of every site the MangaDex manga is mapped to, keyed by the site key
+// A tombstoned manga has none
+func GetMangaMappings(uuid string) (map[string]string, error) {
+	mappings := map[string]string{}
+	for _, site := range MappingSites {
+		var id string
+		err := DB.QueryRow("SELECT ID FROM "+site.Table+" WHERE UUID = ? AND "+notTombstoned(site.Table), uuid).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s id of %s: %w", site.Key, uuid, err)
+		}
+		mappings[site.Key] = id
+	}
+	return mappings, nil
+}
+
+// NormaliseStoredIds normalises the ids already in the table of the site, as stored before ids were normalised
+// Ids the site can't normalise are deleted, the RAW column keeps the id as it was stored
+func (s MappingSite) NormaliseStoredIds() (updated int, deleted int, err error) {
+	if s.Normalise == nil {
+		return 0, 0, nil
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT UUID, ID FROM " + s.Table)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading %s: %w", s.Table, err)
+	}
+	stored := map[string]string{}
+	for rows.Next() {
+		var uuid, id string
+		if err := rows.Scan(&uuid, &id); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("reading %s: %w", s.Table, err)
+		}
+		stored[uuid] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("reading %s: %w", s.Table, err)
+	}
+
+	for uuid, id := range stored {
+		normalised, err := s.Normalise(id)
+		if err != nil {
+			if _, err := tx.Exec("DELETE FROM "+s.Table+" WHERE UUID = ?", uuid); err != nil {
+				return 0, 0, fmt.Errorf("deleting %s id of %s: %w", s.Table, uuid, err)
+			}
+			deleted++
+			continue
+		}
+		if normalised == id {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE "+s.Table+" SET ID = ?, RAW = COALESCE(RAW, ID) WHERE UUID = ?", normalised, uuid); err != nil {
+			return 0, 0, fmt.Errorf("normalising %s id of %s: %w", s.Table, uuid, err)
+		}
+		updated++
+	}
+	return updated, deleted, tx.Commit()
+}
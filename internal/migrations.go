@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are the sql files under migrations/<set>/ named <version>_<name>.sql, applied in version order
+// Each is applied in its own transaction together with its row in the schema_version table
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	MigrationsData = "data"
+	MigrationsNeko = "neko"
+)
+
+const TableSchemaVersion = "schema_version"
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// GetMigrations returns the migrations of a set, data for data.db and neko for the neko mapping database
+func GetMigrations(set string) ([]Migration, error) {
+	dir := path.Join("migrations", set)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no %s migrations: %w", set, err)
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		versionText, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".sql") {
+			return nil, fmt.Errorf("migration %s/%s isn't named <version>_<name>.sql", set, entry.Name())
+		}
+		contents, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(contents)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migrations[i-1].Name, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion is the version of the last migration applied to db, 0 if none were
+func SchemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", TableSchemaVersion).Scan(&tables)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	err = db.QueryRow("SELECT MAX(version) FROM " + TableSchemaVersion).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// LatestSchemaVersion is the version a database of the set has once every migration is applied
+func LatestSchemaVersion(set string) (int, error) {
+	migrations, err := GetMigrations(set)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// CheckSchema returns an error if DBFile exists but is missing migrations
+func CheckSchema() error {
+	if _, err := os.Stat(DBFile); err != nil {
+		return nil
+	}
+	version, err := SchemaVersion(DB)
+	if err != nil {
+		return err
+	}
+	latest, err := LatestSchemaVersion(MigrationsData)
+	if err != nil {
+		return err
+	}
+	if version < latest {
+		return fmt.Errorf("%s is at schema version %d but %d is needed, upgrade it with ./similar init --migrate-only", DBFile, version, latest)
+	}
+	return nil
+}
+
+// Migrate applies the migrations of the set which db doesn't have yet, returning the ones it applied
+// A migration which fails is rolled back, leaving db at the version before it
+func Migrate(db *sql.DB, set string) ([]Migration, error) {
+	migrations, err := GetMigrations(set)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + TableSchemaVersion + " (version INTEGER PRIMARY KEY NOT NULL, name TEXT NOT NULL, applied_at TEXT NOT NULL)")
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", TableSchemaVersion, err)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, migration); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+	appliedAt := strings.Split(time.Now().UTC().Format(time.RFC3339), "Z")[0]
+	_, err = tx.Exec("INSERT INTO "+TableSchemaVersion+" (version, name, applied_at) VALUES (?, ?, ?)", migration.Version, migration.Name, appliedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,11 @@
+-- Tables of the original data.db, "IF NOT EXISTS" so databases made before migrations upgrade in place
+CREATE TABLE IF NOT EXISTS MANGA(UUID TEXT PRIMARY KEY, DATE TEXT, JSON TEXT);
+CREATE TABLE IF NOT EXISTS SIMILAR(UUID TEXT PRIMARY KEY, JSON TEXT);
+CREATE TABLE IF NOT EXISTS MANGAUPDATES_OLD(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS MANGAUPDATES_NEW(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS ANILIST(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS MYANIMELIST(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS NOVEL_UPDATES(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS KITSU(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS BOOK_WALKER(UUID TEXT PRIMARY KEY, ID TEXT);
+CREATE TABLE IF NOT EXISTS ANIME_PLANET(UUID TEXT PRIMARY KEY, ID TEXT);
@@ -0,0 +1,2 @@
+-- Tag catalogue synced by "mangadex tags", it used to be created the first time it was read
+CREATE TABLE IF NOT EXISTS TAG(UUID TEXT PRIMARY KEY NOT NULL, NAME TEXT NOT NULL, TAG_GROUP TEXT NOT NULL, JSON TEXT NOT NULL);
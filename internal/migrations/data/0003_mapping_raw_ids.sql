@@ -0,0 +1,16 @@
+-- The ids of the mapping tables are normalised, RAW keeps the link as MangaDex has it
+ALTER TABLE MANGAUPDATES_OLD ADD COLUMN RAW TEXT;
+ALTER TABLE MANGAUPDATES_NEW ADD COLUMN RAW TEXT;
+ALTER TABLE ANILIST ADD COLUMN RAW TEXT;
+ALTER TABLE MYANIMELIST ADD COLUMN RAW TEXT;
+ALTER TABLE NOVEL_UPDATES ADD COLUMN RAW TEXT;
+ALTER TABLE KITSU ADD COLUMN RAW TEXT;
+ALTER TABLE BOOK_WALKER ADD COLUMN RAW TEXT;
+ALTER TABLE ANIME_PLANET ADD COLUMN RAW TEXT;
+UPDATE MANGAUPDATES_OLD SET RAW = ID;
+UPDATE ANILIST SET RAW = ID;
+UPDATE MYANIMELIST SET RAW = ID;
+UPDATE NOVEL_UPDATES SET RAW = ID;
+UPDATE KITSU SET RAW = ID;
+UPDATE BOOK_WALKER SET RAW = ID;
+UPDATE ANIME_PLANET SET RAW = ID;
@@ -0,0 +1,2 @@
+-- Mapping ids of each MangaDex manga read by the Neko app, one column per site
+CREATE TABLE IF NOT EXISTS mappings(mdex TEXT PRIMARY KEY, al TEXT, ap TEXT, bw TEXT, mu TEXT, mu_new TEXT, nu TEXT, kt TEXT, mal TEXT);
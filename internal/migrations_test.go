@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func migrateToLatest(t *testing.T, db *sql.DB, set string) {
+	t.Helper()
+	migrations, err := GetMigrations(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := Migrate(db, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d of the %d %s migrations", len(applied), len(migrations), set)
+	}
+	latest, err := LatestSchemaVersion(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != latest {
+		t.Errorf("%s schema version is %d %v, want %d", set, version, err, latest)
+	}
+
+	// Migrating again finds nothing left to apply
+	applied, err = Migrate(db, set)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("migrating an up-to-date database applied %v %v", applied, err)
+	}
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	for _, set := range []string{MigrationsData, MigrationsNeko} {
+		db := openTestDB(t)
+		if version, err := SchemaVersion(db); err != nil || version != 0 {
+			t.Errorf("%s schema version of an empty database is %d %v, want 0", set, version, err)
+		}
+		migrateToLatest(t, db, set)
+	}
+}
+
+// Databases made before there were migrations have the original tables and no schema_version
+func TestMigrateDatabaseFromBeforeMigrations(t *testing.T) {
+	db := openTestDB(t)
+	for _, table := range []string{TableMangaupdates, TableMangaupdatesNewId, TableAnilist, TableMyanimelist, TableNovelUpdates, TableKitsu, TableBookWalker, TableAnimePlanet} {
+		if _, err := db.Exec("CREATE TABLE " + table + "(UUID TEXT PRIMARY KEY, ID TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statements := []string{
+		"CREATE TABLE " + TableManga + "(UUID TEXT PRIMARY KEY, DATE TEXT, JSON TEXT)",
+		"CREATE TABLE " + TableSimilar + "(UUID TEXT PRIMARY KEY, JSON TEXT)",
+		"INSERT INTO " + TableManga + " VALUES ('uuid', '2021-01-01', '{}')",
+		"INSERT INTO " + TableAnilist + " VALUES ('uuid', '30013')",
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrateToLatest(t, db, MigrationsData)
+
+	var mangaCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + TableManga).Scan(&mangaCount); err != nil || mangaCount != 1 {
+		t.Errorf("got %d manga %v after migrating, want the 1 from before", mangaCount, err)
+	}
+	var id, raw string
+	if err := db.QueryRow("SELECT ID, RAW FROM "+TableAnilist+" WHERE UUID = ?", "uuid").Scan(&id, &raw); err != nil || id != "30013" || raw != "30013" {
+		t.Errorf("got AniList id %q raw %q %v after migrating, want 30013 for both", id, raw, err)
+	}
+}
@@ -7,4 +7,6 @@ import (
 type DbGeneric struct {
 	UUID string
 	ID   string
+	// The link as MangaDex has it, before ID was normalised
+	RAW string
 }
@@ -0,0 +1,12 @@
+package internal
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type DbTag struct {
+	Id    string
+	Name  string
+	Group string
+	JSON  string
+}
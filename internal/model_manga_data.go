@@ -5,17 +5,40 @@ type Manga struct {
 	Title                        *map[string]string  `json:"title,omitempty"`
 	AltTitles                    []map[string]string `json:"altTitles,omitempty"`
 	LastChapter                  string              `json:"lastChapter,omitempty"`
+	LastVolume                   string              `json:"lastVolume,omitempty"`
+	Status                       string              `json:"status,omitempty"`
+	Year                         int32               `json:"year,omitempty"`
 	AvailableTranslatedLanguages []string            `json:"availableTranslatedLanguages,omitempty"`
 	RelatedIds                   []string            `json:"relatedIds,omitempty"`
+	Relations                    []Relation          `json:"relations,omitempty"`
 	Description                  *map[string]string  `json:"description,omitempty"`
 	Links                        map[string]string   `json:"links,omitempty"`
 	OriginalLanguage             string              `json:"originalLanguage,omitempty"`
 	PublicationDemographic       string              `json:"publicationDemographic,omitempty"`
 	ContentRating                string              `json:"contentRating,omitempty"`
 	Tags                         []Tag               `json:"tags,omitempty"`
+	Authors                      []Creator           `json:"authors,omitempty"`
+	Artists                      []Creator           `json:"artists,omitempty"`
+	CreatedAt                    string              `json:"createdAt,omitempty"`
+	UpdatedAt                    string              `json:"updatedAt,omitempty"`
+	// Set once the manga is no longer on MangaDex, it was deleted or merged into another manga
+	TombstonedAt string `json:"tombstonedAt,omitempty"`
 }
 
 type Tag struct {
-	Id   string             `json:"id,omitempty"`
-	Name *map[string]string `json:"name,omitempty"`
+	Id    string             `json:"id,omitempty"`
+	Name  *map[string]string `json:"name,omitempty"`
+	Group string             `json:"group,omitempty"`
+}
+
+// Creator is an author or artist of a manga
+type Creator struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Relation is a related manga and how it is related, e.g. "sequel", "spin_off" or "doujinshi"
+type Relation struct {
+	Id   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
 }
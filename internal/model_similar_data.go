@@ -5,7 +5,9 @@ type SimilarManga struct {
 	Title          map[string]string `json:"title,omitempty"`
 	ContentRating  string            `json:"contentRating,omitempty"`
 	SimilarMatches []SimilarMatch    `json:"matches,omitempty"`
+	RelatedMatches []SimilarMatch    `json:"relatedMatches,omitempty"`
 	UpdatedAt      string            `json:"updatedAt,omitempty"`
+	ConfigHash     string            `json:"configHash,omitempty"`
 }
 
 type SimilarMatch struct {
@@ -14,4 +16,5 @@ type SimilarMatch struct {
 	ContentRating string            `json:"contentRating,omitempty"`
 	Score         float32           `json:"score,omitempty"`
 	Languages     []string          `json:"languages,omitempty"`
+	Relation      string            `json:"relation,omitempty"`
 }
@@ -256,6 +256,11 @@ func (c *APIClient) prepareRequest(
 		localVarRequest.Header.Add(header, value)
 	}
 
+	// Cancelling the context cancels the request and any rate limit waits
+	if ctx != nil {
+		localVarRequest = localVarRequest.WithContext(ctx)
+	}
+
 	return localVarRequest, nil
 }
 
@@ -20,6 +20,8 @@ type MangaApiGetSearchMangaOpts struct {
 	Offset         optional.Int32
 	Ids            optional.Interface
 	OrderCreatedAt optional.String
+	OrderUpdatedAt optional.String
+	CreatedAtSince optional.String
 	UpdatedAtSince optional.String
 }
 
@@ -43,11 +45,21 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 	localVarQueryParams.Add("contentRating[]", parameterToString("suggestive", ""))
 	localVarQueryParams.Add("contentRating[]", parameterToString("erotica", ""))
 	localVarQueryParams.Add("contentRating[]", parameterToString("pornographic", ""))
+	localVarQueryParams.Add("includes[]", parameterToString("author", ""))
+	localVarQueryParams.Add("includes[]", parameterToString("artist", ""))
 
 	if localVarOptionals != nil && localVarOptionals.OrderCreatedAt.IsSet() {
 		localVarQueryParams.Add("order[createdAt]", parameterToString(localVarOptionals.OrderCreatedAt.Value(), ""))
 	}
 
+	if localVarOptionals != nil && localVarOptionals.OrderUpdatedAt.IsSet() {
+		localVarQueryParams.Add("order[updatedAt]", parameterToString(localVarOptionals.OrderUpdatedAt.Value(), ""))
+	}
+
+	if localVarOptionals != nil && localVarOptionals.CreatedAtSince.IsSet() {
+		localVarQueryParams.Add("createdAtSince", parameterToString(localVarOptionals.CreatedAtSince.Value(), ""))
+	}
+
 	if localVarOptionals != nil && localVarOptionals.UpdatedAtSince.IsSet() {
 		localVarQueryParams.Add("updatedAtSince", parameterToString(localVarOptionals.UpdatedAtSince.Value(), ""))
 	}
@@ -100,10 +112,6 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 	if err != nil {
 		return localVarReturnValue, localVarHttpResponse, err
 	}
-	//fmt.Println(localVarHttpResponse.Header)
-	//fmt.Println("X-RateLimit-Limit: " + localVarHttpResponse.Header.Get("X-RateLimit-Limit"))
-	//fmt.Println("X-RateLimit-Remaining: " + localVarHttpResponse.Header.Get("X-RateLimit-Remaining"))
-	//fmt.Println("X-RateLimit-Retry-After: " + localVarHttpResponse.Header.Get("X-RateLimit-Retry-After"))
 
 	if localVarHttpResponse.StatusCode < 300 {
 		// If we succeed, return the data, otherwise pass on to decode error.
@@ -143,3 +151,82 @@ func (a *MangaApiService) GetSearchManga(ctx context.Context, localVarOptionals
 
 	return localVarReturnValue, localVarHttpResponse, nil
 }
+
+func (a *MangaApiService) GetMangaTag(ctx context.Context) (TagResponse, *http.Response, error) {
+	var (
+		localVarHttpMethod  = strings.ToUpper("Get")
+		localVarPostBody    interface{}
+		localVarFileName    string
+		localVarFileBytes   []byte
+		localVarReturnValue TagResponse
+	)
+
+	// create path and map variables
+	localVarPath := a.client.cfg.BasePath + "/manga/tag"
+
+	localVarHeaderParams := make(map[string]string)
+	localVarQueryParams := url.Values{}
+	localVarFormParams := url.Values{}
+
+	// to determine the Content-Type header
+	localVarHttpContentTypes := []string{}
+
+	// set Content-Type header
+	localVarHttpContentType := selectHeaderContentType(localVarHttpContentTypes)
+	if localVarHttpContentType != "" {
+		localVarHeaderParams["Content-Type"] = localVarHttpContentType
+	}
+
+	// to determine the Accept header
+	localVarHttpHeaderAccepts := []string{"application/json"}
+
+	// set Accept header
+	localVarHttpHeaderAccept := selectHeaderAccept(localVarHttpHeaderAccepts)
+	if localVarHttpHeaderAccept != "" {
+		localVarHeaderParams["Accept"] = localVarHttpHeaderAccept
+	}
+	r, err := a.client.prepareRequest(ctx, localVarPath, localVarHttpMethod, localVarPostBody, localVarHeaderParams, localVarQueryParams, localVarFormParams, localVarFileName, localVarFileBytes)
+
+	if err != nil {
+		return localVarReturnValue, nil, err
+	}
+
+	localVarHttpResponse, err := a.client.callAPI(r)
+	if err != nil || localVarHttpResponse == nil {
+		return localVarReturnValue, localVarHttpResponse, err
+	}
+
+	localVarBody, err := io.ReadAll(localVarHttpResponse.Body)
+	localVarHttpResponse.Body.Close()
+	if err != nil {
+		return localVarReturnValue, localVarHttpResponse, err
+	}
+
+	if localVarHttpResponse.StatusCode < 300 {
+		// If we succeed, return the data, otherwise pass on to decode error.
+		err = a.client.decode(&localVarReturnValue, localVarBody, localVarHttpResponse.Header.Get("Content-Type"))
+		if err == nil {
+			return localVarReturnValue, localVarHttpResponse, err
+		}
+	}
+
+	if localVarHttpResponse.StatusCode >= 300 {
+		newErr := GenericSwaggerError{
+			body:  localVarBody,
+			error: localVarHttpResponse.Status,
+		}
+		if localVarHttpResponse.StatusCode == 400 {
+			var v ErrorResponse
+			err = a.client.decode(&v, localVarBody, localVarHttpResponse.Header.Get("Content-Type"))
+			if err != nil {
+				newErr.error = err.Error()
+				return localVarReturnValue, localVarHttpResponse, newErr
+			}
+			newErr.model = v
+			return localVarReturnValue, localVarHttpResponse, newErr
+		}
+		return localVarReturnValue, localVarHttpResponse, newErr
+	}
+
+	return localVarReturnValue, localVarHttpResponse, err
+}
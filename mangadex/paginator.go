@@ -0,0 +1,167 @@
+package mangadex
+
+import (
+	"context"
+	"fmt"
+	"github.com/antihax/optional"
+	"strings"
+)
+
+// MaxSearchOffset is the largest offset + limit the manga search accepts
+const MaxSearchOffset = 10000
+
+// PaginateField is the timestamp a MangaPaginator orders the manga by
+type PaginateField string
+
+const (
+	PaginateCreatedAt PaginateField = "createdAt"
+	PaginateUpdatedAt PaginateField = "updatedAt"
+)
+
+// MangaPaginator iterates over every manga of a search in ascending createdAt or updatedAt order.
+// Offsets past MaxSearchOffset are refused by the API, so once a window of results reaches the cap
+// a new window is started at the timestamp of the last manga seen using createdAtSince / updatedAtSince.
+// Manga sharing that timestamp are returned by both windows, they are only returned once.
+//
+//	paginator := mangadex.NewMangaPaginator(client, mangadex.PaginateUpdatedAt, since, opts)
+//	for paginator.Next(ctx) {
+//		manga := paginator.Manga()
+//	}
+//	if err := paginator.Err(); err != nil {
+type MangaPaginator struct {
+	client *APIClient
+	field  PaginateField
+	opts   MangaApiGetSearchMangaOpts
+	limit  int32
+
+	windowSince string
+	offset      int32
+	page        []Manga
+	lastSeen    string
+	seenAtLast  map[string]bool
+	exhausted   bool
+
+	current Manga
+	err     error
+}
+
+// NewMangaPaginator starts at since, a "2006-01-02T15:04:05" timestamp or empty for the whole catalogue
+// The order, offset and since fields of opts are managed by the paginator
+func NewMangaPaginator(client *APIClient, field PaginateField, since string, opts MangaApiGetSearchMangaOpts) *MangaPaginator {
+	limit := int32(100)
+	if opts.Limit.IsSet() {
+		limit = opts.Limit.Value()
+	}
+	return &MangaPaginator{
+		client:      client,
+		field:       field,
+		opts:        opts,
+		limit:       limit,
+		windowSince: searchTimestamp(since),
+		seenAtLast:  map[string]bool{},
+	}
+}
+
+// Next advances to the next manga, returning false when there are none left or a request failed
+func (p *MangaPaginator) Next(ctx context.Context) bool {
+	for p.err == nil {
+		for len(p.page) > 0 {
+			manga := p.page[0]
+			p.page = p.page[1:]
+
+			timestamp := searchTimestamp(p.timestamp(manga))
+			if timestamp != p.lastSeen {
+				p.lastSeen = timestamp
+				p.seenAtLast = map[string]bool{}
+			}
+			if p.seenAtLast[manga.Id] {
+				continue
+			}
+			p.seenAtLast[manga.Id] = true
+			p.current = manga
+			return true
+		}
+		if p.exhausted {
+			return false
+		}
+		p.fetchPage(ctx)
+	}
+	return false
+}
+
+// Manga is the current manga of the iteration
+func (p *MangaPaginator) Manga() Manga {
+	return p.current
+}
+
+// Err is the error which stopped the iteration, if any
+func (p *MangaPaginator) Err() error {
+	return p.err
+}
+
+func (p *MangaPaginator) fetchPage(ctx context.Context) {
+
+	// Re-slice into a new window once the current one reaches the offset cap
+	if p.offset+p.limit > MaxSearchOffset {
+		if p.lastSeen == "" || p.lastSeen == p.windowSince {
+			p.err = fmt.Errorf("more than %d manga have the %s %s, they can't be paginated", MaxSearchOffset, p.field, p.windowSince)
+			return
+		}
+		p.windowSince = p.lastSeen
+		p.offset = 0
+	}
+
+	opts := p.opts
+	opts.Limit = optional.NewInt32(p.limit)
+	opts.Offset = optional.NewInt32(p.offset)
+	opts.OrderCreatedAt = optional.EmptyString()
+	opts.OrderUpdatedAt = optional.EmptyString()
+	opts.CreatedAtSince = optional.EmptyString()
+	opts.UpdatedAtSince = optional.EmptyString()
+	switch p.field {
+	case PaginateCreatedAt:
+		opts.OrderCreatedAt = optional.NewString("asc")
+		if p.windowSince != "" {
+			opts.CreatedAtSince = optional.NewString(p.windowSince)
+		}
+	case PaginateUpdatedAt:
+		opts.OrderUpdatedAt = optional.NewString("asc")
+		if p.windowSince != "" {
+			opts.UpdatedAtSince = optional.NewString(p.windowSince)
+		}
+	default:
+		p.err = fmt.Errorf("can't paginate by %s", p.field)
+		return
+	}
+
+	mangaList, resp, err := p.client.MangaApi.GetSearchManga(ctx, &opts)
+	if err != nil {
+		if resp != nil {
+			p.err = fmt.Errorf("manga search at %s %s offset %d failed with http code %d: %w", p.field, p.windowSince, p.offset, resp.StatusCode, err)
+		} else {
+			p.err = fmt.Errorf("manga search at %s %s offset %d failed: %w", p.field, p.windowSince, p.offset, err)
+		}
+		return
+	}
+	p.page = mangaList.Data
+	p.offset += int32(len(mangaList.Data))
+	if int32(len(mangaList.Data)) < p.limit {
+		p.exhausted = true
+	}
+}
+
+func (p *MangaPaginator) timestamp(manga Manga) string {
+	if p.field == PaginateCreatedAt {
+		return manga.Attributes.CreatedAt
+	}
+	return manga.Attributes.UpdatedAt
+}
+
+// The since filters take a timestamp without a timezone, e.g. 2021-05-24T17:18:48+00:00 -> 2021-05-24T17:18:48
+func searchTimestamp(timestamp string) string {
+	timestamp = strings.TrimSuffix(timestamp, "Z")
+	if len(timestamp) > len("2006-01-02T15:04:05") {
+		timestamp = timestamp[:len("2006-01-02T15:04:05")]
+	}
+	return timestamp
+}
@@ -0,0 +1,89 @@
+package mangadex_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/antihax/optional"
+	"github.com/similar-manga/similar/internal/fixtures"
+	"github.com/similar-manga/similar/mangadex"
+	"testing"
+	"time"
+)
+
+// One manga a second, except the manga of the shared indexes which all have the timestamp of the first of them
+func paginatorStub(count int, shared ...int) *fixtures.StubData {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	isShared := map[int]bool{}
+	for _, i := range shared {
+		isShared[i] = true
+	}
+	data := &fixtures.StubData{}
+	for i := 0; i < count; i++ {
+		second := i
+		if isShared[i] {
+			second = shared[0]
+		}
+		timestamp := start.Add(time.Duration(second) * time.Second).Format("2006-01-02T15:04:05+00:00")
+		data.Manga = append(data.Manga, mangadex.Manga{
+			Id:         fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			Attributes: &mangadex.MangaAttributes{CreatedAt: timestamp, UpdatedAt: timestamp},
+		})
+	}
+	return data
+}
+
+func paginate(t *testing.T, data *fixtures.StubData, field mangadex.PaginateField) ([]mangadex.Manga, error) {
+	t.Helper()
+	server := fixtures.NewStubServer(data)
+	defer server.Close()
+	cfg := mangadex.NewConfiguration()
+	cfg.BasePath = server.URL
+	client := mangadex.NewAPIClient(cfg)
+
+	opts := mangadex.MangaApiGetSearchMangaOpts{Limit: optional.NewInt32(5000)}
+	paginator := mangadex.NewMangaPaginator(client, field, "", opts)
+	var mangaList []mangadex.Manga
+	for paginator.Next(context.Background()) {
+		mangaList = append(mangaList, paginator.Manga())
+	}
+	return mangaList, paginator.Err()
+}
+
+func TestMangaPaginatorDedupsTheWindowBoundary(t *testing.T) {
+	count := mangadex.MaxSearchOffset + 3
+	boundary := mangadex.MaxSearchOffset - 1
+
+	// The first window ends within the manga sharing a timestamp, the next window starts at it
+	data := paginatorStub(count, boundary-1, boundary, boundary+1)
+	for _, field := range []mangadex.PaginateField{mangadex.PaginateCreatedAt, mangadex.PaginateUpdatedAt} {
+		mangaList, err := paginate(t, data, field)
+		if err != nil {
+			t.Fatalf("%s: %v", field, err)
+		}
+		if len(mangaList) != count {
+			t.Errorf("%s: got %d manga, want %d", field, len(mangaList), count)
+		}
+		seen := map[string]bool{}
+		for i, manga := range mangaList {
+			if seen[manga.Id] {
+				t.Errorf("%s: %s returned twice", field, manga.Id)
+			}
+			seen[manga.Id] = true
+			if i < len(data.Manga) && manga.Id != data.Manga[i].Id {
+				t.Errorf("%s: manga %d is %s, want %s", field, i, manga.Id, data.Manga[i].Id)
+				break
+			}
+		}
+	}
+}
+
+func TestMangaPaginatorFailsWhenAWindowCanNotAdvance(t *testing.T) {
+	count := mangadex.MaxSearchOffset + 1
+	shared := make([]int, count)
+	for i := range shared {
+		shared[i] = i
+	}
+	if _, err := paginate(t, paginatorStub(count, shared...), mangadex.PaginateCreatedAt); err == nil {
+		t.Errorf("paginated %d manga sharing a timestamp without an error", count)
+	}
+}
@@ -0,0 +1,198 @@
+package mangadex
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/ratelimit"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitTransport is a http.RoundTripper which keeps requests under the MangaDex rate limits.
+// It spaces requests out with Limiter, waits when X-RateLimit-Remaining reaches zero until
+// X-RateLimit-Retry-After, and retries rate limited (429), server error and soft rate limited
+// (html instead of json) responses with exponential backoff plus jitter, honouring Retry-After.
+// All waits end early with the error of the request context if it is cancelled.
+type RateLimitTransport struct {
+	Base       http.RoundTripper
+	Limiter    ratelimit.Limiter
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// NoWait still retries but skips every wait, for recorded or stubbed responses where the waits are meaningless
+	NoWait bool
+
+	mutex        sync.Mutex
+	blockedUntil time.Time
+}
+
+// NewRateLimitTransport wraps base, or http.DefaultTransport if nil, with the default limits
+// The default rate is the one request every 2 seconds which paging through the search has always used
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RateLimitTransport{
+		Base:       base,
+		Limiter:    ratelimit.New(1, ratelimit.Per(2*time.Second)),
+		MaxRetries: 10,
+		MinBackoff: time.Second,
+		MaxBackoff: 2 * time.Minute,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	for attempt := 0; ; attempt++ {
+		if !t.NoWait {
+			if err := t.waitUntilUnblocked(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if t.Limiter != nil {
+			t.Limiter.Take()
+			// The limiter can't be interrupted, so a request cancelled while it waited ends here
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Each retry sends a clone with a fresh body, the caller's request is left as it was
+		attemptRequest := request
+		if attempt > 0 {
+			attemptRequest = request.Clone(ctx)
+			if request.Body != nil && request.Body != http.NoBody {
+				if request.GetBody == nil {
+					return nil, fmt.Errorf("can't retry %s %s since its body can't be read again", request.Method, request.URL)
+				}
+				body, err := request.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptRequest.Body = body
+			}
+		}
+
+		response, err := t.Base.RoundTrip(attemptRequest)
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		if response != nil {
+			t.updateRateLimit(response.Header)
+		}
+		if !retryable(response, err) || attempt >= t.MaxRetries {
+			return response, err
+		}
+
+		delay := t.retryDelay(attempt, response)
+		if response != nil {
+			fmt.Printf("\u001B[1;31mMANGADEX ERROR (%d of %d): http code %d, retrying in %s\u001B[0m\n", attempt+1, t.MaxRetries, response.StatusCode, delay.Round(time.Millisecond))
+			// Drain the body so the connection can be re-used
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		} else {
+			fmt.Printf("\u001B[1;31mMANGADEX ERROR (%d of %d): %v, retrying in %s\u001B[0m\n", attempt+1, t.MaxRetries, err, delay.Round(time.Millisecond))
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Blocks every request once the remaining requests of the current window are used up
+func (t *RateLimitTransport) updateRateLimit(header http.Header) {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	retryAfter, err := strconv.ParseInt(header.Get("X-RateLimit-Retry-After"), 10, 64)
+	if err != nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	until := time.Unix(retryAfter, 0)
+	if until.After(t.blockedUntil) {
+		t.blockedUntil = until
+	}
+}
+
+func (t *RateLimitTransport) waitUntilUnblocked(ctx context.Context) error {
+	t.mutex.Lock()
+	wait := time.Until(t.blockedUntil)
+	t.mutex.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	fmt.Printf("Rate limit window used up, waiting %s\n", wait.Round(time.Millisecond))
+	return sleepContext(ctx, wait)
+}
+
+// Wait before the retry after attempt, the delay the response asks for if it has one, otherwise the backoff
+func (t *RateLimitTransport) retryDelay(attempt int, response *http.Response) time.Duration {
+	if t.NoWait {
+		return 0
+	}
+	if response != nil {
+		if retryAfter, ok := retryAfterDelay(response.Header); ok && retryAfter > 0 {
+			return retryAfter
+		}
+	}
+	return t.backoff(attempt)
+}
+
+// Exponential backoff with jitter, a random delay between half and all of MinBackoff * 2^attempt
+func (t *RateLimitTransport) backoff(attempt int) time.Duration {
+	delay := t.MaxBackoff
+	if attempt < 30 && t.MinBackoff<<attempt < t.MaxBackoff {
+		delay = t.MinBackoff << attempt
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func retryable(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return true
+	}
+	// MangaDex answers with an html page instead of json when it soft rate limits us
+	return response.StatusCode < 300 && strings.HasPrefix(response.Header.Get("Content-Type"), "text/html")
+}
+
+// Delay requested by the Retry-After (seconds or a http date) or X-RateLimit-Retry-After (unix time) headers
+func retryAfterDelay(header http.Header) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return time.Until(date), true
+		}
+	}
+	if value := header.Get("X-RateLimit-Retry-After"); value != "" {
+		if retryAfter, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Until(time.Unix(retryAfter, 0)), true
+		}
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package mangadex
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers each request with the next of the responses, the last one is repeated, and records the request bodies
+type scriptedServer struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	bodies []string
+}
+
+type scriptedResponse struct {
+	status      int
+	contentType string
+	header      http.Header
+}
+
+func newScriptedServer(t *testing.T, responses ...scriptedResponse) *scriptedServer {
+	server := &scriptedServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		server.mutex.Lock()
+		server.bodies = append(server.bodies, string(body))
+		response := responses[len(responses)-1]
+		if len(server.bodies) <= len(responses) {
+			response = responses[len(server.bodies)-1]
+		}
+		server.mutex.Unlock()
+		for key, values := range response.header {
+			w.Header()[key] = values
+		}
+		contentType := response.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(response.status)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *scriptedServer) requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func testTransport() *RateLimitTransport {
+	return &RateLimitTransport{Base: http.DefaultTransport, MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		header http.Header
+		ok     bool
+		delay  time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"3"}}, true, 3 * time.Second},
+		{"http date", http.Header{"Retry-After": {now.Add(10 * time.Second).UTC().Format(http.TimeFormat)}}, true, 10 * time.Second},
+		{"rate limit unix time", http.Header{"X-Ratelimit-Retry-After": {strconv.FormatInt(now.Add(5*time.Second).Unix(), 10)}}, true, 5 * time.Second},
+		{"retry after over the rate limit header", http.Header{"Retry-After": {"1"}, "X-Ratelimit-Retry-After": {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}}, true, time.Second},
+		{"malformed", http.Header{"Retry-After": {"soon"}}, false, 0},
+		{"none", http.Header{}, false, 0},
+	}
+	for _, test := range tests {
+		delay, ok := retryAfterDelay(test.header)
+		if ok != test.ok {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		// Dates only have a precision of seconds
+		if diff := delay - test.delay; diff < -1500*time.Millisecond || diff > 1500*time.Millisecond {
+			t.Errorf("%s: got delay %s, want about %s", test.name, delay, test.delay)
+		}
+	}
+}
+
+func TestBackoffStaysWithinBoundsWithJitter(t *testing.T) {
+	transport := &RateLimitTransport{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 0; attempt < 70; attempt++ {
+		ceiling := transport.MaxBackoff
+		if attempt < 4 {
+			ceiling = transport.MinBackoff << attempt
+		}
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 50; i++ {
+			delay := transport.backoff(attempt)
+			if delay < ceiling/2 || delay > ceiling {
+				t.Fatalf("attempt %d: backoff %s outside [%s, %s]", attempt, delay, ceiling/2, ceiling)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: backoff has no jitter, always %v", attempt, seen)
+		}
+	}
+
+	transport.NoWait = true
+	if delay := transport.retryDelay(5, &http.Response{Header: http.Header{"Retry-After": {"60"}}}); delay != 0 {
+		t.Errorf("NoWait waits %s", delay)
+	}
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	ok := scriptedResponse{status: http.StatusOK}
+	tests := []struct {
+		name      string
+		responses []scriptedResponse
+		requests  int
+		status    int
+	}{
+		{"rate limited", []scriptedResponse{{status: http.StatusTooManyRequests}, ok}, 2, http.StatusOK},
+		{"server errors", []scriptedResponse{{status: http.StatusBadGateway}, {status: http.StatusServiceUnavailable}, ok}, 3, http.StatusOK},
+		{"soft rate limited html", []scriptedResponse{{status: http.StatusOK, contentType: "text/html; charset=utf-8"}, ok}, 2, http.StatusOK},
+		{"client errors are not retried", []scriptedResponse{{status: http.StatusNotFound}, ok}, 1, http.StatusNotFound},
+		{"gives up after the retries", []scriptedResponse{{status: http.StatusInternalServerError}}, 4, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		server := newScriptedServer(t, test.responses...)
+		transport := testTransport()
+		request, _ := http.NewRequest("POST", server.URL, strings.NewReader("payload"))
+		originalBody := request.Body
+		response, err := transport.RoundTrip(request)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+		requests := server.requests()
+		if len(requests) != test.requests {
+			t.Errorf("%s: got %d requests, want %d", test.name, len(requests), test.requests)
+		}
+		// Every retry sends the whole body again, from a clone rather than the caller's request
+		for i, body := range requests {
+			if body != "payload" {
+				t.Errorf("%s: request %d had body %q", test.name, i, body)
+			}
+		}
+		if request.Body != originalBody {
+			t.Errorf("%s: the body of the caller's request was replaced", test.name)
+		}
+	}
+}
+
+func TestRoundTripBlocksOnceTheRateLimitIsUsedUp(t *testing.T) {
+	retryAfter := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	server := newScriptedServer(t, scriptedResponse{status: http.StatusOK, header: http.Header{
+		"X-Ratelimit-Remaining":   {"0"},
+		"X-Ratelimit-Retry-After": {retryAfter},
+	}})
+	transport := testTransport()
+
+	request, _ := http.NewRequest("GET", server.URL, nil)
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	// The next request waits for the window to reset, so it runs into its deadline instead
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = transport.RoundTrip(request.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline to be exceeded while blocked", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled wait took %s", elapsed)
+	}
+	if got := len(server.requests()); got != 1 {
+		t.Errorf("got %d requests, want only the one before the block", got)
+	}
+
+	// NoWait ignores the block
+	transport.NoWait = true
+	response, err = transport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if got := len(server.requests()); got != 2 {
+		t.Errorf("got %d requests, want 2 with NoWait", got)
+	}
+}
+
+// Cancels the request context while the request waits its turn
+type cancellingLimiter struct {
+	cancel context.CancelFunc
+}
+
+func (l cancellingLimiter) Take() time.Time {
+	l.cancel()
+	return time.Now()
+}
+
+func TestRoundTripCancellation(t *testing.T) {
+	server := newScriptedServer(t, scriptedResponse{status: http.StatusInternalServerError})
+
+	// Cancelled while waiting on the limiter, the request is never sent
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := testTransport()
+	transport.Limiter = cancellingLimiter{cancel}
+	request, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := transport.RoundTrip(request); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the request cancelled", err)
+	}
+	if got := len(server.requests()); got != 0 {
+		t.Errorf("got %d requests after cancelling in the limiter, want none", got)
+	}
+
+	// Cancelled during the backoff before a retry
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	transport = testTransport()
+	transport.MinBackoff = time.Hour
+	transport.MaxBackoff = time.Hour
+	request, _ = http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	start := time.Now()
+	if _, err := transport.RoundTrip(request); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline to be exceeded during the backoff", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled backoff took %s", elapsed)
+	}
+	if got := len(server.requests()); got != 1 {
+		t.Errorf("got %d requests, want 1 before the backoff", got)
+	}
+}